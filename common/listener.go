@@ -69,6 +69,11 @@ func (l *ListenerService) process(c net.Conn) error {
 		return fmt.Errorf("invalid magic bytes")
 	}
 
+	if err := protocol.CheckSelfConnection(handshakeMsg, l.localNode.State().PrivateIP.To4()); err != nil {
+		protocol.EncodeAndWrite(c, protocol.NewRejectMessage(protocol.OkRejectedSelf))
+		return err
+	}
+
 	l.logger.Debug("maginc is correct, replying...")
 
 	if err := protocol.WriteEncodeOk(c); err != nil {
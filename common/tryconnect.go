@@ -44,7 +44,8 @@ func TryConnect(h string, networkSecret *secure.NetworkSecret, ln *LocalNode) (*
 	rn.conn = conn
 	rn.sessionKey = RandomBytes(16)
 
-	if err := protocol.WriteEncodeHandshake(rn.conn, rn.sessionKey, networkSecret); err != nil {
+	nodeID := protocol.HandshakeField{Tag: protocol.HandshakeNodeID, Value: ln.State().PrivateIP.To4()}
+	if err := protocol.WriteEncodeHandshake(rn.conn, rn.sessionKey, networkSecret, nodeID); err != nil {
 		return nil, err
 	}
 	if _, okError := protocol.ReadDecodeOk(rn.conn); okError != nil {
@@ -52,6 +52,19 @@ func (c *ch) Debug(format string, v ...interface{}) {
 	c.log(LevelDebug, format, v...)
 }
 
+func (c *ch) Event(level int, msg string, fields Fields) {
+	if c.Level() < level {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.formatter.(EventFormatter); ok {
+		f.FormatEvent(c.out, level, c.name, msg, fields)
+		return
+	}
+	c.formatter.Format(c.out, level, c.name, msg+fields.String())
+}
+
 func (c *ch) SetLevel(level int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -24,9 +24,26 @@ type (
 		Formatter() Formatter
 	}
 
+	// EventLogger is implemented by loggers that keep the fields of an
+	// event apart, like the channels returned by L. See LogEvent.
+	EventLogger interface {
+		// Event logs msg with structured fields at level.
+		Event(level int, msg string, fields Fields)
+	}
+
 	Formatter interface {
 		Format(out io.Writer, level int, channel string, msg string)
 	}
+
+	// EventFormatter is implemented by formatters that keep the fields of
+	// an event apart, e.g. to emit JSON. Other formatters get the fields
+	// appended to the message as key=value pairs.
+	EventFormatter interface {
+		FormatEvent(out io.Writer, level int, channel string, msg string, fields Fields)
+	}
+
+	// Fields are the structured attributes of an event.
+	Fields map[string]interface{}
 )
 
 var (
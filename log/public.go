@@ -53,3 +53,30 @@ func SetLevel(level int) {
 		log.SetLevel(level)
 	}
 }
+
+// LogEvent logs msg with structured fields at level on l. Loggers that are
+// no EventLogger get the fields appended to the message as key=value pairs.
+func LogEvent(l Logger, level int, msg string, fields Fields) {
+	if el, ok := l.(EventLogger); ok {
+		el.Event(level, msg, fields)
+		return
+	}
+	if l.Level() < level {
+		return
+	}
+	msg += fields.String()
+	switch level {
+	case LevelPanic:
+		l.Panic("%s", msg)
+	case LevelFatal:
+		l.Fatal("%s", msg)
+	case LevelError:
+		l.Error("%s", msg)
+	case LevelWarning:
+		l.Warning("%s", msg)
+	case LevelInfo:
+		l.Info("%s", msg)
+	default:
+		l.Debug("%s", msg)
+	}
+}
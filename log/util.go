@@ -1,5 +1,11 @@
 package log
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 func itoa(buf *[]byte, i int, wid int) {
 	var b [20]byte
 	bp := len(b) - 1
@@ -13,3 +19,18 @@ func itoa(buf *[]byte, i int, wid int) {
 	b[bp] = byte('0' + i)
 	*buf = append(*buf, b[bp:]...)
 }
+
+// String formats the fields as " key=value" pairs sorted by key.
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, f[key])
+	}
+	return b.String()
+}
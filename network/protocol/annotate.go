@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// FieldSpan names the bytes [Start, End) of an encoded packet.
+type FieldSpan struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// DecodeAnnotated decodes the packet in data like DecodeString and maps its
+// bytes to the fields they belong to, for hex-dump inspectors. The spans
+// are in order and cover data without gaps: "length", "version", "flags",
+// "reserved", "type", "priority", "source", "destination", "sent", "id",
+// "vector", "message", "tag", "checksum" and "trailing" for bytes past the
+// frame, each present only when the packet has it. The tag is split off
+// the message when key is set and the type is sealed. The spans follow the
+// header even when decoding fails, which is where they are most useful.
+func DecodeAnnotated(data, key []byte) (*Packet, []FieldSpan, error) {
+	pack, err := decode(bytes.NewReader(data), decodeOptions{key: key})
+	return pack, annotate(data, key != nil), err
+}
+
+func annotate(data []byte, sealed bool) []FieldSpan {
+	var (
+		spans []FieldSpan
+		off   int
+		limit = len(data)
+	)
+	add := func(name string, n int) {
+		end := min(off+n, limit)
+		if end > off {
+			spans = append(spans, FieldSpan{Name: name, Start: off, End: end})
+			off = end
+		}
+	}
+
+	add("length", 2)
+	add("version", 1)
+	if off < 3 {
+		return spans
+	}
+	head := Header{
+		Length:  binary.BigEndian.Uint16(data),
+		Version: data[2],
+	}
+	if head.Version >= FlagsVersion {
+		add("flags", 1)
+		if len(data) > 3 {
+			head.Flags = data[3]
+		}
+	}
+	if head.Version >= ReservedVersion {
+		add("reserved", 1)
+	}
+	limit = min(int(head.Len())+int(head.Length), len(data))
+
+	t := uint8(0)
+	if off < limit {
+		t = data[off]
+	}
+	add("type", 1)
+	for i := range bodyFields {
+		f := &bodyFields[i]
+		if head.Flags&f.flag == 0 {
+			continue
+		}
+		for _, name := range f.spans {
+			add(name, f.len/len(f.spans))
+		}
+	}
+	if hasVector(t) {
+		add("vector", bodyVectorLen)
+	}
+	checksum := int(head.Checksum().Len())
+	message := max(0, limit-off-checksum)
+	if sealed && hasVector(t) && message >= gcmTagLen {
+		add("message", message-gcmTagLen)
+		add("tag", gcmTagLen)
+	} else {
+		add("message", message)
+	}
+	add("checksum", checksum)
+
+	limit = len(data)
+	add("trailing", limit-off)
+	return spans
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeAnnotated(t *testing.T) {
+	checked := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	checked.SetChecksum(ChecksumCRC32C)
+
+	for _, tc := range []struct {
+		pack  *Packet
+		names []string
+	}{
+		{NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)), []string{"length", "version", "type", "message"}},
+		{checked, []string{"length", "version", "flags", "type", "message", "checksum"}},
+	} {
+		data, err := Encode(tc.pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pack, spans, err := DecodeAnnotated(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := AsMessage[HeartbeatMessage](pack); !ok {
+			t.Fatalf("expected a heartbeat, got %#v", pack)
+		}
+		checkSpans(t, spans, len(data), tc.names)
+	}
+}
+
+func TestDecodeAnnotatedMalformed(t *testing.T) {
+	data, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated packet still maps the bytes that are there
+	_, spans, err := DecodeAnnotated(data[:5], nil)
+	if err == nil {
+		t.Fatal("expected a truncated packet to fail")
+	}
+	checkSpans(t, spans, 5, []string{"length", "version", "type", "message"})
+
+	data = append(data, 0xff, 0xff)
+	if _, spans, err = DecodeAnnotated(data, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, spans, len(data), []string{"length", "version", "type", "message", "trailing"})
+}
+
+func TestDecodeAnnotatedSealed(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewTransferMessage([]byte("tunnelled ip packet"))); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+	_, spans, err := DecodeAnnotated(data, rRecv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpans(t, spans, len(data), []string{"length", "version", "type", "vector", "message", "tag"})
+	if message := spans[4]; message.End-message.Start != len("tunnelled ip packet") {
+		t.Fatalf("expected the plaintext length for the message, got %+v", message)
+	}
+}
+
+// checkSpans verifies that spans are named names and cover n bytes without
+// gaps or overlaps.
+func checkSpans(t *testing.T, spans []FieldSpan, n int, names []string) {
+	t.Helper()
+	var got []string
+	off := 0
+	for _, span := range spans {
+		if span.Start != off || span.End <= span.Start {
+			t.Fatalf("span %+v does not follow offset %d in %+v", span, off, spans)
+		}
+		off = span.End
+		got = append(got, span.Name)
+	}
+	if off != n {
+		t.Fatalf("spans cover %d of %d bytes: %+v", off, n, spans)
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Fatalf("expected fields %v, got %v", names, got)
+	}
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultAnomalyThreshold is the failure count of an ErrorRateMonitor with
+// no positive Threshold.
+const DefaultAnomalyThreshold = 16
+
+type (
+	// ErrorRateMonitor watches the decode failures of each peer and calls
+	// OnAnomaly when a peer reaches Threshold failures within Window, the
+	// sign of an attack or a corrupted link. Each alert doubles the count
+	// the next one needs, so a sustained spike does not flood the callback;
+	// the threshold is reset once the rate fell below Threshold again. A
+	// Threshold of zero or less means DefaultAnomalyThreshold. It is safe
+	// for concurrent use.
+	ErrorRateMonitor struct {
+		Threshold int
+		Window    time.Duration
+		OnAnomaly func(peer string, failures int)
+
+		mu    sync.Mutex
+		now   func() time.Time
+		peers map[string]*peerFailures
+	}
+
+	peerFailures struct {
+		times   []time.Time
+		alertAt int
+	}
+
+	// peerMetrics reports the decode failures of one peer to its monitor.
+	peerMetrics struct {
+		m    *ErrorRateMonitor
+		peer string
+	}
+)
+
+func NewErrorRateMonitor(threshold int, window time.Duration, onAnomaly func(peer string, failures int)) *ErrorRateMonitor {
+	if threshold <= 0 {
+		threshold = DefaultAnomalyThreshold
+	}
+	return &ErrorRateMonitor{
+		Threshold: threshold,
+		Window:    window,
+		OnAnomaly: onAnomaly,
+		now:       time.Now,
+		peers:     make(map[string]*peerFailures),
+	}
+}
+
+// Peer returns the Metrics to set on the Decoder of peer, see
+// Decoder.Metrics. Installed with SetMetrics, it counts the failures of all
+// peers under that name.
+func (m *ErrorRateMonitor) Peer(peer string) Metrics {
+	return peerMetrics{m: m, peer: peer}
+}
+
+// Failures returns the decode failures of peer within the window.
+func (m *ErrorRateMonitor) Failures(peer string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.peers[peer]
+	if !ok {
+		return 0
+	}
+	p.expire(m.now().Add(-m.Window))
+	return len(p.times)
+}
+
+// Forget drops the state of peer, once it disconnected.
+func (m *ErrorRateMonitor) Forget(peer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers, peer)
+}
+
+func (m *ErrorRateMonitor) failed(peer string) {
+	m.mu.Lock()
+	threshold := m.Threshold
+	if threshold <= 0 {
+		threshold = DefaultAnomalyThreshold
+	}
+	p, ok := m.peers[peer]
+	if !ok {
+		p = &peerFailures{alertAt: threshold}
+		m.peers[peer] = p
+	}
+	now := m.now()
+	p.expire(now.Add(-m.Window))
+	if len(p.times) < threshold {
+		p.alertAt = threshold
+	}
+	p.times = append(p.times, now)
+
+	failures := len(p.times)
+	alert := failures >= p.alertAt
+	if alert {
+		p.alertAt *= 2
+	}
+	m.mu.Unlock()
+
+	if alert && m.OnAnomaly != nil {
+		m.OnAnomaly(peer, failures)
+	}
+}
+
+// expire drops the failures before since.
+func (p *peerFailures) expire(since time.Time) {
+	n := 0
+	for n < len(p.times) && p.times[n].Before(since) {
+		n++
+	}
+	p.times = append(p.times[:0], p.times[n:]...)
+}
+
+func (p peerMetrics) PacketEncoded(t uint8, size int) {}
+
+func (p peerMetrics) PacketDecoded(t uint8, size int) {}
+
+func (p peerMetrics) DecodeFailed(err error) {
+	p.m.failed(p.peer)
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestErrorRateMonitor(t *testing.T) {
+	var alerts []int
+	m := NewErrorRateMonitor(10, time.Second, func(peer string, failures int) {
+		if peer != "10.0.0.2" {
+			t.Errorf("alert for %q", peer)
+		}
+		alerts = append(alerts, failures)
+	})
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	metrics := m.Peer("10.0.0.2")
+
+	// one failure every 200ms stays at five per window
+	for i := 0; i < 50; i++ {
+		metrics.DecodeFailed(ErrorUnknownType)
+		now = now.Add(200 * time.Millisecond)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alert at a low rate, got %v", alerts)
+	}
+
+	// one every 10ms fires at 10 failures, then at 20 and 40
+	for i := 0; i < 50; i++ {
+		metrics.DecodeFailed(ErrorUnknownType)
+		now = now.Add(10 * time.Millisecond)
+	}
+	if len(alerts) != 3 || alerts[0] != 10 || alerts[1] != 20 || alerts[2] != 40 {
+		t.Fatalf("expected alerts at 10, 20 and 40 failures, got %v", alerts)
+	}
+
+	// once the spike is over the threshold is back at 10
+	now = now.Add(2 * time.Second)
+	if n := m.Failures("10.0.0.2"); n != 0 {
+		t.Fatalf("expected the window to be empty, got %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		metrics.DecodeFailed(ErrorUnknownType)
+	}
+	if len(alerts) != 4 || alerts[3] != 10 {
+		t.Fatalf("expected a new alert at 10 failures, got %v", alerts)
+	}
+}
+
+func TestErrorRateMonitorDecoder(t *testing.T) {
+	fired := false
+	m := NewErrorRateMonitor(3, time.Minute, func(string, int) { fired = true })
+
+	// packets of an unknown type, each rejected
+	frame := []byte{0, 2, CurrentVersion, 0xee, 0}
+	dec := NewDecoder(bytes.NewReader(bytes.Repeat(frame, 3)), nil)
+	dec.Metrics = m.Peer("peer")
+	for i := 0; i < 3; i++ {
+		dec.Decode()
+	}
+	if !fired {
+		t.Fatalf("expected an alert after %d failures", m.Failures("peer"))
+	}
+}
+
+func TestErrorRateMonitorDefaultThreshold(t *testing.T) {
+	var alerts []int
+	m := NewErrorRateMonitor(0, time.Minute, func(peer string, failures int) {
+		alerts = append(alerts, failures)
+	})
+	if m.Threshold != DefaultAnomalyThreshold {
+		t.Fatalf("expected threshold %d, got %d", DefaultAnomalyThreshold, m.Threshold)
+	}
+	m.Threshold = -1
+	metrics := m.Peer("peer")
+	for i := 0; i < DefaultAnomalyThreshold; i++ {
+		metrics.DecodeFailed(ErrorUnknownType)
+	}
+	if len(alerts) != 1 || alerts[0] != DefaultAnomalyThreshold {
+		t.Fatalf("expected one alert at %d failures, got %v", DefaultAnomalyThreshold, alerts)
+	}
+}
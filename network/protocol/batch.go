@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DecodeBatch decodes each of datas, one packet per datagram, opening sealed
+// messages with key when it is set. A datagram with bytes after its packet
+// fails with ErrorLengthMismatch. The message of a type registered with
+// RegisterStreamType reads from its datagram, which has to stay unchanged
+// until it is read. The work is spread over at most workers
+// goroutines, GOMAXPROCS when workers is not positive. Results are in input
+// order: packets[i] and errs[i] belong to datas[i], and exactly one of them
+// is non-nil.
+func DecodeBatch(datas [][]byte, key []byte, workers int) ([]*Packet, []error) {
+	packets := make([]*Packet, len(datas))
+	errs := make([]error, len(datas))
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(datas) {
+		workers = len(datas)
+	}
+
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(datas) {
+					return
+				}
+				packets[i], errs[i] = decodeDatagram(datas[i], decodeOptions{key: key})
+			}
+		}()
+	}
+	wg.Wait()
+
+	return packets, errs
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	datas := make([][]byte, 100)
+	for i := range datas {
+		if i%7 == 3 {
+			datas[i] = []byte{0, 1, CurrentVersion, 250} // unknown type
+			continue
+		}
+		if i%11 == 5 {
+			datas[i] = []byte{0, 3, 1, TypeOk, 'O', 'K', 0} // trailing byte
+			continue
+		}
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf, iSend).Encode(NewTransferMessage([]byte(fmt.Sprint(i)))); err != nil {
+			t.Fatal(err)
+		}
+		datas[i] = buf.Bytes()
+	}
+
+	for _, workers := range []int{0, 1, 4, 1000} {
+		packets, errs := DecodeBatch(datas, rRecv, workers)
+		if len(packets) != len(datas) || len(errs) != len(datas) {
+			t.Fatalf("workers %d: expected %d results, got %d and %d", workers, len(datas), len(packets), len(errs))
+		}
+		for i := range datas {
+			if i%7 == 3 {
+				if packets[i] != nil || !errors.Is(errs[i], ErrorUnknownType) {
+					t.Fatalf("workers %d, item %d: expected %v, got %v", workers, i, ErrorUnknownType, errs[i])
+				}
+				continue
+			}
+			if i%11 == 5 {
+				if packets[i] != nil || !errors.Is(errs[i], ErrorLengthMismatch) {
+					t.Fatalf("workers %d, item %d: expected %v, got %v", workers, i, ErrorLengthMismatch, errs[i])
+				}
+				continue
+			}
+			if errs[i] != nil {
+				t.Fatalf("workers %d, item %d: %v", workers, i, errs[i])
+			}
+			if got := string(packets[i].Data.Msg.(TransferMessage)); got != fmt.Sprint(i) {
+				t.Fatalf("workers %d, item %d: out of order payload %q", workers, i, got)
+			}
+		}
+	}
+}
+
+func TestDecodeBatchStreamType(t *testing.T) {
+	const typeStream uint8 = 207
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	data, err := Encode(newPacket(typeStream, RawMessage("streamed payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	datas := [][]byte{data, append(bytes.Clone(data), 0), data[:len(data)-1]}
+	packets, errs := DecodeBatch(datas, nil, 1)
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	msg, ok := AsMessage[*StreamMessage](packets[0])
+	if !ok {
+		t.Fatalf("unexpected %#v", packets[0].Data.Msg)
+	}
+	if payload, err := io.ReadAll(msg); err != nil || string(payload) != "streamed payload" {
+		t.Fatalf("unexpected payload %q, %v", payload, err)
+	}
+	if !errors.Is(errs[1], ErrorLengthMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorLengthMismatch, errs[1])
+	}
+	if !errors.Is(errs[2], io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, errs[2])
+	}
+}
+
+func TestDecodeBatchEmpty(t *testing.T) {
+	packets, errs := DecodeBatch(nil, nil, 4)
+	if len(packets) != 0 || len(errs) != 0 {
+		t.Fatalf("expected no results, got %v %v", packets, errs)
+	}
+}
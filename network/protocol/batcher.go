@@ -0,0 +1,78 @@
+package protocol
+
+import "fmt"
+
+// Batcher coalesces small tunnel frames into TransferBatch packets written
+// to an Encoder, never producing a packet larger than its MTU: a batch is
+// flushed before the next frame would push it over.
+type Batcher struct {
+	enc   *Encoder
+	mtu   int
+	limit int
+
+	frames [][]byte
+	size   int
+}
+
+// NewBatcher batches frames for enc into packets of at most mtu bytes,
+// accounting for the header, checksum and, when enc seals, the overhead
+// of AES-GCM.
+func NewBatcher(enc *Encoder, mtu int) (*Batcher, error) {
+	opts := Options{
+		Version: CurrentVersion,
+		Type:    TypeTransferBatch,
+	}
+	if enc.version != 0 {
+		opts.Version = enc.version
+	}
+	if enc.SendKey != nil {
+		opts.Cipher = CipherAES256GCM
+	}
+
+	limit := min(mtu-Overhead(opts), MaxMessageLen)
+	if limit < batchLenSize+batchLenSize+1 {
+		return nil, fmt.Errorf("%w: mtu %d leaves no room for a frame", ErrorPacketTooLarge, mtu)
+	}
+	return &Batcher{
+		enc:   enc,
+		mtu:   mtu,
+		limit: limit,
+		size:  batchLenSize,
+	}, nil
+}
+
+// MTU returns the largest packet the batcher writes.
+func (b *Batcher) MTU() int {
+	return b.mtu
+}
+
+// Add queues frame, first flushing the pending batch when frame does not fit
+// it. A frame too large for a packet of its own is rejected with
+// ErrorPacketTooLarge. The frame is retained until the batch is flushed.
+func (b *Batcher) Add(frame []byte) error {
+	frameSize := batchLenSize + len(frame)
+	if batchLenSize+frameSize > b.limit {
+		return fmt.Errorf("%w: frame of %d bytes exceeds mtu %d", ErrorPacketTooLarge, len(frame), b.mtu)
+	}
+	if b.size+frameSize > b.limit || len(b.frames) == 1<<16-1 {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+	b.frames = append(b.frames, frame)
+	b.size += frameSize
+	return nil
+}
+
+// Flush writes the pending frames, if any, as one TransferBatch packet.
+func (b *Batcher) Flush() error {
+	if len(b.frames) == 0 {
+		return nil
+	}
+	pack, err := NewTransferBatchMessage(b.frames)
+	if err != nil {
+		return err
+	}
+	b.frames, b.size = nil, batchLenSize
+	return b.enc.Encode(pack)
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestBatcherRespectsMTU(t *testing.T) {
+	const mtu = 1280
+	rnd := rand.New(rand.NewSource(1))
+
+	var frames [][]byte
+	for i := 0; i < 2000; i++ {
+		frame := make([]byte, 1+rnd.Intn(300))
+		rnd.Read(frame)
+		frames = append(frames, frame)
+	}
+	// the largest frame that still fits a packet on its own
+	frames = append(frames, make([]byte, mtu-Overhead(Options{Version: CurrentVersion, Type: TypeTransferBatch})-2*batchLenSize))
+
+	var stream bytes.Buffer
+	b, err := NewBatcher(NewEncoder(&stream, nil), mtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MTU() != mtu {
+		t.Fatalf("expected mtu %d, got %d", mtu, b.MTU())
+	}
+	for _, frame := range frames {
+		if err = b.Add(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	packets, consumed, err := SplitFrames(stream.Bytes())
+	if err != nil || consumed != stream.Len() {
+		t.Fatalf("split %d of %d bytes, %v", consumed, stream.Len(), err)
+	}
+	var got [][]byte
+	for i, data := range packets {
+		if len(data) > mtu {
+			t.Fatalf("packet %d of %d bytes exceeds mtu %d", i, len(data), mtu)
+		}
+		pack, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pack.Data.Msg.(TransferBatchMessage).Frames()...)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("delivered %d of %d frames", len(got), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(got[i], frames[i]) {
+			t.Fatalf("frame %d differs", i)
+		}
+	}
+}
+
+func TestBatcherFrameTooLarge(t *testing.T) {
+	b, err := NewBatcher(NewEncoder(&bytes.Buffer{}, nil), 576)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Add(make([]byte, 576)); !errors.Is(err, ErrorPacketTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrorPacketTooLarge, err)
+	}
+	if _, err = NewBatcher(NewEncoder(&bytes.Buffer{}, nil), 8); !errors.Is(err, ErrorPacketTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrorPacketTooLarge, err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package capture records encoded packets with their timestamps and replays
+// them into a protocol.Decoder, for debugging a mesh after the fact.
+//
+// A capture starts with a magic and a version byte, followed by one record
+// per packet: the capture time in Unix nanoseconds as an int64, the frame
+// length as a uint32 and the frame as it went over the wire. Reader and
+// Replay hand out the records; DecodeTimestamped decodes them one by one.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/meshbird/meshbird/network/protocol"
+)
+
+const (
+	version        = 1
+	recordHeadLen  = 8 + 4
+	maxRecordFrame = 1 << 17
+)
+
+var (
+	magic = []byte{'M', 'B', 'C', 'A', 'P'}
+
+	ErrorNotCapture     = errors.New("not a capture file")
+	ErrorCaptureVersion = errors.New("unsupported capture version")
+	ErrorCorruptRecord  = errors.New("corrupt capture record")
+)
+
+type (
+	// Writer appends packets to a capture.
+	Writer struct {
+		w   io.Writer
+		now func() time.Time
+	}
+
+	// Record is one captured frame.
+	Record struct {
+		Time  time.Time
+		Frame []byte
+	}
+
+	// Reader reads the records of a capture in order.
+	Reader struct {
+		r *bufio.Reader
+	}
+
+	// Replay feeds the frames of a capture to a reader such as a
+	// protocol.Decoder, pacing them as they were captured.
+	Replay struct {
+		records *Reader
+		speed   float64
+		now     func() time.Time
+		sleep   func(time.Duration)
+
+		first, start time.Time
+		pending      []byte
+	}
+)
+
+// NewWriter starts a capture on w by writing its header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := w.Write(append(magic[:len(magic):len(magic)], version)); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:   w,
+		now: time.Now,
+	}, nil
+}
+
+// WritePacket records pack in its plain encoded form.
+func (c *Writer) WritePacket(pack *protocol.Packet) error {
+	data, err := protocol.Encode(pack)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(data)
+}
+
+// WriteFrame records an already encoded frame, such as one read from the
+// network with its message sealed.
+func (c *Writer) WriteFrame(frame []byte) error {
+	if len(frame) > maxRecordFrame {
+		return fmt.Errorf("%w: frame of %d bytes", protocol.ErrorPacketTooLarge, len(frame))
+	}
+	record := make([]byte, recordHeadLen, recordHeadLen+len(frame))
+	binary.BigEndian.PutUint64(record, uint64(c.now().UnixNano()))
+	binary.BigEndian.PutUint32(record[8:], uint32(len(frame)))
+	_, err := c.w.Write(append(record, frame...))
+	return err
+}
+
+// NewReader opens the capture in r, checking its header.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if err := ReadHeader(br); err != nil {
+		return nil, err
+	}
+	return &Reader{r: br}, nil
+}
+
+// ReadHeader reads and checks the header of the capture in r, leaving r at
+// its first record, see DecodeTimestamped.
+func ReadHeader(r io.Reader) error {
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return fmt.Errorf("%w: %v", ErrorNotCapture, err)
+	}
+	if !bytes.Equal(head[:len(magic)], magic) {
+		return ErrorNotCapture
+	}
+	if head[len(magic)] != version {
+		return fmt.Errorf("%w: %d", ErrorCaptureVersion, head[len(magic)])
+	}
+	return nil
+}
+
+// Next returns the next record, io.EOF after the last one.
+func (c *Reader) Next() (Record, error) {
+	return readRecord(c.r)
+}
+
+// DecodeTimestamped reads the next record of a capture from r, positioned
+// past the header by ReadHeader, and decodes its frame, opening a sealed
+// message with key when it is set. It returns the packet with the time it
+// was captured, io.EOF after the last record. A frame holding anything but
+// exactly one packet fails with ErrorCorruptRecord.
+func DecodeTimestamped(r io.Reader, key []byte) (*protocol.Packet, time.Time, error) {
+	record, err := readRecord(r)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	dec := protocol.NewDecoder(bytes.NewReader(record.Frame), key)
+	pack, err := dec.Decode()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// the header tells the span, a streamed message is not read yet
+	if n := len(record.Frame) - (int(pack.Head.Len()) + int(pack.Head.Length)); n != 0 {
+		return nil, time.Time{}, fmt.Errorf("%w: frame of %d bytes holds a packet of %d", ErrorCorruptRecord, len(record.Frame), len(record.Frame)-n)
+	}
+	return pack, record.Time, nil
+}
+
+func readRecord(r io.Reader) (Record, error) {
+	var head [recordHeadLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: truncated header", ErrorCorruptRecord)
+		}
+		return Record{}, err
+	}
+	frameLen := binary.BigEndian.Uint32(head[8:])
+	if frameLen > maxRecordFrame {
+		return Record{}, fmt.Errorf("%w: frame of %d bytes", ErrorCorruptRecord, frameLen)
+	}
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return Record{}, fmt.Errorf("%w: truncated frame", ErrorCorruptRecord)
+	}
+	return Record{
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(head[:]))),
+		Frame: frame,
+	}, nil
+}
+
+// NewReplay replays the capture in r. A speed of 1 reproduces the original
+// gaps between frames, 10 plays ten times faster and 0 does not wait at
+// all.
+func NewReplay(r io.Reader, speed float64) (*Replay, error) {
+	records, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Replay{
+		records: records,
+		speed:   speed,
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}, nil
+}
+
+// Read hands out the frames of the capture, each once its time has come.
+// Frames are never merged within a single Read.
+func (r *Replay) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		record, err := r.records.Next()
+		if err != nil {
+			return 0, err
+		}
+		r.wait(record.Time)
+		r.pending = record.Frame
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *Replay) wait(at time.Time) {
+	if r.first.IsZero() {
+		r.first, r.start = at, r.now()
+		return
+	}
+	if r.speed <= 0 {
+		return
+	}
+	due := r.start.Add(time.Duration(float64(at.Sub(r.first)) / r.speed))
+	if d := due.Sub(r.now()); d > 0 {
+		r.sleep(d)
+	}
+}
@@ -0,0 +1,234 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meshbird/meshbird/network/protocol"
+)
+
+func testCapture(t *testing.T, gap time.Duration) ([]*protocol.Packet, []byte) {
+	packs := []*protocol.Packet{
+		protocol.NewOkMessage(),
+		protocol.NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		protocol.NewTransferMessage([]byte("tunnelled ip packet")),
+		protocol.NewGoneMessage(protocol.GoneReasonShutdown, nil),
+	}
+
+	var file bytes.Buffer
+	w, err := NewWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1700000000, 0)
+	w.now = func() time.Time {
+		clock = clock.Add(gap)
+		return clock
+	}
+	for _, pack := range packs {
+		if err = w.WritePacket(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return packs, file.Bytes()
+}
+
+func TestReplay(t *testing.T) {
+	packs, file := testCapture(t, time.Second)
+
+	replay, err := NewReplay(bytes.NewReader(file), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := protocol.NewDecoder(replay, nil)
+	for i, want := range packs {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		wantData, _ := protocol.Encode(want)
+		gotData, _ := protocol.Encode(got)
+		if !bytes.Equal(gotData, wantData) {
+			t.Fatalf("packet %d: replayed %#v, expected %#v", i, got, want)
+		}
+	}
+	if _, err = dec.Decode(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	_, file := testCapture(t, time.Second)
+
+	var waited time.Duration
+	replay, err := NewReplay(bytes.NewReader(file), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1700000000, 0)
+	replay.now = func() time.Time {
+		return clock
+	}
+	replay.sleep = func(d time.Duration) {
+		waited += d
+		clock = clock.Add(d)
+	}
+	if _, err = io.Copy(io.Discard, replay); err != nil {
+		t.Fatal(err)
+	}
+	// three one second gaps played a hundred times faster
+	if waited != 30*time.Millisecond {
+		t.Fatalf("waited %v, expected 30ms", waited)
+	}
+}
+
+func TestReaderRecords(t *testing.T) {
+	packs, file := testCapture(t, time.Millisecond)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last time.Time
+	for i := range packs {
+		record, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.Time.After(last) {
+			t.Fatalf("record %d out of order", i)
+		}
+		last = record.Time
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+
+	truncated, _ := NewReader(bytes.NewReader(file[:len(file)-3]))
+	for range packs {
+		if _, err = truncated.Next(); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrorCorruptRecord) {
+		t.Fatalf("expected %v, got %v", ErrorCorruptRecord, err)
+	}
+	if _, err = NewReader(bytes.NewReader([]byte("pcap file"))); !errors.Is(err, ErrorNotCapture) {
+		t.Fatalf("expected %v, got %v", ErrorNotCapture, err)
+	}
+}
+
+func TestDecodeTimestamped(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, protocol.SessionKeyLen)
+	payloads := []string{"first", "second", "third"}
+
+	var file bytes.Buffer
+	w, err := NewWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	clock := start
+	w.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	// sealed frames as they went over the wire, next to a plain one
+	for _, payload := range payloads {
+		var frame bytes.Buffer
+		if err = protocol.NewEncoder(&frame, key).Encode(protocol.NewTransferMessage([]byte(payload))); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.WriteFrame(frame.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WritePacket(protocol.NewOkMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(file.Bytes())
+	if err = ReadHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	for i, payload := range payloads {
+		pack, at, err := DecodeTimestamped(r, key)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if want := start.Add(time.Duration(i+1) * time.Millisecond); !at.Equal(want) {
+			t.Fatalf("record %d: captured at %v, expected %v", i, at, want)
+		}
+		if msg, ok := protocol.AsMessage[protocol.TransferMessage](pack); !ok || string(msg) != payload {
+			t.Fatalf("record %d: expected %q, got %#v", i, payload, pack.Data.Msg)
+		}
+	}
+	if pack, _, err := DecodeTimestamped(r, key); err != nil || pack.Data.Type != protocol.TypeOk {
+		t.Fatalf("expected the ok, got %v", err)
+	}
+	if _, _, err = DecodeTimestamped(r, key); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+
+	// a record framing more than one packet
+	var frame bytes.Buffer
+	for range 2 {
+		data, _ := protocol.Encode(protocol.NewOkMessage())
+		frame.Write(data)
+	}
+	file.Reset()
+	if w, err = NewWriter(&file); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(frame.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	r = bytes.NewReader(file.Bytes())
+	if err = ReadHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = DecodeTimestamped(r, nil); !errors.Is(err, ErrorCorruptRecord) {
+		t.Fatalf("expected %v, got %v", ErrorCorruptRecord, err)
+	}
+}
+
+func TestDecodeTimestampedStreamType(t *testing.T) {
+	// the registration stays for the rest of the package tests, which do
+	// not use the type otherwise
+	const typeStream uint8 = 230
+	protocol.RegisterStreamType(typeStream, "stream", nil)
+
+	var file bytes.Buffer
+	w, err := NewWriter(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := &protocol.Packet{
+		Head: protocol.Header{Version: protocol.CurrentVersion},
+		Data: protocol.Body{Type: typeStream, Msg: protocol.RawMessage("streamed payload")},
+	}
+	pack.Head.Length = pack.Data.Len()
+	if err = w.WritePacket(pack); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(file.Bytes())
+	if err = ReadHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := DecodeTimestamped(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := protocol.AsMessage[*protocol.StreamMessage](decoded)
+	if !ok {
+		t.Fatalf("unexpected %#v", decoded.Data.Msg)
+	}
+	if payload, err := io.ReadAll(msg); err != nil || string(payload) != "streamed payload" {
+		t.Fatalf("unexpected payload %q, %v", payload, err)
+	}
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+type ChecksumAlgorithm uint8
+
+// The checksum algorithm is kept in the two lowest bits of Header.Flags. A
+// checksummed packet carries a 4 byte trailer, counted in Header.Length,
+// computed over the header and the body.
+const (
+	ChecksumNone ChecksumAlgorithm = iota
+	ChecksumCRC32
+	ChecksumCRC32C
+	ChecksumXXHash
+
+	DefaultChecksum = ChecksumCRC32
+
+	flagChecksumMask = 0x03
+	checksumLen      = 4
+)
+
+var (
+	ErrorChecksumMismatch = errors.New("checksum mismatch")
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Checksum returns the integrity algorithm selected by the header flags.
+func (h Header) Checksum() ChecksumAlgorithm {
+	if h.Version < FlagsVersion {
+		return ChecksumNone
+	}
+	return ChecksumAlgorithm(h.Flags & flagChecksumMask)
+}
+
+// SetChecksum selects the integrity algorithm of p, upgrading its header to
+// FlagsVersion when needed.
+func (p *Packet) SetChecksum(alg ChecksumAlgorithm) {
+	if p.Head.Version < FlagsVersion {
+		p.Head.Version = FlagsVersion
+	}
+	p.Head.Flags = p.Head.Flags&^flagChecksumMask | uint8(alg)&flagChecksumMask
+	p.Head.Length = p.Data.Len() + alg.Len()
+}
+
+// Len returns the size of the checksum trailer.
+func (a ChecksumAlgorithm) Len() uint16 {
+	if a == ChecksumNone {
+		return 0
+	}
+	return checksumLen
+}
+
+func (a ChecksumAlgorithm) Sum(data []byte) uint32 {
+	switch a {
+	case ChecksumCRC32:
+		return crc32.ChecksumIEEE(data)
+	case ChecksumCRC32C:
+		return crc32.Checksum(data, crc32cTable)
+	case ChecksumXXHash:
+		return xxhash32(data, 0)
+	}
+	return 0
+}
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case ChecksumNone:
+		return "none"
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash:
+		return "xxhash"
+	}
+	return "unknown"
+}
+
+// verifyChecksum reads the checksum trailer from r and compares it against
+// the already read parts of pack.
+func verifyChecksum(r io.Reader, pack *Packet, message []byte) error {
+	var trailer [checksumLen]byte
+	if n, err := io.ReadFull(r, trailer[:]); err != nil {
+		return shortReadError(pack.Data.Type, ErrorUnableToReadMessage, checksumLen, n, err)
+	}
+	expected := binary.BigEndian.Uint32(trailer[:])
+
+	covered := new(bytes.Buffer)
+	pack.Head.WriteTo(covered)
+	covered.WriteByte(pack.Data.Type)
+	covered.Write(pack.Data.appendFields(nil, pack.Head.Flags))
+	covered.Write(pack.Data.Vector)
+	covered.Write(message)
+
+	if pack.Head.Checksum().Sum(covered.Bytes()) != expected {
+		return &DecodeError{Type: pack.Data.Type, Err: ErrorChecksumMismatch}
+	}
+	return nil
+}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// xxhash32 is the 32 bit variant of xxHash.
+func xxhash32(data []byte, seed uint32) uint32 {
+	n := len(data)
+	var h uint32
+
+	if n >= 16 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(data) >= 16 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint32(data[12:]))
+			data = data[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+
+	h += uint32(n)
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+		data = data[4:]
+	}
+	for _, b := range data {
+		h += uint32(b) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(acc, input uint32) uint32 {
+	acc += input * xxPrime2
+	return bits.RotateLeft32(acc, 13) * xxPrime1
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestXXHash32(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		sum uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+	} {
+		if sum := xxhash32([]byte(tc.in), 0); sum != tc.sum {
+			t.Errorf("%q: expected %08x, got %08x", tc.in, tc.sum, sum)
+		}
+	}
+}
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for _, alg := range []ChecksumAlgorithm{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash} {
+		for _, pack := range []*Packet{
+			NewTransferMessage(bytes.Repeat([]byte{7}, 100)),
+			NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		} {
+			pack.SetChecksum(alg)
+			data, err := Encode(pack)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != int(pack.Len()) {
+				t.Fatalf("%v: expected %d bytes, got %d", alg, pack.Len(), len(data))
+			}
+
+			decoded, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%v: %v", alg, err)
+			}
+			if decoded.Head.Checksum() != alg {
+				t.Fatalf("expected %v, got %v", alg, decoded.Head.Checksum())
+			}
+			if !samePacket(t, pack, decoded) {
+				t.Fatalf("%v: packet changed in round trip", alg)
+			}
+		}
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	for _, alg := range []ChecksumAlgorithm{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash} {
+		pack := NewTransferMessage(bytes.Repeat([]byte{7}, 100))
+		pack.SetChecksum(alg)
+		data, err := Encode(pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// flip a single payload bit
+		data[len(data)-checksumLen-10] ^= 0x01
+		if _, err = Decode(bytes.NewReader(data)); !errors.Is(err, ErrorChecksumMismatch) {
+			t.Fatalf("%v: expected %v, got %v", alg, ErrorChecksumMismatch, err)
+		}
+	}
+}
+
+func TestChecksumNotUsedByDefault(t *testing.T) {
+	pack := NewOkMessage()
+	if pack.Head.Checksum() != ChecksumNone {
+		t.Fatalf("expected no checksum on a version %d packet", pack.Head.Version)
+	}
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3+1+2 {
+		t.Fatalf("unexpected encoded size %d", len(data))
+	}
+}
@@ -0,0 +1,272 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+const (
+	// SessionKeyLen is the size of the AES-256 keys messages are sealed with.
+	SessionKeyLen = 32
+
+	infoInitiatorToResponder = "meshbird initiator to responder"
+	infoResponderToInitiator = "meshbird responder to initiator"
+
+	gcmTagLen = 16
+)
+
+const (
+	CipherNone CipherSuite = iota
+	// CipherAES256GCM seals with AES-256-GCM, the vector being the nonce.
+	CipherAES256GCM
+)
+
+type (
+	// SealFunc encrypts plain under key with the nonce, authenticating ad
+	// along with it, and returns the ciphertext including any tag. It lets
+	// the crypto live outside the process, e.g. in an HSM.
+	SealFunc func(key, nonce, plain, ad []byte) ([]byte, error)
+	// OpenFunc reverses SealFunc. It may decrypt in place into sealed.
+	OpenFunc func(key, nonce, sealed, ad []byte) ([]byte, error)
+	// KeyResolver returns the key messages of type t are sealed with, nil
+	// for types sent in the clear.
+	KeyResolver func(t uint8) []byte
+)
+
+var (
+	ErrorDecryption = errors.New("unable to decrypt message")
+	ErrorNotSealed  = errors.New("packet type is not sealed")
+)
+
+// CipherSuite identifies how messages carrying a vector are sealed.
+type CipherSuite uint8
+
+// TagLen returns the bytes the suite adds to every sealed message.
+func (s CipherSuite) TagLen() int {
+	if s == CipherAES256GCM {
+		return gcmTagLen
+	}
+	return 0
+}
+
+func (s CipherSuite) String() string {
+	switch s {
+	case CipherNone:
+		return "none"
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	}
+	return fmt.Sprintf("cipher %d", uint8(s))
+}
+
+// DeriveDirectionKeys derives the send and receive keys of one side of a
+// session from the handshake's shared secret. Each direction is sealed with
+// its own key, so the initiator's send key is the responder's receive key
+// and a packet reflected back at its sender fails authentication.
+func DeriveDirectionKeys(sharedSecret, salt []byte, initiator bool) (sendKey, receiveKey []byte, err error) {
+	toResponder, err := secure.DeriveSessionKey(sharedSecret, salt, []byte(infoInitiatorToResponder), SessionKeyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	toInitiator, err := secure.DeriveSessionKey(sharedSecret, salt, []byte(infoResponderToInitiator), SessionKeyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	if initiator {
+		return toResponder, toInitiator, nil
+	}
+	return toInitiator, toResponder, nil
+}
+
+// sealMessage encrypts the message of body with seal, AES-GCM when nil,
+// using the vector as nonce and authenticating the version, flags, type,
+// priority, route, send time and message id along with it.
+func sealMessage(key []byte, seal SealFunc, head Header, body Body) (Message, error) {
+	if len(body.Vector) != bodyVectorLen {
+		return nil, ErrorUnableToReadVector
+	}
+	if seal == nil {
+		seal = gcmSeal
+	}
+
+	plain := new(bytes.Buffer)
+	if _, err := body.Msg.WriteTo(plain); err != nil {
+		return nil, err
+	}
+	sealed, err := seal(key, body.Vector, plain.Bytes(), additionalData(head, body))
+	wipe(plain.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) > MaxMessageLen-bodyVectorLen {
+		return nil, ErrorPacketTooLarge
+	}
+	return encodedMessage(sealed), nil
+}
+
+// Reencrypt seals the plain message of p, as returned by a Decoder, under
+// newKey with a fresh vector, so a relay can forward it to another peer
+// without handing the plaintext further up. The returned packet is written
+// as is with Encode or EncodeTo; it must not go through a keyed Encoder
+// again. The message of p is wiped and p must not be used afterwards.
+func Reencrypt(p *Packet, newKey []byte) (*Packet, error) {
+	if !hasVector(p.Data.Type) {
+		return nil, fmt.Errorf("%w: %s", ErrorNotSealed, typeName(p.Data.Type))
+	}
+	defer wipeMessage(p.Data.Msg)
+
+	head := p.Head
+	// the message is forwarded as is, compressing it here would change the
+	// length a relay has to account for
+	head.Flags &^= FlagCompressed
+	body := Body{
+		Type:        p.Data.Type,
+		Priority:    p.Data.Priority,
+		Source:      p.Data.Source,
+		Destination: p.Data.Destination,
+		SentAt:      p.Data.SentAt,
+		MessageID:   p.Data.MessageID,
+		Vector:      randomBytes(bodyVectorLen),
+		Msg:         p.Data.Msg,
+	}
+	sealed, err := sealMessage(newKey, nil, head, body)
+	if err != nil {
+		return nil, err
+	}
+	body.Msg = sealed
+	head.Length = body.Len() + head.Checksum().Len()
+
+	return &Packet{
+		Head: head,
+		Data: body,
+	}, nil
+}
+
+// openMessage decrypts sealed with open, AES-GCM when nil. Failures are
+// reported as ErrorDecryption.
+func openMessage(key []byte, open OpenFunc, pack *Packet, sealed []byte) ([]byte, error) {
+	if open == nil {
+		open = gcmOpen
+	}
+	message, err := open(key, pack.Data.Vector, sealed, additionalData(pack.Head, pack.Data))
+	if err != nil {
+		if errors.Is(err, ErrorDecryption) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrorDecryption, err)
+	}
+	return message, nil
+}
+
+// bindSeal makes seal authenticate context after the additional data of
+// each packet. The packet's part is as long as its flags say, so context
+// cannot be confused with it.
+func bindSeal(seal SealFunc, context []byte) SealFunc {
+	if len(context) == 0 {
+		return seal
+	}
+	return func(key, nonce, plain, ad []byte) ([]byte, error) {
+		return seal(key, nonce, plain, append(ad, context...))
+	}
+}
+
+// bindOpen is bindSeal for open, AES-GCM when nil.
+func bindOpen(open OpenFunc, context []byte) OpenFunc {
+	if len(context) == 0 {
+		return open
+	}
+	if open == nil {
+		open = gcmOpen
+	}
+	return func(key, nonce, sealed, ad []byte) ([]byte, error) {
+		return open(key, nonce, sealed, append(ad, context...))
+	}
+}
+
+func gcmSeal(key, nonce, plain, ad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plain, ad), nil
+}
+
+func gcmOpen(key, nonce, sealed, ad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	message, err := aead.Open(sealed[:0], nonce, sealed, ad)
+	if err != nil {
+		return nil, ErrorDecryption
+	}
+	return message, nil
+}
+
+// aeadCache keeps the AES-GCM instance of the key it last sealed with, so a
+// burst of packets under one key sets up the cipher once and only the nonce
+// varies. It is safe for concurrent use.
+type aeadCache struct {
+	mu   sync.Mutex
+	key  []byte
+	aead cipher.AEAD
+}
+
+func (c *aeadCache) get(key []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// compared on every call, the key may have been replaced or changed in
+	// place since
+	if c.aead == nil || !bytes.Equal(c.key, key) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		wipe(c.key)
+		c.key = bytes.Clone(key)
+		c.aead = aead
+	}
+	return c.aead, nil
+}
+
+// seal is gcmSeal on the cached cipher.
+func (c *aeadCache) seal(key, nonce, plain, ad []byte) ([]byte, error) {
+	aead, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plain, ad), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, bodyVectorLen)
+}
+
+func additionalData(head Header, body Body) []byte {
+	return body.appendFields([]byte{head.Version, head.Flags, body.Type}, head.Flags)
+}
+
+// wipe zeroes sensitive scratch space once it is no longer needed.
+func wipe(b []byte) {
+	clear(b)
+}
+
+// wipeMessage zeroes the bytes of msg when it is backed by a byte slice, as
+// all messages of this package are.
+func wipeMessage(msg Message) {
+	if v := reflect.ValueOf(msg); v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		wipe(v.Bytes())
+	}
+}
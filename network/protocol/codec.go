@@ -0,0 +1,408 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+type (
+	// Encoder writes packets to a stream. Messages of types carrying a vector
+	// are sealed with SendKey when it is set.
+	Encoder struct {
+		w       io.Writer
+		SendKey []byte
+		// Seal replaces the built-in AES-GCM sealing when set.
+		Seal SealFunc
+		// AssociatedData is authenticated along with every sealed message
+		// without being sent, e.g. a tunnel label: the packets only open
+		// under a Decoder with the same AssociatedData.
+		AssociatedData []byte
+		version        uint8
+		level          int
+		fec            *fecEncoder
+		gcm            *aeadCache
+		prefix         int
+		stamp          bool
+		now            func() time.Time
+		ids            bool
+		lastID         uint32
+		last           *Packet
+	}
+
+	// Decoder reads packets from a stream. Messages of types carrying a
+	// vector are opened with ReceiveKey when it is set. Scratch space holding
+	// plaintext is wiped before Decode returns, but the decoded message is
+	// the caller's: wipe it with clear once a sensitive payload is handled.
+	Decoder struct {
+		r          io.Reader
+		ReceiveKey []byte
+		// KeyFor resolves the key per packet type when set, taking
+		// precedence over ReceiveKey.
+		KeyFor KeyResolver
+		// Open replaces the built-in AES-GCM opening when set.
+		Open OpenFunc
+		// AssociatedData has to match the one of the Encoder, sealed
+		// messages fail with ErrorDecryption otherwise.
+		AssociatedData []byte
+		// MaxPeerEntries rejects peer tables announcing more entries with
+		// ErrorTooManyPeers, before they are parsed. Zero means no limit.
+		MaxPeerEntries int
+		// KeepRaw fills in Body.Raw of every decoded packet, at the cost of
+		// a copy of the message.
+		KeepRaw bool
+		// MaxDecompressedSize aborts inflating a compressed message with
+		// ErrorDecompressionLimit once it grows past that many bytes. Zero
+		// means the MaxMessageLen every message is bound to.
+		MaxDecompressedSize int
+		// Interceptors run in order on every decoded packet.
+		Interceptors []DecodeInterceptor
+		// OnReset is called with the Reset packets Decode returns, once the
+		// interceptors accepted them: the session should restart the
+		// handshake. Resets are never sealed, so anyone able to inject a
+		// packet into the stream can send one; OnReset fires at most once
+		// per ResetInterval to bound the handshakes they can force.
+		OnReset func(m ResetMessage)
+		// ResetInterval is the least time between two calls of OnReset.
+		// Resets arriving sooner are still returned by Decode. Zero means
+		// DefaultResetInterval.
+		ResetInterval time.Duration
+		// Metrics receives the decode events of this Decoder in addition to
+		// the Metrics installed with SetMetrics, e.g. those of one peer.
+		Metrics Metrics
+		// ReadHint enables read-ahead: Decode reads that many bytes at
+		// once, buffering further packets that arrived together, which
+		// saves a read per small packet. DefaultReadHint suits most links.
+		// It has to be set before the first Decode; from then on the
+		// Decoder owns every byte of the stream. Zero or a negative hint,
+		// the default, reads exactly the bytes of each packet.
+		ReadHint int
+
+		fec       *fecDecoder
+		recovered []*Packet
+		recent    errorRing
+		// pending is the streamed message of the last packet, whose unread
+		// bytes precede the next packet
+		pending   io.Reader
+		prefix    int
+		buffered  bool
+		seen      *seenIDs
+		now       func() time.Time
+		lastReset time.Time
+	}
+
+	// DecodeInterceptor inspects a decoded packet and returns the packet to
+	// hand on, possibly a different one. An error rejects the packet and is
+	// returned by Decode as is.
+	DecodeInterceptor func(pack *Packet) (*Packet, error)
+)
+
+func NewEncoder(w io.Writer, sendKey []byte) *Encoder {
+	return &Encoder{
+		w:       w,
+		SendKey: sendKey,
+		level:   -1,
+		gcm:     new(aeadCache),
+		now:     time.Now,
+	}
+}
+
+// SetCompressionLevel applies the level negotiated for the session, see
+// NegotiateCompressionLevel. From level 1 on, Transfer packets are
+// compressed at that level as well as the packets marked with SetCompressed;
+// level 0 sends everything uncompressed. Compressing before sealing lets
+// the packet size reveal how compressible the payload is.
+func (e *Encoder) SetCompressionLevel(level int) error {
+	if level < 0 || level > MaxCompressionLevel {
+		return fmt.Errorf("%w: %d", ErrorCompressionLevel, level)
+	}
+	e.level = level
+	return nil
+}
+
+// CompressionLevel returns the level set with SetCompressionLevel, -1 when
+// none was set.
+func (e *Encoder) CompressionLevel() int {
+	return e.level
+}
+
+// SetFEC protects Transfer packets with forward error correction: every
+// dataShards of them are followed by parityShards FEC packets, from which a
+// Decoder with FEC enabled recovers up to parityShards lost packets of the
+// group. The redundancy ratio is parityShards/dataShards.
+func (e *Encoder) SetFEC(dataShards, parityShards int) error {
+	fec, err := newFECEncoder(dataShards, parityShards)
+	if err != nil {
+		return err
+	}
+	e.fec = fec
+	return nil
+}
+
+// SetVersion makes Encode emit every packet with version v in its header in
+// place of the version the packet was built with, so tests and migration
+// tools can talk to peers of an older version. Packets using header flags
+// cannot be emitted below FlagsVersion.
+func (e *Encoder) SetVersion(v uint8) error {
+	if v < MinVersion || v > MaxVersion {
+		return fmt.Errorf("%w: %d, supported are %d to %d", ErrorUnsupportedVersion, v, MinVersion, MaxVersion)
+	}
+	e.version = v
+	return nil
+}
+
+// SetFramePrefix makes Encode precede every packet with its total length as
+// a big endian integer of width bytes, 1, 2 or 4, for transports that frame
+// messages that way; zero, the default, writes packets back to back.
+// Head.Length is written as ever. Packets too long for the prefix fail with
+// ErrorFrameTooLarge, a 1 byte prefix allowing 255 bytes and a 2 byte one
+// 65535.
+func (e *Encoder) SetFramePrefix(width int) error {
+	if err := checkFramePrefix(width); err != nil {
+		return err
+	}
+	e.prefix = width
+	return nil
+}
+
+// SetTimestamps makes Encode stamp every packet but handshakes with the
+// time it is written, see SetSentAt, so the peer can estimate the one-way
+// delay with Body.OneWayDelay. Stamped packets are emitted with at least
+// FlagsVersion.
+func (e *Encoder) SetTimestamps(enabled bool) {
+	e.stamp = enabled
+}
+
+// Encode writes pack to the underlying stream in a single Write. With FEC
+// enabled, the Transfer packet completing a group is followed by the parity
+// packets, each in its own Write.
+func (e *Encoder) Encode(pack *Packet) error {
+	if err := e.write(pack); err != nil {
+		return err
+	}
+	if e.fec == nil || pack.Data.Type != TypeTransfer {
+		return nil
+	}
+
+	parity, err := e.fec.add(pack)
+	if err != nil {
+		return err
+	}
+	for _, p := range parity {
+		if err = e.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) write(pack *Packet) error {
+	level := defaultCompressionLevel
+	if e.level >= 0 {
+		level = e.level
+		override := *pack
+		switch {
+		case level == 0:
+			override.Head.Flags &^= FlagCompressed
+		case pack.Data.Type == TypeTransfer:
+			override.Head.Flags |= FlagCompressed
+			override.Head.Version = max(override.Head.Version, FlagsVersion)
+		}
+		pack = &override
+	}
+	pack = e.tag(pack)
+	if e.stamp && pack.Data.Type != TypeHandshake {
+		override := *pack
+		override.SetSentAt(e.now())
+		pack = &override
+	}
+	if e.version != 0 && pack.Head.Version != e.version {
+		if e.version < FlagsVersion && pack.Head.Version >= FlagsVersion && pack.Head.Flags != 0 {
+			return fmt.Errorf("%w: flags need version %d, encoder emits %d", ErrorUnsupportedVersion, FlagsVersion, e.version)
+		}
+		override := *pack
+		override.Head.Version = e.version
+		pack = &override
+	}
+	seal := e.Seal
+	if seal == nil {
+		seal = e.gcm.seal
+	}
+	data, err := encode(pack, e.SendKey, bindSeal(seal, e.AssociatedData), level)
+	if err != nil {
+		return err
+	}
+	if e.prefix != 0 {
+		if data, err = appendFrame(make([]byte, 0, e.prefix+len(data)), e.prefix, data); err != nil {
+			return err
+		}
+	}
+	if _, err = e.w.Write(data); err != nil {
+		return err
+	}
+	reportEncoded(pack, len(data))
+	return nil
+}
+
+// DefaultReadHint is the ReadHint of a Decoder reading a packet at a time
+// off a link, the MTU of an Ethernet link.
+const DefaultReadHint = 1500
+
+func NewDecoder(r io.Reader, receiveKey []byte) *Decoder {
+	return &Decoder{
+		r:          r,
+		ReceiveKey: receiveKey,
+		now:        time.Now,
+	}
+}
+
+// EnableFEC makes Decode consume the FEC packets sent by an Encoder with
+// SetFEC and return the Transfer packets they recover in place of the lost
+// ones. Recovered packets come after the rest of their group and are
+// validated and reported to Metrics like received ones; a lost packet
+// arriving after it was recovered is dropped. A group whose parity is
+// inconsistent or corrupt is dropped and its error kept for RecentErrors;
+// Decode goes on with the next packet.
+func (d *Decoder) EnableFEC() {
+	d.fec = newFECDecoder()
+}
+
+// SetFramePrefix makes Decode read packets framed by an Encoder with the
+// same prefix width. A frame whose length differs from the one of the
+// packet it holds fails with ErrorFrameLenMismatch.
+func (d *Decoder) SetFramePrefix(width int) error {
+	if err := checkFramePrefix(width); err != nil {
+		return err
+	}
+	d.prefix = width
+	return nil
+}
+
+// Use appends interceptors to the chain run by Decode.
+func (d *Decoder) Use(interceptors ...DecodeInterceptor) {
+	d.Interceptors = append(d.Interceptors, interceptors...)
+}
+
+// Decode reads the next packet from the underlying stream and passes it
+// through the interceptors. What is left unread of a streamed message, see
+// RegisterStreamType, is skipped first.
+func (d *Decoder) Decode() (*Packet, error) {
+	pack, err := d.next()
+	if err != nil {
+		return nil, d.recordError(nil, err)
+	}
+	if m, ok := AsMessage[PeerInfoMessage](pack); ok && d.MaxPeerEntries > 0 && m.EntryCount() > d.MaxPeerEntries {
+		return nil, d.recordError(pack, &DecodeError{
+			Type: TypePeerInfo,
+			Err:  fmt.Errorf("%w: %d announced, at most %d", ErrorTooManyPeers, m.EntryCount(), d.MaxPeerEntries),
+		})
+	}
+	for _, intercept := range d.Interceptors {
+		in := pack
+		if pack, err = intercept(in); err != nil {
+			return nil, d.recordError(in, err)
+		}
+	}
+	if m, ok := AsMessage[ResetMessage](pack); ok && d.OnReset != nil && d.acceptReset() {
+		d.OnReset(m)
+	}
+	return pack, nil
+}
+
+// options returns the decode options set on d.
+func (d *Decoder) options() decodeOptions {
+	return decodeOptions{
+		key:      d.ReceiveKey,
+		keyFor:   d.KeyFor,
+		open:     bindOpen(d.Open, d.AssociatedData),
+		raw:      d.KeepRaw,
+		maxPlain: d.MaxDecompressedSize,
+	}
+}
+
+// decodeFrame decodes the next packet, unwrapping it from its frame when a
+// prefix is set.
+func (d *Decoder) decodeFrame() (*Packet, error) {
+	if d.prefix == 0 {
+		return decode(d.r, d.options())
+	}
+
+	frame, err := readFrame(d.r, d.prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		// an empty reader would look like the end of the stream
+		return nil, ErrorFrameLenMismatch
+	}
+	pack, err := decode(bytes.NewReader(frame), d.options())
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != int(pack.Head.Len())+int(pack.Head.Length) {
+		return nil, ErrorFrameLenMismatch
+	}
+	return pack, nil
+}
+
+func (d *Decoder) next() (*Packet, error) {
+	if !d.buffered {
+		d.buffered = true
+		if d.ReadHint > 0 {
+			d.r = bufio.NewReaderSize(d.r, d.ReadHint)
+		}
+	}
+	for {
+		if len(d.recovered) > 0 {
+			pack, err := checkRecovered(d.recovered[0])
+			d.recovered = d.recovered[1:]
+			reportDecoded(pack, err)
+			if d.Metrics != nil {
+				reportDecodedTo(d.Metrics, pack, err)
+			}
+			if err != nil {
+				// only this packet is lost
+				d.recordError(nil, err)
+				continue
+			}
+			return pack, nil
+		}
+
+		if d.pending != nil {
+			_, err := io.Copy(io.Discard, d.pending)
+			d.pending = nil
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		pack, err := d.decodeFrame()
+		if d.Metrics != nil {
+			reportDecodedTo(d.Metrics, pack, err)
+		}
+		if err == nil {
+			if r, ok := pack.Data.Msg.(io.Reader); ok {
+				d.pending = r
+			}
+		}
+		if err != nil || d.fec == nil {
+			return pack, err
+		}
+		switch pack.Data.Type {
+		case TypeTransfer:
+			if !d.fec.data(pack) {
+				// recovered already
+				continue
+			}
+		case TypeFEC:
+			if d.recovered, err = d.fec.parity(pack.Data.Msg.(FECMessage)); err != nil {
+				// only the group is lost, the stream goes on without it
+				d.recordError(pack, err)
+			}
+			continue
+		}
+		return pack, nil
+	}
+}
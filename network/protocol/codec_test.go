@@ -0,0 +1,691 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func testDirectionKeys() (initiatorSend, initiatorRecv, responderSend, responderRecv []byte) {
+	secret, salt := []byte("shared secret"), []byte("salt")
+	var err error
+	if initiatorSend, initiatorRecv, err = DeriveDirectionKeys(secret, salt, true); err != nil {
+		panic(err)
+	}
+	if responderSend, responderRecv, err = DeriveDirectionKeys(secret, salt, false); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func TestEncoderDecoderSealed(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	payload := []byte("tunnelled ip packet")
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stream.Bytes(), payload) {
+		t.Fatal("payload written in the clear")
+	}
+
+	pack, err := NewDecoder(&stream, rRecv).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pack.Data.Msg.(TransferMessage), payload) {
+		t.Fatalf("unexpected payload %q", pack.Data.Msg)
+	}
+}
+
+func TestDirectionKeysPreventReflection(t *testing.T) {
+	iSend, iRecv, rSend, rRecv := testDirectionKeys()
+	if !bytes.Equal(iSend, rRecv) || !bytes.Equal(rSend, iRecv) {
+		t.Fatal("direction keys of both sides do not pair up")
+	}
+	if bytes.Equal(iSend, iRecv) {
+		t.Fatal("send and receive keys are equal")
+	}
+
+	data, err := encode(NewTransferMessage([]byte("reflect me")), iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reflected back at the initiator, which opens with its receive key
+	if _, err = NewDecoder(bytes.NewReader(data), iRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+	if _, err = NewDecoder(bytes.NewReader(data), rRecv).Decode(); err != nil {
+		t.Fatalf("responder failed to open the packet, %v", err)
+	}
+}
+
+func TestEncoderLeavesControlMessagesPlain(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))); err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if !bytes.Equal(stream.Bytes(), plain) {
+		t.Fatal("heartbeat was sealed")
+	}
+	if _, err := NewDecoder(&stream, rRecv).Decode(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderRejectsTamperedHeader(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	pack := NewTransferMessage([]byte("payload"))
+	pack.Head.Version = FlagsVersion
+
+	data, err := encode(pack, iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[3] |= 0x80 // unused flag bit
+	if _, err = NewDecoder(bytes.NewReader(data), rRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+}
+
+func TestDecoderRejectsSealedPacketWithoutVector(t *testing.T) {
+	_, _, _, rRecv := testDirectionKeys()
+
+	// a transfer whose length leaves room for 8 bytes only, no vector
+	data := append([]byte{0, 9, CurrentVersion, TypeTransfer}, make([]byte, 8)...)
+	_, err := NewDecoder(bytes.NewReader(data), rRecv).Decode()
+	if !errors.Is(err, ErrorUnableToReadVector) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadVector, err)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Expected != bodyVectorLen || decodeErr.Got != 8 {
+		t.Fatalf("unexpected error details %#v", err)
+	}
+}
+
+func TestDecoderInterceptors(t *testing.T) {
+	errDropped := errors.New("dropped")
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	for _, pack := range []*Packet{
+		NewTransferMessage([]byte("hello")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+	} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var order []string
+	dec := NewDecoder(&stream, nil)
+	dec.Use(func(pack *Packet) (*Packet, error) {
+		order = append(order, "upper")
+		if msg, ok := AsMessage[TransferMessage](pack); ok {
+			pack.Data.Msg = TransferMessage(bytes.ToUpper(msg))
+		}
+		return pack, nil
+	}, func(pack *Packet) (*Packet, error) {
+		order = append(order, "reject")
+		if pack.Data.Type == TypeHeartbeat {
+			return nil, errDropped
+		}
+		return pack, nil
+	})
+
+	pack, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(pack.Data.Msg.(TransferMessage)); msg != "HELLO" {
+		t.Fatalf("expected rewritten payload, got %q", msg)
+	}
+	if _, err = dec.Decode(); err != errDropped {
+		t.Fatalf("expected %v, got %v", errDropped, err)
+	}
+	if len(order) != 4 || order[0] != "upper" || order[1] != "reject" {
+		t.Fatalf("interceptors ran out of order: %v", order)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	keyA, _, _, _ := testDirectionKeys()
+	keyB, _, err := DeriveDirectionKeys([]byte("other secret"), []byte("salt"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("relayed ip packet")
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, keyA).Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	pack, err := NewDecoder(&stream, keyA).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := pack.Data.Msg.(TransferMessage)
+	vectorA := pack.Data.Vector
+
+	relayed, err := Reencrypt(pack, keyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, make([]byte, len(plain))) {
+		t.Fatalf("plaintext not wiped: %q", plain)
+	}
+	if bytes.Equal(relayed.Data.Vector, vectorA) {
+		t.Fatal("vector reused")
+	}
+
+	data, err := Encode(relayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, payload) {
+		t.Fatal("payload relayed in the clear")
+	}
+	if _, err = NewDecoder(bytes.NewReader(data), keyA).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v under the old key, got %v", ErrorDecryption, err)
+	}
+	decoded, err := NewDecoder(bytes.NewReader(data), keyB).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Data.Msg.(TransferMessage), payload) {
+		t.Fatalf("unexpected payload %q", decoded.Data.Msg)
+	}
+}
+
+func TestReencryptControlMessage(t *testing.T) {
+	keyA, _, _, _ := testDirectionKeys()
+	if _, err := Reencrypt(NewOkMessage(), keyA); !errors.Is(err, ErrorNotSealed) {
+		t.Fatalf("expected %v, got %v", ErrorNotSealed, err)
+	}
+}
+
+// ctrHMAC is an encrypt-then-MAC construction standing in for an external
+// crypto backend.
+type ctrHMAC struct {
+	seals, opens int
+}
+
+func (c *ctrHMAC) tag(key, nonce, ad, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write(ad)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)[:16]
+}
+
+func (c *ctrHMAC) Seal(key, nonce, plain, ad []byte) ([]byte, error) {
+	c.seals++
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, len(plain))
+	cipher.NewCTR(block, nonce).XORKeyStream(sealed, plain)
+	return append(sealed, c.tag(key, nonce, ad, sealed)...), nil
+}
+
+func (c *ctrHMAC) Open(key, nonce, sealed, ad []byte) ([]byte, error) {
+	c.opens++
+	if len(sealed) < 16 {
+		return nil, errors.New("short message")
+	}
+	ciphertext, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+	if !hmac.Equal(tag, c.tag(key, nonce, ad, ciphertext)) {
+		return nil, errors.New("bad tag")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, nonce).XORKeyStream(ciphertext, ciphertext)
+	return ciphertext, nil
+}
+
+func TestEncoderDecoderCustomCrypto(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	payload := []byte("sealed elsewhere")
+	backend := new(ctrHMAC)
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	enc.Seal = backend.Seal
+	if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte(nil), stream.Bytes()...)
+
+	dec := NewDecoder(&stream, rRecv)
+	dec.Open = backend.Open
+	pack, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pack.Data.Msg.(TransferMessage), payload) {
+		t.Fatalf("unexpected payload %q", pack.Data.Msg)
+	}
+	if backend.seals != 1 || backend.opens != 1 {
+		t.Fatalf("backend not used: %d seals, %d opens", backend.seals, backend.opens)
+	}
+
+	// the built-in GCM can not open what the backend sealed
+	if _, err = NewDecoder(bytes.NewReader(data), rRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+	data[len(data)-1] ^= 1
+	tampered := NewDecoder(bytes.NewReader(data), rRecv)
+	tampered.Open = backend.Open
+	if _, err = tampered.Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+}
+
+func TestEncoderDecoderAssociatedData(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	payload := []byte("labelled tunnel")
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	enc.AssociatedData = []byte("tunnel-a")
+	if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+
+	for _, tc := range []struct {
+		ad  []byte
+		err error
+	}{
+		{[]byte("tunnel-a"), nil},
+		{[]byte("tunnel-b"), ErrorDecryption},
+		{nil, ErrorDecryption},
+	} {
+		dec := NewDecoder(bytes.NewReader(data), rRecv)
+		dec.AssociatedData = tc.ad
+		pack, err := dec.Decode()
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%q: expected %v, got %v", tc.ad, tc.err, err)
+		}
+		if err == nil && !bytes.Equal(pack.Data.Msg.(TransferMessage), payload) {
+			t.Fatalf("unexpected payload %q", pack.Data.Msg)
+		}
+	}
+
+	// control messages are not sealed, the context does not apply to them
+	stream.Reset()
+	if err := enc.Encode(NewOkMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDecoder(&stream, rRecv).Decode(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderEOF(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	for _, pack := range []*Packet{NewOkMessage(), NewTransferMessage([]byte("last"))} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := stream.Bytes()
+
+	dec := NewDecoder(bytes.NewReader(data), nil)
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("expected %v at the boundary, got %v", io.EOF, err)
+	}
+
+	// cut inside the header, the vector, the message and the checksum trailer
+	checked := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	checked.SetChecksum(ChecksumCRC32)
+	trailer, err := Encode(checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, truncated := range [][]byte{data[:1], data[:7], data[:12], data[:len(data)-1], trailer[:len(trailer)-2]} {
+		dec := NewDecoder(bytes.NewReader(truncated), nil)
+		var err error
+		for err == nil {
+			_, err = dec.Decode()
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d bytes: expected %v, got %v", len(truncated), io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestEncoderSetVersion(t *testing.T) {
+	for _, v := range []uint8{1, 2} {
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream, nil)
+		if err := enc.SetVersion(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(NewHeartbeatMessage(net.ParseIP("10.0.0.1"))); err != nil {
+			t.Fatal(err)
+		}
+		if got := stream.Bytes()[2]; got != v {
+			t.Fatalf("header carries version %d, expected %d", got, v)
+		}
+
+		pack, err := NewDecoder(&stream, nil).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pack.Head.Version != v {
+			t.Fatalf("decoded version %d, expected %d", pack.Head.Version, v)
+		}
+	}
+}
+
+func TestEncoderSetVersionRange(t *testing.T) {
+	enc := NewEncoder(io.Discard, nil)
+	for _, v := range []uint8{0, MaxVersion + 1} {
+		if err := enc.SetVersion(v); !errors.Is(err, ErrorUnsupportedVersion) {
+			t.Fatalf("version %d: expected %v, got %v", v, ErrorUnsupportedVersion, err)
+		}
+	}
+
+	if err := enc.SetVersion(1); err != nil {
+		t.Fatal(err)
+	}
+	pack := NewHeartbeatMessage(net.ParseIP("10.0.0.1"))
+	pack.SetChecksum(ChecksumCRC32C)
+	if err := enc.Encode(pack); !errors.Is(err, ErrorUnsupportedVersion) {
+		t.Fatalf("expected %v, got %v", ErrorUnsupportedVersion, err)
+	}
+}
+
+func TestDecoderRecentErrors(t *testing.T) {
+	const extra = 8
+	var stream bytes.Buffer
+	for i := 0; i < recentErrorsLen+extra; i++ {
+		stream.Write([]byte{0, 1, CurrentVersion, byte(200 + i)})
+	}
+
+	dec := NewDecoder(&stream, nil)
+	for i := 0; i < recentErrorsLen+extra; i++ {
+		if _, err := dec.Decode(); !errors.Is(err, ErrorUnknownType) {
+			t.Fatalf("expected %v, got %v", ErrorUnknownType, err)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+
+	records := dec.RecentErrors()
+	if len(records) != recentErrorsLen {
+		t.Fatalf("kept %d errors, expected %d", len(records), recentErrorsLen)
+	}
+	for i, record := range records {
+		if want := PacketType(200 + extra + i); record.Type != want {
+			t.Fatalf("record %d is of type %v, expected %v", i, record.Type, want)
+		}
+		if !errors.Is(record.Err, ErrorUnknownType) || record.Time.IsZero() {
+			t.Fatalf("unexpected record %+v", record)
+		}
+	}
+}
+
+func TestDecoderKeepRaw(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	compressed := NewPeerInfoMessage(net.IPv4(10, 0, 0, 2))
+	compressed.SetCompressed(CapabilityDictCompression)
+	for _, pack := range []*Packet{
+		NewTransferMessage([]byte("tunnelled ip packet")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		compressed,
+	} {
+		var body bytes.Buffer
+		pack.Data.Msg.WriteTo(&body)
+
+		for _, keys := range [][2][]byte{{nil, nil}, {iSend, rRecv}} {
+			var stream bytes.Buffer
+			if err := NewEncoder(&stream, keys[0]).Encode(pack); err != nil {
+				t.Fatal(err)
+			}
+			stream.Write(stream.Bytes())
+
+			dec := NewDecoder(&stream, keys[1])
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Data.Raw != nil {
+				t.Fatal("raw body kept by default")
+			}
+
+			dec.KeepRaw = true
+			if got, err = dec.Decode(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Data.Raw, body.Bytes()) {
+				t.Fatalf("%s: raw body %x, expected %x", typeName(got.Data.Type), got.Data.Raw, body.Bytes())
+			}
+		}
+	}
+}
+
+func TestDecodeWithKeys(t *testing.T) {
+	iSend, iRecv, _, rRecv := testDirectionKeys()
+	resolved := make(map[uint8]int)
+	keyFor := func(t uint8) []byte {
+		resolved[t]++
+		if t == TypeTransfer {
+			return rRecv
+		}
+		return nil
+	}
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	enc.Encode(NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}))
+	enc.Encode(NewTransferMessage([]byte("tunnelled ip packet")))
+
+	pack, err := DecodeWithKeys(&stream, keyFor)
+	if err != nil || pack.Data.Type != TypeHandshake {
+		t.Fatalf("expected a handshake, got %v, %v", pack, err)
+	}
+	dec := NewDecoder(&stream, nil)
+	dec.KeyFor = keyFor
+	if pack, err = dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := AsMessage[TransferMessage](pack); !ok || string(msg) != "tunnelled ip packet" {
+		t.Fatalf("unexpected transfer %#v", pack)
+	}
+	if resolved[TypeHandshake] != 1 || resolved[TypeTransfer] != 1 {
+		t.Fatalf("unexpected key resolutions %v", resolved)
+	}
+
+	// the transfer is only readable with the resolved key
+	data, err := encode(NewTransferMessage([]byte("tunnelled ip packet")), iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecodeWithKeys(bytes.NewReader(data), func(uint8) []byte { return iRecv })
+	if !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+}
+
+// frameRecorder keeps every write as a frame of its own.
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (w *frameRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.frames = append(w.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestEncoderCachedCipher(t *testing.T) {
+	iSend, iRecv, rSend, rRecv := testDirectionKeys()
+	const (
+		workers = 8
+		packets = 50
+	)
+
+	// the encoder is shared, rec collects the frames of all workers
+	rec := &frameRecorder{}
+	enc := NewEncoder(rec, iSend)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < packets; i++ {
+				if err := enc.Encode(NewTransferMessage([]byte{byte(w), byte(i)})); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a new key replaces the cached cipher
+	enc.SendKey = rSend
+	for i := 0; i < packets; i++ {
+		if err := enc.Encode(NewTransferMessage([]byte{workers, byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[[2]byte]bool)
+	for _, frame := range rec.frames {
+		key := rRecv
+		if len(seen) >= workers*packets {
+			key = iRecv
+		}
+		pack, err := NewDecoder(bytes.NewReader(frame), key).Decode()
+		if err != nil {
+			t.Fatalf("packet %d: %v", len(seen), err)
+		}
+		msg := pack.Data.Msg.(TransferMessage)
+		seen[[2]byte{msg[0], msg[1]}] = true
+	}
+	if len(seen) != (workers+1)*packets {
+		t.Fatalf("expected %d distinct packets, got %d", (workers+1)*packets, len(seen))
+	}
+}
+
+func benchmarkEncoderSealed(b *testing.B, seal SealFunc) {
+	iSend, _, _, _ := testDirectionKeys()
+	enc := NewEncoder(io.Discard, iSend)
+	enc.Seal = seal
+	pack := NewTransferMessage(make([]byte, 1400))
+
+	b.ReportAllocs()
+	b.SetBytes(1400)
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(pack); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoderSealedCached(b *testing.B) {
+	benchmarkEncoderSealed(b, nil)
+}
+
+// BenchmarkEncoderSealedUncached sets up the cipher per packet, as the
+// Encoder did before caching it.
+func BenchmarkEncoderSealedUncached(b *testing.B) {
+	benchmarkEncoderSealed(b, gcmSeal)
+}
+
+// readCounter counts the Read calls reaching the underlying reader.
+type readCounter struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+// smallPackets returns n encoded heartbeats, as they arrive on a busy link.
+func smallPackets(tb testing.TB, n int) []byte {
+	var stream bytes.Buffer
+	for i := 0; i < n; i++ {
+		if err := EncodeAndWrite(&stream, NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return stream.Bytes()
+}
+
+// decodeCountingReads decodes every packet in data with a Decoder using
+// hint and returns the reads it took.
+func decodeCountingReads(tb testing.TB, data []byte, hint int) int {
+	counter := &readCounter{r: bytes.NewReader(data)}
+	dec := NewDecoder(counter, nil)
+	dec.ReadHint = hint
+	for {
+		if _, err := dec.Decode(); err == io.EOF {
+			return counter.reads
+		} else if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestDecoderReadHint(t *testing.T) {
+	data := smallPackets(t, 100)
+	exact := decodeCountingReads(t, data, 0)
+	if exact < 200 {
+		t.Fatalf("expected at least two reads per packet without read-ahead, got %d", exact)
+	}
+	if buffered := decodeCountingReads(t, data, DefaultReadHint); buffered > len(data)/DefaultReadHint+2 {
+		t.Fatalf("expected %d byte reads with the default hint, got %d reads for %d bytes", DefaultReadHint, buffered, len(data))
+	}
+}
+
+func benchmarkDecoderReadHint(b *testing.B, hint int) {
+	data := smallPackets(b, 100)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	reads := 0
+	for i := 0; i < b.N; i++ {
+		reads += decodeCountingReads(b, data, hint)
+	}
+	b.ReportMetric(float64(reads)/float64(b.N*100), "reads/packet")
+}
+
+func BenchmarkDecoderReadHint(b *testing.B) {
+	benchmarkDecoderReadHint(b, DefaultReadHint)
+}
+
+func BenchmarkDecoderNoReadAhead(b *testing.B) {
+	benchmarkDecoderReadHint(b, 0)
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	// FlagCompressed marks a message deflated with its type's dictionary.
+	FlagCompressed uint8 = 1 << 2
+
+	// CapabilityDictCompression is advertised in the handshake by peers able
+	// to decode FlagCompressed packets.
+	CapabilityDictCompression uint8 = 1 << 0
+
+	// defaultCompressionLevel is used unless an Encoder negotiated a level.
+	defaultCompressionLevel = flate.BestCompression
+	// MaxCompressionLevel is the highest level a session may negotiate,
+	// zero disabling compression.
+	MaxCompressionLevel = flate.BestCompression
+)
+
+var (
+	ErrorCompressionLevel       = errors.New("compression level out of range")
+	ErrorCompressionUnsupported = errors.New("compression not supported for type")
+	ErrorDecompression          = errors.New("unable to decompress message")
+	ErrorDecompressionLimit     = errors.New("decompressed size limit exceeded")
+
+	// compressionDicts lists the types that may be compressed together with
+	// the preset dictionary each is deflated with.
+	compressionDicts = map[uint8][]byte{
+		TypePeerInfo: peerInfoDict(),
+		// tunnelled packets share no content worth a dictionary
+		TypeTransfer: nil,
+	}
+)
+
+// SetCompressed compresses p on encode when its type supports compression
+// and peerCaps, the capabilities the peer advertised, allow it. Otherwise
+// the packet is left plain.
+func (p *Packet) SetCompressed(peerCaps uint8) {
+	if peerCaps&CapabilityDictCompression == 0 {
+		return
+	}
+	if _, ok := compressionDicts[p.Data.Type]; !ok || p.Data.Type == TypeTransfer {
+		// transfers are only compressed by an Encoder with a negotiated
+		// level, peers merely advertising the capability may not inflate them
+		return
+	}
+	if p.Head.Version < FlagsVersion {
+		p.Head.Version = FlagsVersion
+	}
+	p.Head.Flags |= FlagCompressed
+}
+
+// CompressionLevelField builds the handshake field offering level, the
+// highest compression level the local node is willing to spend CPU on.
+func CompressionLevelField(level int) (HandshakeField, error) {
+	if level < 0 || level > MaxCompressionLevel {
+		return HandshakeField{}, fmt.Errorf("%w: %d", ErrorCompressionLevel, level)
+	}
+	return HandshakeField{Tag: HandshakeCompressionLevel, Value: []byte{byte(level)}}, nil
+}
+
+// NegotiateCompressionLevel returns the level of the session: the lower of
+// local and the level offered in the peer's handshake. Both sides compute
+// the same level from each other's offers. A peer offering none predates
+// Transfer compression and gets zero, no compression.
+func NegotiateCompressionLevel(local int, m HandshakeMessage) int {
+	offer, ok := m.Field(HandshakeCompressionLevel)
+	if !ok || len(offer) != 1 {
+		return 0
+	}
+	return max(0, min(local, int(offer[0]), MaxCompressionLevel))
+}
+
+func compressMessage(t uint8, msg Message, level int) (Message, error) {
+	dict, ok := compressionDicts[t]
+	if !ok {
+		return nil, ErrorCompressionUnsupported
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriterDict(buf, level, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = msg.WriteTo(w); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > MaxMessageLen {
+		return nil, ErrorPacketTooLarge
+	}
+	return encodedMessage(buf.Bytes()), nil
+}
+
+// decompressMessage inflates data, giving up as soon as the output exceeds
+// limit bytes. A limit of zero or above MaxMessageLen means MaxMessageLen.
+func decompressMessage(t uint8, data []byte, limit int) ([]byte, error) {
+	dict, ok := compressionDicts[t]
+	if !ok {
+		return nil, ErrorCompressionUnsupported
+	}
+	exceeded := ErrorDecompressionLimit
+	if limit <= 0 || limit >= MaxMessageLen {
+		limit, exceeded = MaxMessageLen, ErrorPacketTooLarge
+	}
+
+	r := flate.NewReaderDict(bytes.NewReader(data), dict)
+	defer r.Close()
+
+	// a corrupt or truncated stream still yields what was inflated up to the
+	// fault, that partial plaintext is wiped rather than returned
+	message, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		wipe(message)
+		return nil, fmt.Errorf("%w: %v", ErrorDecompression, err)
+	}
+	if len(message) > limit {
+		wipe(message)
+		return nil, fmt.Errorf("%w: %w, more than %d bytes", ErrorDecompression, exceeded, limit)
+	}
+	return message, nil
+}
+
+// peerInfoDict builds the preset dictionary for peer tables: entries in the
+// private ranges peers are usually addressed from.
+func peerInfoDict() []byte {
+	var dict []byte
+	for _, prefix := range [][]byte{{10, 0, 0}, {10, 0, 1}, {172, 16, 0}, {192, 168, 0}, {192, 168, 1}} {
+		for _, host := range []byte{1, 2, 3, 4} {
+			ip := net.IP(append(append([]byte(nil), prefix...), host))
+			dict = PeerEntry{PrivateIP: ip, PublicIP: ip, Port: 7000}.appendTo(dict)
+		}
+	}
+	return dict
+}
@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func testPeerTable(n int) []PeerEntry {
+	entries := make([]PeerEntry, n)
+	for i := range entries {
+		entries[i] = PeerEntry{
+			PrivateIP: net.IPv4(10, 0, byte(i/250), byte(i%250+1)),
+			PublicIP:  net.IPv4(192, 168, 1, byte(i%250+1)),
+			Port:      uint16(7000 + i%7),
+		}
+	}
+	return entries
+}
+
+func TestPeerTableCompressedRoundTrip(t *testing.T) {
+	entries := testPeerTable(200)
+	pack := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), entries)
+	plain, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.SetCompressed(CapabilityDictCompression)
+	compressed, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Fatalf("compressed packet is not smaller, %d >= %d bytes", len(compressed), len(plain))
+	}
+
+	decoded, err := Decode(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Data.Msg.(PeerInfoMessage), pack.Data.Msg.(PeerInfoMessage)) {
+		t.Fatal("peer table changed in round trip")
+	}
+	got, err := decoded.Data.Msg.(PeerInfoMessage).Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(entries) || !got[199].PublicIP.Equal(entries[199].PublicIP) || got[199].Port != entries[199].Port {
+		t.Fatalf("unexpected entries after round trip")
+	}
+}
+
+func TestPeerTableDictionaryBeatsPlainDeflate(t *testing.T) {
+	for _, n := range []int{4, 50, 500} {
+		msg := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(n)).Data.Msg
+
+		withDict, err := compressMessage(TypePeerInfo, msg, defaultCompressionLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		w, _ := flate.NewWriter(buf, flate.BestCompression)
+		msg.WriteTo(w)
+		w.Close()
+
+		if withDict.Len() >= uint16(buf.Len()) {
+			t.Errorf("%d entries: dictionary gives %d bytes, plain deflate %d", n, withDict.Len(), buf.Len())
+		}
+	}
+}
+
+func TestCompressionFallsBackToPlain(t *testing.T) {
+	pack := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(10))
+	pack.SetCompressed(0)
+	if pack.Head.Flags&FlagCompressed != 0 {
+		t.Fatal("compressed for a peer without the capability")
+	}
+
+	transfer := NewTransferMessage([]byte{1, 2, 3})
+	transfer.SetCompressed(CapabilityDictCompression)
+	if transfer.Head.Flags&FlagCompressed != 0 {
+		t.Fatal("compressed a type without dictionary")
+	}
+}
+
+func TestHandshakeCapabilities(t *testing.T) {
+	pack := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{},
+		HandshakeField{Tag: HandshakeCapabilities, Value: []byte{CapabilityDictCompression}})
+	decoded, err := encodeDecode(t, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps := decoded.Data.Msg.(HandshakeMessage).Capabilities(); caps != CapabilityDictCompression {
+		t.Fatalf("unexpected capabilities %08b", caps)
+	}
+}
+
+func TestDecodeTruncatedCompressedBody(t *testing.T) {
+	pack := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(20))
+	pack.SetCompressed(CapabilityDictCompression)
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the deflate stream short and make the header agree
+	const cut = 8
+	data = data[:len(data)-cut]
+	binary.BigEndian.PutUint16(data, binary.BigEndian.Uint16(data)-cut)
+
+	decoded, err := Decode(bytes.NewReader(data))
+	if !errors.Is(err, ErrorDecompression) {
+		t.Fatalf("expected %v, got %v", ErrorDecompression, err)
+	}
+	if decoded != nil {
+		t.Fatalf("partial packet returned: %+v", decoded)
+	}
+	if ErrorCodeFor(err) != ErrorCodeMalformed {
+		t.Fatalf("unexpected error code %d", ErrorCodeFor(err))
+	}
+}
+
+func TestNegotiateCompressionLevel(t *testing.T) {
+	handshake := func(level int) HandshakeMessage {
+		field, err := CompressionLevelField(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}, field).Data.Msg.(HandshakeMessage)
+	}
+
+	local, remote := 3, 7
+	if a, b := NegotiateCompressionLevel(local, handshake(remote)), NegotiateCompressionLevel(remote, handshake(local)); a != 3 || b != 3 {
+		t.Fatalf("peers settled on levels %d and %d, expected 3", a, b)
+	}
+	legacy := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}).Data.Msg.(HandshakeMessage)
+	if level := NegotiateCompressionLevel(local, legacy); level != 0 {
+		t.Fatalf("negotiated level %d with a peer offering none", level)
+	}
+	if _, err := CompressionLevelField(MaxCompressionLevel + 1); !errors.Is(err, ErrorCompressionLevel) {
+		t.Fatalf("expected %v, got %v", ErrorCompressionLevel, err)
+	}
+}
+
+func TestEncoderCompressionLevel(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	// compressible but not trivially so: words drawn from a small vocabulary
+	rnd := rand.New(rand.NewSource(1))
+	words := strings.Fields("mesh bird peer tunnel packet route table node relay session key vector")
+	var payload []byte
+	for len(payload) < 32<<10 {
+		payload = append(payload, words[rnd.Intn(len(words))]...)
+		payload = append(payload, ' ')
+	}
+
+	sizes := make(map[int]int)
+	for _, level := range []int{0, 1, 9} {
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream, iSend)
+		if err := enc.SetCompressionLevel(level); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = stream.Len()
+
+		pack, err := NewDecoder(&stream, rRecv).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pack.Data.Msg.(TransferMessage), payload) {
+			t.Fatalf("level %d: payload differs after round trip", level)
+		}
+	}
+	if !(sizes[9] < sizes[1] && sizes[1] < sizes[0]) {
+		t.Fatalf("expected output to shrink with the level, got %v", sizes)
+	}
+
+	if err := NewEncoder(io.Discard, nil).SetCompressionLevel(10); !errors.Is(err, ErrorCompressionLevel) {
+		t.Fatalf("expected %v, got %v", ErrorCompressionLevel, err)
+	}
+}
+
+func TestDecoderMaxDecompressedSize(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	if err := enc.SetCompressionLevel(MaxCompressionLevel); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(NewTransferMessage(make([]byte, 60000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stream.Len() > 2*512 {
+		t.Fatalf("expected the zeros to deflate to a few bytes, got %d", stream.Len())
+	}
+
+	dec := NewDecoder(&stream, nil)
+	dec.MaxDecompressedSize = 4096
+	if _, err := dec.Decode(); !errors.Is(err, ErrorDecompressionLimit) {
+		t.Fatalf("expected %v, got %v", ErrorDecompressionLimit, err)
+	}
+
+	dec.MaxDecompressedSize = 60000
+	pack, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := pack.Data.Msg.(TransferMessage); len(msg) != 60000 {
+		t.Fatalf("expected 60000 bytes, got %d", len(msg))
+	}
+}
@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorConnBroken = errors.New("packet stream broken")
+)
+
+// PacketConn reads and writes whole packets over a byte stream connection.
+// Deadlines set on it, or on the underlying conn, apply to ReadPacket and
+// WritePacket. A deadline that expires before a packet is started can be
+// retried. Any failure after part of a packet went through the connection
+// leaves the stream out of sync, and every later call in that direction
+// fails with ErrorConnBroken.
+type PacketConn struct {
+	conn net.Conn
+	enc  *Encoder
+	dec  *Decoder
+	in   countingReader
+	out  countingWriter
+
+	readMu   sync.Mutex
+	readErr  error
+	writeMu  sync.Mutex
+	writeErr error
+}
+
+// NewPacketConn wraps conn in an Encoder and a Decoder. Transfer messages
+// are sealed with sendKey and opened with receiveKey when they are set, see
+// DeriveDirectionKeys.
+func NewPacketConn(conn net.Conn, sendKey, receiveKey []byte) *PacketConn {
+	c := &PacketConn{
+		conn: conn,
+	}
+	c.out.w = conn
+	c.enc = NewEncoder(&c.out, sendKey)
+	// read ahead below the count, which has to see the bytes of a packet
+	// as they are consumed to tell a broken stream from a clean timeout
+	c.in.r = bufio.NewReaderSize(conn, DefaultReadHint)
+	c.dec = NewDecoder(&c.in, receiveKey)
+	return c
+}
+
+// Encoder exposes the encoder, e.g. to set AssociatedData. Configure it
+// before the first WritePacket, and the Decoder to match on the peer.
+func (c *PacketConn) Encoder() *Encoder {
+	return c.enc
+}
+
+// Decoder exposes the decoder, e.g. to add interceptors.
+func (c *PacketConn) Decoder() *Decoder {
+	return c.dec
+}
+
+func (c *PacketConn) ReadPacket() (*Packet, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.readErr != nil {
+		return nil, c.readErr
+	}
+	c.in.n = 0
+	pack, err := c.dec.Decode()
+	if err != nil && c.in.n > 0 {
+		c.readErr = fmt.Errorf("%w: %v", ErrorConnBroken, err)
+	}
+	return pack, err
+}
+
+func (c *PacketConn) WritePacket(pack *Packet) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	c.out.n = 0
+	err := c.enc.Encode(pack)
+	if err != nil && c.out.n > 0 {
+		c.writeErr = fmt.Errorf("%w: %v", ErrorConnBroken, err)
+	}
+	return err
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *PacketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *PacketConn) Close() error {
+	return c.conn.Close()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPacketConnBidirectional(t *testing.T) {
+	iSend, iRecv, rSend, rRecv := testDirectionKeys()
+	a, b := net.Pipe()
+	initiator := NewPacketConn(a, iSend, iRecv)
+	responder := NewPacketConn(b, rSend, rRecv)
+	defer initiator.Close()
+	defer responder.Close()
+
+	const count = 5
+	errs := make(chan error, 2)
+	exchange := func(c *PacketConn, name string) {
+		go func() {
+			for i := 0; i < count; i++ {
+				if err := c.WritePacket(NewTransferMessage([]byte(fmt.Sprintf("%s %d", name, i)))); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	exchange(initiator, "initiator")
+	exchange(responder, "responder")
+
+	for i := 0; i < count; i++ {
+		for _, c := range []struct {
+			conn *PacketConn
+			from string
+		}{{responder, "initiator"}, {initiator, "responder"}} {
+			pack, err := c.conn.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(pack.Data.Msg.(TransferMessage)), fmt.Sprintf("%s %d", c.from, i); got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPacketConnReadDeadline(t *testing.T) {
+	a, b := net.Pipe()
+	reader := NewPacketConn(a, nil, nil)
+	writer := NewPacketConn(b, nil, nil)
+	defer reader.Close()
+	defer writer.Close()
+
+	// nothing sent yet, the read can be retried
+	reader.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := reader.ReadPacket(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", os.ErrDeadlineExceeded, err)
+	}
+	reader.SetReadDeadline(time.Time{})
+	go writer.WritePacket(NewOkMessage())
+	if _, err := reader.ReadPacket(); err != nil {
+		t.Fatalf("read after idle timeout: %v", err)
+	}
+
+	// half a packet, the stream is out of sync
+	data, _ := Encode(NewOkMessage())
+	go b.Write(data[:2])
+	reader.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := reader.ReadPacket(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", os.ErrDeadlineExceeded, err)
+	}
+	if _, err := reader.ReadPacket(); !errors.Is(err, ErrorConnBroken) {
+		t.Fatalf("expected %v, got %v", ErrorConnBroken, err)
+	}
+}
+
+func TestPacketConnAssociatedData(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	a, b := net.Pipe()
+	initiator := NewPacketConn(a, iSend, nil)
+	responder := NewPacketConn(b, nil, rRecv)
+	defer initiator.Close()
+	defer responder.Close()
+
+	// the conns seal and open through their Encoder and Decoder
+	initiator.Encoder().AssociatedData = []byte("tunnel a")
+	responder.Decoder().AssociatedData = []byte("tunnel b")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- initiator.WritePacket(NewTransferMessage([]byte("ip packet")))
+	}()
+	if _, err := responder.ReadPacket(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v for another tunnel, got %v", ErrorDecryption, err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
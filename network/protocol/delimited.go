@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxDelimitedLen is the largest frame a varint prefix may announce: a
+// header followed by a body of the maximum uint16 length.
+const maxDelimitedLen = maxHeaderLen + 1<<16 - 1
+
+var (
+	ErrorFrameTooLarge    = errors.New("delimited frame too large")
+	ErrorFrameLenMismatch = errors.New("delimited frame length mismatch")
+)
+
+// EncodeDelimited writes pack prefixed with its total length as an unsigned
+// varint, the framing used by protobuf-style length-delimited streams.
+func EncodeDelimited(w io.Writer, pack *Packet) error {
+	data, err := Encode(pack)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(data)))
+	if _, err = w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// DecodeDelimited reads one varint length-delimited packet written by
+// EncodeDelimited, opening its message with key when set. It never reads
+// past the end of the frame. io.EOF is only returned before a prefix; a
+// stream ending inside a frame fails with io.ErrUnexpectedEOF.
+func DecodeDelimited(r io.Reader, key []byte) (*Packet, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &singleByteReader{r: r}
+	}
+
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxDelimitedLen {
+		return nil, ErrorFrameTooLarge
+	}
+	if length == 0 {
+		// an empty frame would look like the end of the stream
+		return nil, ErrorFrameLenMismatch
+	}
+
+	frame := make([]byte, length)
+	if _, err = io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	pack, err := decode(bytes.NewReader(frame), decodeOptions{key: key})
+	if err != nil {
+		return nil, err
+	}
+	// the header tells the span, a streamed message is not read yet
+	if len(frame) != pack.wireLen() {
+		return nil, ErrorFrameLenMismatch
+	}
+	return pack, nil
+}
+
+// singleByteReader adapts an io.Reader to io.ByteReader without buffering, so
+// no bytes beyond the varint are consumed.
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		return 0, err
+	}
+	return s.buf[0], nil
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDelimitedRoundTrip(t *testing.T) {
+	packets := []*Packet{
+		NewOkMessage(),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		NewTransferMessage(bytes.Repeat([]byte{0xab}, 300)),
+		NewPeerInfoMessage(net.IPv4(10, 0, 0, 2)),
+	}
+
+	var stream bytes.Buffer
+	for _, pack := range packets {
+		if err := EncodeDelimited(&stream, pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hide bytes.Buffer's ReadByte to exercise the unbuffered path too
+	for _, r := range []io.Reader{bytes.NewReader(stream.Bytes()), struct{ io.Reader }{bytes.NewReader(stream.Bytes())}} {
+		for i, want := range packets {
+			got, err := DecodeDelimited(r, nil)
+			if err != nil {
+				t.Fatalf("packet %d: %v", i, err)
+			}
+			if !samePacket(t, want, got) {
+				t.Fatalf("packet %d: expected %+v, got %+v", i, want, got)
+			}
+		}
+		if _, err := DecodeDelimited(r, nil); err != io.EOF {
+			t.Fatalf("expected EOF at end of stream, got %v", err)
+		}
+	}
+}
+
+func TestDelimitedSealed(t *testing.T) {
+	iSend, _, rSend, rRecv := testDirectionKeys()
+	payload := []byte("tunnelled ip packet")
+
+	// frame what a keyed Encoder wrote the way EncodeDelimited does
+	var sealed bytes.Buffer
+	if err := NewEncoder(&sealed, iSend).Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	stream := append(binary.AppendUvarint(nil, uint64(sealed.Len())), sealed.Bytes()...)
+
+	pack, err := DecodeDelimited(bytes.NewReader(stream), rRecv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := AsMessage[TransferMessage](pack); !ok || !bytes.Equal(msg, payload) {
+		t.Fatalf("unexpected %#v", pack.Data.Msg)
+	}
+	if _, err := DecodeDelimited(bytes.NewReader(stream), rSend); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v with the wrong key, got %v", ErrorDecryption, err)
+	}
+}
+
+func TestDecodeDelimitedLengthMismatch(t *testing.T) {
+	data, err := Encode(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte{byte(len(data) + 1)}, append(data, 0)...)
+
+	if _, err = DecodeDelimited(bytes.NewReader(frame), nil); err != ErrorFrameLenMismatch {
+		t.Fatalf("expected %v, got %v", ErrorFrameLenMismatch, err)
+	}
+}
+
+func TestDecodeDelimitedEmptyFrame(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteByte(0)
+	if err := EncodeDelimited(&stream, NewOkMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeDelimited(&stream, nil); err != ErrorFrameLenMismatch {
+		t.Fatalf("expected %v, got %v", ErrorFrameLenMismatch, err)
+	}
+}
+
+func TestDecodeDelimitedTruncated(t *testing.T) {
+	data, err := Encode(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte{byte(len(data))}, data...)
+
+	// cut right after the prefix and inside the packet
+	for _, n := range []int{1, 2} {
+		if _, err := DecodeDelimited(bytes.NewReader(frame[:n]), nil); err != io.ErrUnexpectedEOF {
+			t.Fatalf("%d bytes: expected %v, got %v", n, io.ErrUnexpectedEOF, err)
+		}
+	}
+	if _, err := DecodeDelimited(bytes.NewReader(nil), nil); err != io.EOF {
+		t.Fatalf("expected %v before a prefix, got %v", io.EOF, err)
+	}
+}
+
+func TestDecodeDelimitedTooLarge(t *testing.T) {
+	if _, err := DecodeDelimited(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x7f}), nil); err != ErrorFrameTooLarge {
+		t.Fatalf("expected %v, got %v", ErrorFrameTooLarge, err)
+	}
+}
+
+func samePacket(t *testing.T, a, b *Packet) bool {
+	encA, err := Encode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encB, err := Encode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(encA, encB)
+}
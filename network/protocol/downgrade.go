@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorInsufficientSecurity = errors.New("session security below the required minimum")
+)
+
+type (
+	// SessionSecurity is the protocol version and cipher suite of a session.
+	// Higher versions and suites are the stronger ones.
+	SessionSecurity struct {
+		Version uint8
+		Cipher  CipherSuite
+	}
+
+	// Negotiator settles the version and cipher suite of a session with the
+	// peer's handshake. Both sides agree on the weaker of their preferences,
+	// which an attacker tampering with the handshake can abuse to force a
+	// weak session: a result weaker than Preferred is logged as a possible
+	// downgrade and reported to OnDowngrade. A result below
+	// MinSecurityLevel is refused with ErrorInsufficientSecurity; the zero
+	// value accepts any.
+	Negotiator struct {
+		Preferred        SessionSecurity
+		MinSecurityLevel SessionSecurity
+		OnDowngrade      func(from, to SessionSecurity)
+	}
+)
+
+// CipherSuiteField builds the handshake field announcing the strongest
+// cipher suite the local node seals with.
+func CipherSuiteField(suite CipherSuite) HandshakeField {
+	return HandshakeField{Tag: HandshakeCipherSuite, Value: []byte{byte(suite)}}
+}
+
+// CipherSuite returns the cipher suite the peer announced. A peer announcing
+// none predates the field and seals with AES-256-GCM.
+func (m HandshakeMessage) CipherSuite() CipherSuite {
+	if suite, ok := m.Field(HandshakeCipherSuite); ok && len(suite) == 1 {
+		return CipherSuite(suite[0])
+	}
+	return CipherAES256GCM
+}
+
+func NewNegotiator(preferred SessionSecurity, onDowngrade func(from, to SessionSecurity)) *Negotiator {
+	return &Negotiator{
+		Preferred:   preferred,
+		OnDowngrade: onDowngrade,
+	}
+}
+
+// Negotiate returns the security of the session with the peer that sent hs:
+// the lower of the preferred version and the one of the handshake header,
+// and the weaker of the preferred cipher suite and the one announced.
+func (n *Negotiator) Negotiate(hs *Packet) (SessionSecurity, error) {
+	offered, err := offeredSecurity(hs)
+	if err != nil {
+		return SessionSecurity{}, err
+	}
+	agreed := SessionSecurity{
+		Version: min(n.Preferred.Version, offered.Version),
+		Cipher:  min(n.Preferred.Cipher, offered.Cipher),
+	}
+	if agreed.weakerThan(n.Preferred) {
+		logger.Warning("session downgraded from %s to %s, the handshake may have been tampered with", n.Preferred, agreed)
+		if n.OnDowngrade != nil {
+			n.OnDowngrade(n.Preferred, agreed)
+		}
+	}
+	if agreed.weakerThan(n.MinSecurityLevel) {
+		return SessionSecurity{}, fmt.Errorf("%w: %s, required %s", ErrorInsufficientSecurity, agreed, n.MinSecurityLevel)
+	}
+	return agreed, nil
+}
+
+// MinSecurityInterceptor rejects handshakes offering less than min, before
+// the session is negotiated. Installed on the Decoder of incoming
+// connections, it refuses peers too old or too weak for the local policy.
+func MinSecurityInterceptor(min SessionSecurity) DecodeInterceptor {
+	return func(pack *Packet) (*Packet, error) {
+		if pack.Data.Type != TypeHandshake {
+			return pack, nil
+		}
+		offered, err := offeredSecurity(pack)
+		if err != nil {
+			return nil, err
+		}
+		if offered.weakerThan(min) {
+			return nil, &DecodeError{Type: TypeHandshake, Err: fmt.Errorf("%w: %s offered, required %s", ErrorInsufficientSecurity, offered, min)}
+		}
+		return pack, nil
+	}
+}
+
+// offeredSecurity returns the version of the handshake hs and the cipher
+// suite it announces.
+func offeredSecurity(hs *Packet) (SessionSecurity, error) {
+	m, ok := AsMessage[HandshakeMessage](hs)
+	if !ok {
+		return SessionSecurity{}, fmt.Errorf("negotiating with a %s packet", typeName(hs.Data.Type))
+	}
+	return SessionSecurity{Version: hs.Head.Version, Cipher: m.CipherSuite()}, nil
+}
+
+// weakerThan reports whether s falls short of o in version or cipher suite.
+func (s SessionSecurity) weakerThan(o SessionSecurity) bool {
+	return s.Version < o.Version || s.Cipher < o.Cipher
+}
+
+func (s SessionSecurity) String() string {
+	return fmt.Sprintf("version %d with %s", s.Version, s.Cipher)
+}
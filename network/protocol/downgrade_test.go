@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestNegotiatorDowngrade(t *testing.T) {
+	preferred := SessionSecurity{Version: FlagsVersion, Cipher: CipherAES256GCM}
+	handshake := func(version uint8, fields ...HandshakeField) *Packet {
+		pack := NewHandshakePacket(make([]byte, sessionKeyLen), &secure.NetworkSecret{}, fields...)
+		pack.Head.Version = version
+		got, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		name      string
+		hs        *Packet
+		agreed    SessionSecurity
+		downgrade bool
+	}{
+		{"same strength", handshake(FlagsVersion, CipherSuiteField(CipherAES256GCM)), preferred, false},
+		{"stronger peer", handshake(ReservedVersion), preferred, false},
+		{"older version", handshake(CurrentVersion), SessionSecurity{CurrentVersion, CipherAES256GCM}, true},
+		{"weaker cipher", handshake(FlagsVersion, CipherSuiteField(CipherNone)), SessionSecurity{FlagsVersion, CipherNone}, true},
+	} {
+		var calls []SessionSecurity
+		n := NewNegotiator(preferred, func(from, to SessionSecurity) {
+			calls = append(calls, from, to)
+		})
+		agreed, err := n.Negotiate(tc.hs)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if agreed != tc.agreed {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.agreed, agreed)
+		}
+		if !tc.downgrade && len(calls) != 0 {
+			t.Fatalf("%s: unexpected downgrade %v", tc.name, calls)
+		}
+		if tc.downgrade && (len(calls) != 2 || calls[0] != preferred || calls[1] != agreed) {
+			t.Fatalf("%s: expected a downgrade from %s to %s, got %v", tc.name, preferred, agreed, calls)
+		}
+	}
+
+	if _, err := NewNegotiator(preferred, nil).Negotiate(NewOkMessage()); err == nil {
+		t.Fatal("expected negotiating with an ok to fail")
+	}
+}
+
+func TestMinSecurityLevel(t *testing.T) {
+	policy := SessionSecurity{Version: FlagsVersion, Cipher: CipherAES256GCM}
+	handshake := func(version uint8, suite CipherSuite) []byte {
+		pack := NewHandshakePacket(make([]byte, sessionKeyLen), &secure.NetworkSecret{}, CipherSuiteField(suite))
+		pack.Head.Version = version
+		data, err := Encode(pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, tc := range []struct {
+		name      string
+		version   uint8
+		suite     CipherSuite
+		compliant bool
+	}{
+		{"compliant", FlagsVersion, CipherAES256GCM, true},
+		{"old version", CurrentVersion, CipherAES256GCM, false},
+		{"no cipher", FlagsVersion, CipherNone, false},
+	} {
+		dec := NewDecoder(bytes.NewReader(handshake(tc.version, tc.suite)), nil)
+		dec.Use(MinSecurityInterceptor(policy))
+		pack, err := dec.Decode()
+		if tc.compliant && err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !tc.compliant {
+			if !errors.Is(err, ErrorInsufficientSecurity) {
+				t.Fatalf("%s: expected %v, got %v", tc.name, ErrorInsufficientSecurity, err)
+			}
+			if pack, err = Decode(bytes.NewReader(handshake(tc.version, tc.suite))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the negotiator holds the session to the same policy
+		n := NewNegotiator(SessionSecurity{Version: ReservedVersion, Cipher: CipherAES256GCM}, nil)
+		n.MinSecurityLevel = policy
+		_, err = n.Negotiate(pack)
+		if tc.compliant != (err == nil) || !tc.compliant && !errors.Is(err, ErrorInsufficientSecurity) {
+			t.Fatalf("%s: negotiated with %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/meshbird/meshbird/log"
+)
+
+type countingFormatter struct {
+	n int64
+}
+
+func (f *countingFormatter) Format(out io.Writer, level int, channel string, msg string) {
+	atomic.AddInt64(&f.n, 1)
+}
+
+func TestEncodeIsPureAndReentrant(t *testing.T) {
+	formatter := &countingFormatter{}
+	prevFormatter, prevLevel := logger.Formatter(), logger.Level()
+	logger.SetFormatter(formatter)
+	logger.SetLevel(log.LevelDebug)
+	defer func() {
+		logger.SetFormatter(prevFormatter)
+		logger.SetLevel(prevLevel)
+	}()
+
+	shared := NewTransferMessage(bytes.Repeat([]byte{0x42}, 1400))
+	want, err := Encode(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				got, err := Encode(shared)
+				if err != nil || !bytes.Equal(got, want) {
+					errs <- fmt.Errorf("goroutine %d: shared packet encoded differently, %v", i, err)
+					return
+				}
+
+				var buf bytes.Buffer
+				own := NewHeartbeatMessage(net.IPv4(10, 0, byte(i), byte(j)))
+				if err = EncodeTo(&buf, own); err != nil {
+					errs <- err
+					return
+				}
+				if ip := buf.Bytes()[4:]; !bytes.Equal(ip, []byte{10, 0, byte(i), byte(j)}) {
+					errs <- fmt.Errorf("goroutine %d: unexpected heartbeat payload %v", i, ip)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt64(&formatter.n); n != 0 {
+		t.Fatalf("Encode logged %d messages", n)
+	}
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	peers := make(map[string]PeerEntry)
+	for i := 1; i <= 16; i++ {
+		ip := net.IPv4(10, 0, 0, byte(i))
+		peers[ip.String()] = PeerEntry{PrivateIP: ip, PublicIP: net.IPv4(203, 0, 113, byte(17-i)), Port: uint16(7000 + i)}
+	}
+	// map iteration hands out the peers in a different order every time
+	collect := func() []PeerEntry {
+		var entries []PeerEntry
+		for _, entry := range peers {
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	iSend, _, _, _ := testDirectionKeys()
+	vector := randomBytes(bodyVectorLen)
+
+	build := map[string]func() *Packet{
+		"peer table": func() *Packet {
+			return NewPeerTableMessage(net.IPv4(10, 0, 0, 1), collect())
+		},
+		"heartbeat delta": func() *Packet {
+			pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+			if err := pack.SetPeerDelta(collect()); err != nil {
+				t.Fatal(err)
+			}
+			return pack
+		},
+		"sealed transfer": func() *Packet {
+			pack := NewTransferMessage(bytes.Repeat([]byte("tunnelled ip packet "), 20))
+			pack.Data.Vector = vector
+			pack.SetChecksum(ChecksumCRC32C)
+			return pack
+		},
+	}
+	for name, newPacket := range build {
+		var want []byte
+		for i := 0; i < 100; i++ {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf, iSend)
+			if err := enc.SetCompressionLevel(MaxCompressionLevel); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Encode(newPacket()); err != nil {
+				t.Fatal(err)
+			}
+			if want == nil {
+				want = buf.Bytes()
+			} else if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("%s: encoding %d differs", name, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	ErrorCodeUnknown uint8 = iota
+	ErrorCodeUnknownType
+	ErrorCodeMalformed
+	ErrorCodeInvalidPayload
+)
+
+// maxErrorDetailLen bounds the detail text so that an error report can not
+// be used to echo large amounts of data back to a peer.
+const maxErrorDetailLen = 128
+
+type (
+	// DecodeError is returned by Decode when a packet was read but could not
+	// be understood. Plain I/O errors, such as io.EOF, are never wrapped.
+	// Expected and Got are the byte counts of a short read, zero otherwise.
+	DecodeError struct {
+		Type     uint8
+		Err      error
+		Expected int
+		Got      int
+	}
+
+	// ErrorMessage reports a protocol error back to the peer: a one byte
+	// error code followed by an optional human readable detail.
+	ErrorMessage []byte
+)
+
+func (e *DecodeError) Error() string {
+	if e.Expected != e.Got {
+		return fmt.Sprintf("%s: %v, expected %d bytes, got %d", typeName(e.Type), e.Err, e.Expected, e.Got)
+	}
+	return fmt.Sprintf("%s: %v", typeName(e.Type), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorMessage builds the packet telling the peer why its packet was dropped.
+func (e *DecodeError) ErrorMessage() *Packet {
+	return NewErrorMessage(ErrorCodeFor(e), e.Error())
+}
+
+// ErrorCodeFor maps the sentinel errors of this package to error codes.
+func ErrorCodeFor(err error) uint8 {
+	switch {
+	case errors.Is(err, ErrorUnknownType):
+		return ErrorCodeUnknownType
+	case errors.Is(err, ErrorUnableToReadVector), errors.Is(err, ErrorUnableToReadMessage),
+		errors.Is(err, ErrorInvalidReadSize), errors.Is(err, ErrorLengthMismatch),
+		errors.Is(err, ErrorDecompression):
+		return ErrorCodeMalformed
+	case errors.Is(err, ErrorInvalidPayload):
+		return ErrorCodeInvalidPayload
+	}
+	return ErrorCodeUnknown
+}
+
+func NewErrorMessage(code uint8, detail string) *Packet {
+	if len(detail) > maxErrorDetailLen {
+		detail = detail[:maxErrorDetailLen]
+	}
+
+	body := Body{
+		Type: TypeError,
+		Msg:  ErrorMessage(append([]byte{code}, detail...)),
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+func (m ErrorMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m ErrorMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m ErrorMessage) Code() uint8 {
+	return m[0]
+}
+
+func (m ErrorMessage) Detail() string {
+	return string(m[1:])
+}
+
+func decodeError(data []byte) (Message, error) {
+	return ErrorMessage(data), nil
+}
+
+func validateError(msg Message) error {
+	if msg.Len() == 0 {
+		return fmt.Errorf("error message without code")
+	}
+	if msg.Len() > 1+maxErrorDetailLen {
+		return fmt.Errorf("error detail too long, %d bytes", msg.Len()-1)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestErrorMessageRoundTrip(t *testing.T) {
+	pack, err := encodeDecode(t, NewErrorMessage(ErrorCodeMalformed, "short vector"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := AsMessage[ErrorMessage](pack)
+	if !ok {
+		t.Fatalf("expected error message, got %T", pack.Data.Msg)
+	}
+	if msg.Code() != ErrorCodeMalformed || msg.Detail() != "short vector" {
+		t.Fatalf("unexpected error message %d %q", msg.Code(), msg.Detail())
+	}
+}
+
+func TestErrorMessageDetailIsBounded(t *testing.T) {
+	pack, err := encodeDecode(t, NewErrorMessage(ErrorCodeUnknown, strings.Repeat("x", 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail := pack.Data.Msg.(ErrorMessage).Detail(); len(detail) != maxErrorDetailLen {
+		t.Fatalf("expected detail truncated to %d bytes, got %d", maxErrorDetailLen, len(detail))
+	}
+}
+
+func TestErrorMessageFromDecodeError(t *testing.T) {
+	_, err := Decode(bytes.NewReader([]byte{0, 1, CurrentVersion, 250}))
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+
+	pack, err := encodeDecode(t, decodeErr.ErrorMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := pack.Data.Msg.(ErrorMessage)
+	if msg.Code() != ErrorCodeUnknownType {
+		t.Fatalf("expected code %d, got %d", ErrorCodeUnknownType, msg.Code())
+	}
+	if msg.Detail() != decodeErr.Error() {
+		t.Fatalf("expected detail %q, got %q", decodeErr.Error(), msg.Detail())
+	}
+}
+
+func TestErrorCodeFor(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code uint8
+	}{
+		{ErrorUnknownType, ErrorCodeUnknownType},
+		{ErrorUnableToReadVector, ErrorCodeMalformed},
+		{ErrorUnableToReadMessage, ErrorCodeMalformed},
+		{ErrorLengthMismatch, ErrorCodeMalformed},
+		{&DecodeError{Type: TypeOk, Err: fmt.Errorf("%w: bad", ErrorInvalidPayload)}, ErrorCodeInvalidPayload},
+		{io.EOF, ErrorCodeUnknown},
+	} {
+		if code := ErrorCodeFor(tc.err); code != tc.code {
+			t.Errorf("%v: expected code %d, got %d", tc.err, tc.code, code)
+		}
+	}
+}
+
+func TestDecodeDoesNotWrapIOErrors(t *testing.T) {
+	if _, err := Decode(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestDecodeTruncatedVectorReportsLengths(t *testing.T) {
+	data, err := Encode(NewTransferMessage([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header (3) + type (1) + the first 10 of 16 vector bytes
+	truncated := data[:4+10]
+
+	_, err = Decode(bytes.NewReader(truncated))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+	if !errors.Is(err, ErrorUnableToReadVector) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadVector, err)
+	}
+	if decodeErr.Expected != 16 || decodeErr.Got != 10 {
+		t.Fatalf("expected 16/10 bytes, got %d/%d", decodeErr.Expected, decodeErr.Got)
+	}
+	if !strings.Contains(err.Error(), "expected 16 bytes, got 10") {
+		t.Fatalf("lengths missing from error %q", err)
+	}
+}
+
+func TestDecodeTruncatedMessageReportsLengths(t *testing.T) {
+	data, err := Encode(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Decode(bytes.NewReader(data[:len(data)-1]))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Expected != 2 || decodeErr.Got != 1 {
+		t.Fatalf("expected short message read 2/1, got %v", err)
+	}
+}
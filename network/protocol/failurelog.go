@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/meshbird/meshbird/log"
+)
+
+// failurePrefixLen bounds the input logged along with a decode failure:
+// the header, the type and a vector, never a whole payload.
+const failurePrefixLen = 32
+
+// prefixRecorder keeps the first bytes read through it.
+type prefixRecorder struct {
+	r   io.Reader
+	buf [failurePrefixLen]byte
+	n   int
+}
+
+func (p *prefixRecorder) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += copy(p.buf[p.n:], b[:n])
+	return n, err
+}
+
+// logDecodeFailure logs err as a structured debug event with the type of the
+// packet when it was read and the hex of the first bytes of the input.
+func logDecodeFailure(err error, prefix []byte) {
+	fields := log.Fields{
+		"error":  err,
+		"prefix": hex.EncodeToString(prefix),
+	}
+	var errDecode *DecodeError
+	if errors.As(err, &errDecode) {
+		fields["type"] = PacketType(errDecode.Type)
+	} else if t, err := PeekType(prefix); err == nil {
+		fields["type"] = PacketType(t)
+	}
+	log.LogEvent(logger, log.LevelDebug, "unable to decode packet", fields)
+}
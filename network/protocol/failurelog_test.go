@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/meshbird/meshbird/log"
+)
+
+type eventRecorder struct {
+	events []log.Fields
+}
+
+func (r *eventRecorder) Format(out io.Writer, level int, channel string, msg string) {}
+
+func (r *eventRecorder) FormatEvent(out io.Writer, level int, channel string, msg string, fields log.Fields) {
+	r.events = append(r.events, fields)
+}
+
+func TestDecodeFailureEvent(t *testing.T) {
+	recorder := &eventRecorder{}
+	formatter, level := logger.Formatter(), logger.Level()
+	logger.SetFormatter(recorder)
+	defer logger.SetFormatter(formatter)
+	defer logger.SetLevel(level)
+
+	iSend, iRecv, _, _ := testDirectionKeys()
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewTransferMessage(bytes.Repeat([]byte{0xab}, 1000))); err != nil {
+		t.Fatal(err)
+	}
+	sealed := stream.Bytes()
+
+	// nothing is recorded below debug
+	logger.SetLevel(log.LevelInfo)
+	if _, err := NewDecoder(bytes.NewReader(sealed), iRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+	if len(recorder.events) != 0 {
+		t.Fatalf("unexpected events %v", recorder.events)
+	}
+
+	logger.SetLevel(log.LevelDebug)
+	NewDecoder(bytes.NewReader(sealed), iRecv).Decode()
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected one event, got %v", recorder.events)
+	}
+	event := recorder.events[0]
+	if err, _ := event["error"].(error); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, event["error"])
+	}
+	if event["type"] != PacketType(TypeTransfer) {
+		t.Fatalf("expected a transfer, got %v", event["type"])
+	}
+	if event["prefix"] != hex.EncodeToString(sealed[:failurePrefixLen]) {
+		t.Fatalf("expected the hex of the first %d bytes, got %v", failurePrefixLen, event["prefix"])
+	}
+
+	// the type is taken from the input when the error does not carry it
+	Decode(bytes.NewReader(sealed[:10]))
+	if len(recorder.events) != 2 {
+		t.Fatalf("expected two events, got %v", recorder.events)
+	}
+	if event := recorder.events[1]; event["type"] != PacketType(TypeTransfer) || event["prefix"] != hex.EncodeToString(sealed[:10]) {
+		t.Fatalf("unexpected event %v", event)
+	}
+}
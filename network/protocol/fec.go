@@ -0,0 +1,357 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// fecHeaderLen covers the group id, the data and parity shard counts,
+	// the parity index and the shard length.
+	fecHeaderLen = 4 + 1 + 1 + 1 + 2
+	// fecShardPrefixLen is the payload length each data shard starts with.
+	fecShardPrefixLen = 2
+
+	// fecDataWindow and fecGroupWindow bound what a Decoder keeps around
+	// for recovery: the most recent Transfer payloads and parity groups.
+	fecDataWindow  = 1024
+	fecGroupWindow = 64
+)
+
+var (
+	ErrorInvalidFEC = errors.New("invalid fec configuration")
+)
+
+type (
+	// FECMessage carries one Reed-Solomon parity shard of a group of Transfer
+	// packets: the group id, the data and parity shard counts, the index of
+	// this parity shard, the shard length, the vectors identifying the data
+	// packets of the group and the parity itself.
+	//
+	// A data shard is the Transfer payload prefixed with its uint16 length and
+	// zero padded to the shard length.
+	FECMessage []byte
+
+	fecEncoder struct {
+		dataShards   int
+		parityShards int
+		group        uint32
+		vectors      [][]byte
+		shards       [][]byte
+	}
+
+	fecDecoder struct {
+		payloads    map[string][]byte
+		payloadRing []string
+		groups      map[uint32]map[int]FECMessage
+		groupRing   []uint32
+	}
+)
+
+func (m FECMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m FECMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m FECMessage) Group() uint32 {
+	return binary.BigEndian.Uint32(m)
+}
+
+func (m FECMessage) DataShards() int {
+	return int(m[4])
+}
+
+func (m FECMessage) ParityShards() int {
+	return int(m[5])
+}
+
+func (m FECMessage) Index() int {
+	return int(m[6])
+}
+
+func (m FECMessage) shardLen() int {
+	return int(binary.BigEndian.Uint16(m[7:]))
+}
+
+// Vectors returns the vectors of the data packets protected by the group.
+func (m FECMessage) Vectors() [][]byte {
+	vectors := make([][]byte, m.DataShards())
+	data := m[fecHeaderLen:]
+	for i := range vectors {
+		vectors[i] = data[:bodyVectorLen:bodyVectorLen]
+		data = data[bodyVectorLen:]
+	}
+	return vectors
+}
+
+func (m FECMessage) Parity() []byte {
+	return m[fecHeaderLen+m.DataShards()*bodyVectorLen:]
+}
+
+func decodeFEC(data []byte) (Message, error) {
+	return FECMessage(data), nil
+}
+
+func validateFEC(msg Message) error {
+	m := msg.(FECMessage)
+	if len(m) < fecHeaderLen {
+		return fmt.Errorf("fec header needs %d bytes, got %d", fecHeaderLen, len(m))
+	}
+	if m.DataShards() == 0 || m.ParityShards() == 0 || m.DataShards()+m.ParityShards() > 255 {
+		return fmt.Errorf("unsupported fec code of %d data and %d parity shards", m.DataShards(), m.ParityShards())
+	}
+	if m.Index() >= m.ParityShards() {
+		return fmt.Errorf("parity index %d out of %d", m.Index(), m.ParityShards())
+	}
+	if want := fecHeaderLen + m.DataShards()*bodyVectorLen + m.shardLen(); len(m) != want {
+		return fmt.Errorf("fec message of %d bytes, expected %d", len(m), want)
+	}
+	return nil
+}
+
+func newFECEncoder(dataShards, parityShards int) (*fecEncoder, error) {
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > 255 {
+		return nil, fmt.Errorf("%w: %d data and %d parity shards", ErrorInvalidFEC, dataShards, parityShards)
+	}
+	return &fecEncoder{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+	}, nil
+}
+
+// add records a sent Transfer packet and returns the parity packets to send
+// once its group is complete.
+func (f *fecEncoder) add(pack *Packet) ([]*Packet, error) {
+	shard := new(bytes.Buffer)
+	shard.Write([]byte{0, 0})
+	if _, err := pack.Data.Msg.WriteTo(shard); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(shard.Bytes(), uint16(shard.Len()-fecShardPrefixLen))
+
+	f.vectors = append(f.vectors, pack.Data.Vector)
+	f.shards = append(f.shards, shard.Bytes())
+	if len(f.shards) < f.dataShards {
+		return nil, nil
+	}
+
+	shardLen := 0
+	for _, shard := range f.shards {
+		shardLen = max(shardLen, len(shard))
+	}
+	for i, shard := range f.shards {
+		f.shards[i] = append(shard, make([]byte, shardLen-len(shard))...)
+	}
+
+	packs := make([]*Packet, f.parityShards)
+	for i := range packs {
+		msg := make(FECMessage, fecHeaderLen, fecHeaderLen+f.dataShards*bodyVectorLen+shardLen)
+		binary.BigEndian.PutUint32(msg, f.group)
+		msg[4], msg[5], msg[6] = byte(f.dataShards), byte(f.parityShards), byte(i)
+		binary.BigEndian.PutUint16(msg[7:], uint16(shardLen))
+		for _, vector := range f.vectors {
+			msg = append(msg, vector...)
+		}
+
+		parity := make([]byte, shardLen)
+		for j, shard := range f.shards {
+			gfMulAdd(parity, shard, cauchy(i, j, f.dataShards))
+		}
+		msg = append(msg, parity...)
+		wipe(parity)
+
+		body := Body{
+			Type:   TypeFEC,
+			Vector: randomBytes(bodyVectorLen),
+			Msg:    msg,
+		}
+		packs[i] = &Packet{
+			Head: Header{
+				Length:  body.Len(),
+				Version: CurrentVersion,
+			},
+			Data: body,
+		}
+	}
+
+	for _, shard := range f.shards {
+		wipe(shard)
+	}
+	f.group++
+	f.vectors, f.shards = nil, nil
+	return packs, nil
+}
+
+func newFECDecoder() *fecDecoder {
+	return &fecDecoder{
+		payloads: make(map[string][]byte),
+		groups:   make(map[uint32]map[int]FECMessage),
+	}
+}
+
+// data records a received Transfer packet for later recovery, reporting
+// false when its vector is known already: the packet was recovered before
+// it arrived, or arrived twice.
+func (f *fecDecoder) data(pack *Packet) bool {
+	if _, ok := f.payloads[string(pack.Data.Vector)]; ok {
+		return false
+	}
+	var payload bytes.Buffer
+	pack.Data.Msg.WriteTo(&payload)
+	f.remember(pack.Data.Vector, payload.Bytes())
+	return true
+}
+
+func (f *fecDecoder) remember(vector, payload []byte) {
+	key := string(vector)
+	if _, ok := f.payloads[key]; ok {
+		return
+	}
+	if len(f.payloadRing) == fecDataWindow {
+		wipe(f.payloads[f.payloadRing[0]])
+		delete(f.payloads, f.payloadRing[0])
+		f.payloadRing = f.payloadRing[1:]
+	}
+	f.payloads[key] = payload
+	f.payloadRing = append(f.payloadRing, key)
+}
+
+// parity records a parity shard and returns the Transfer packets of its
+// group that it, together with the shards seen before, recovers. A group
+// that turns out to be unrecoverable, its parity being inconsistent or
+// corrupt, is dropped along with the error.
+func (f *fecDecoder) parity(m FECMessage) ([]*Packet, error) {
+	recovered, err := f.recover(m)
+	if err != nil {
+		f.drop(m.Group())
+		return nil, err
+	}
+	return recovered, nil
+}
+
+// drop forgets the parity shards of group.
+func (f *fecDecoder) drop(group uint32) {
+	delete(f.groups, group)
+	for i, id := range f.groupRing {
+		if id == group {
+			f.groupRing = append(f.groupRing[:i], f.groupRing[i+1:]...)
+			break
+		}
+	}
+}
+
+func (f *fecDecoder) recover(m FECMessage) ([]*Packet, error) {
+	group, ok := f.groups[m.Group()]
+	if !ok {
+		if len(f.groupRing) == fecGroupWindow {
+			delete(f.groups, f.groupRing[0])
+			f.groupRing = f.groupRing[1:]
+		}
+		group = make(map[int]FECMessage)
+		f.groups[m.Group()] = group
+		f.groupRing = append(f.groupRing, m.Group())
+	}
+	group[m.Index()] = m
+
+	k, shardLen := m.DataShards(), m.shardLen()
+	vectors := m.Vectors()
+
+	var missing []int
+	shards := make([][]byte, 0, k)
+	matrix := make([][]byte, 0, k)
+	for j, vector := range vectors {
+		payload, ok := f.payloads[string(vector)]
+		if !ok {
+			missing = append(missing, j)
+			continue
+		}
+		if len(payload)+fecShardPrefixLen > shardLen {
+			return nil, fmt.Errorf("%w: payload longer than its fec shard", ErrorInvalidFEC)
+		}
+		shard := make([]byte, shardLen)
+		binary.BigEndian.PutUint16(shard, uint16(len(payload)))
+		copy(shard[fecShardPrefixLen:], payload)
+		shards = append(shards, shard)
+
+		row := make([]byte, k)
+		row[j] = 1
+		matrix = append(matrix, row)
+	}
+	if len(missing) == 0 || len(missing) > len(group) {
+		return nil, nil
+	}
+
+	for i, parity := range group {
+		if len(matrix) == k {
+			break
+		}
+		if parity.DataShards() != k || parity.shardLen() != shardLen {
+			return nil, fmt.Errorf("%w: inconsistent parity in group %d", ErrorInvalidFEC, m.Group())
+		}
+		row := make([]byte, k)
+		for j := range row {
+			row[j] = cauchy(i, j, k)
+		}
+		matrix = append(matrix, row)
+		shards = append(shards, parity.Parity())
+	}
+
+	defer func() {
+		for _, shard := range shards[:len(shards)-len(missing)] {
+			wipe(shard)
+		}
+	}()
+
+	inv, err := gfInvert(matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	recovered := make([]*Packet, 0, len(missing))
+	for _, j := range missing {
+		shard := make([]byte, shardLen)
+		for l, src := range shards {
+			gfMulAdd(shard, src, inv[j][l])
+		}
+		payloadLen := int(binary.BigEndian.Uint16(shard))
+		if payloadLen+fecShardPrefixLen > shardLen {
+			return nil, fmt.Errorf("%w: recovered shard of group %d is corrupt", ErrorInvalidFEC, m.Group())
+		}
+		payload := shard[fecShardPrefixLen : fecShardPrefixLen+payloadLen]
+		// the window wipes what it evicts, keep the returned payload apart
+		f.remember(vectors[j], bytes.Clone(payload))
+
+		body := Body{
+			Type:   TypeTransfer,
+			Vector: vectors[j],
+			Msg:    TransferMessage(payload),
+		}
+		recovered = append(recovered, &Packet{
+			Head: Header{
+				Length:  body.Len(),
+				Version: CurrentVersion,
+			},
+			Data: body,
+		})
+	}
+	return recovered, nil
+}
+
+// checkRecovered runs the message of pack, a Transfer recovered from
+// parity, through the decoder and validator of its type like that of a
+// received packet.
+func checkRecovered(pack *Packet) (*Packet, error) {
+	msg, err := decodeMessage(pack.Data.Type, pack.Data.Msg.(TransferMessage))
+	if err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+	pack.Data.Msg = msg
+	return pack, nil
+}
@@ -0,0 +1,257 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// fecDatagrams encodes count Transfer packets with FEC, one datagram each.
+func fecDatagrams(t *testing.T, key []byte, dataShards, parityShards, count int) ([][]byte, [][]byte) {
+	var payloads [][]byte
+	w := new(datagramWriter)
+	enc := NewEncoder(w, key)
+	if err := enc.SetFEC(dataShards, parityShards); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		payload := bytes.Repeat([]byte(fmt.Sprint(i)), i+1)
+		payloads = append(payloads, payload)
+		if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.datagrams, payloads
+}
+
+type datagramWriter struct {
+	datagrams [][]byte
+}
+
+func (w *datagramWriter) Write(p []byte) (int, error) {
+	w.datagrams = append(w.datagrams, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func decodeAll(t *testing.T, datagrams [][]byte, key []byte) [][]byte {
+	var stream bytes.Buffer
+	for _, datagram := range datagrams {
+		stream.Write(datagram)
+	}
+	dec := NewDecoder(&stream, key)
+	dec.EnableFEC()
+
+	var payloads [][]byte
+	for {
+		pack, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, pack.Data.Msg.(TransferMessage))
+	}
+	return payloads
+}
+
+func TestFECRecoversLostPacket(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	datagrams, payloads := fecDatagrams(t, iSend, 4, 1, 4)
+	if len(datagrams) != 5 {
+		t.Fatalf("expected 4 data and 1 parity datagrams, got %d", len(datagrams))
+	}
+
+	for lost := 0; lost < 4; lost++ {
+		received := append(append([][]byte(nil), datagrams[:lost]...), datagrams[lost+1:]...)
+		got := decodeAll(t, received, rRecv)
+		if len(got) != 4 {
+			t.Fatalf("lost %d: expected 4 payloads, got %d", lost, len(got))
+		}
+		// the recovered packet comes after the rest of its group
+		if !bytes.Equal(got[3], payloads[lost]) {
+			t.Fatalf("lost %d: recovered %q, expected %q", lost, got[3], payloads[lost])
+		}
+	}
+}
+
+func TestFECRecoversUpToParityShards(t *testing.T) {
+	datagrams, payloads := fecDatagrams(t, nil, 5, 2, 10)
+	if len(datagrams) != 14 {
+		t.Fatalf("expected 10 data and 4 parity datagrams, got %d", len(datagrams))
+	}
+
+	// lose two packets of the first group and one of the second
+	var received [][]byte
+	for i, datagram := range datagrams {
+		if i != 1 && i != 4 && i != 8 {
+			received = append(received, datagram)
+		}
+	}
+	got := decodeAll(t, received, nil)
+	if len(got) != 10 {
+		t.Fatalf("expected 10 payloads, got %d", len(got))
+	}
+	seen := make(map[string]bool)
+	for _, payload := range got {
+		seen[string(payload)] = true
+	}
+	for _, payload := range payloads {
+		if !seen[string(payload)] {
+			t.Fatalf("payload %q not recovered", payload)
+		}
+	}
+}
+
+func TestFECTooManyLost(t *testing.T) {
+	datagrams, _ := fecDatagrams(t, nil, 4, 1, 4)
+	got := decodeAll(t, append([][]byte(nil), datagrams[2:]...), nil)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 payloads without recovery, got %d", len(got))
+	}
+}
+
+func TestFECLateOriginalDropped(t *testing.T) {
+	datagrams, payloads := fecDatagrams(t, nil, 2, 1, 2)
+
+	// the second packet is recovered, then arrives after all
+	var counts decodeCounts
+	var stream bytes.Buffer
+	for _, datagram := range [][]byte{datagrams[0], datagrams[2], datagrams[1]} {
+		stream.Write(datagram)
+	}
+	dec := NewDecoder(&stream, nil)
+	dec.EnableFEC()
+	dec.Metrics = &counts
+	var got [][]byte
+	for {
+		pack, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pack.Data.Msg.(TransferMessage))
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], payloads[0]) || !bytes.Equal(got[1], payloads[1]) {
+		t.Fatalf("expected each payload once, got %q", got)
+	}
+	// both transfers, the parity, the recovered packet and the late original
+	if counts.decoded != 4 || counts.failed != 0 {
+		t.Fatalf("expected 4 decoded and no failure, got %+v", counts)
+	}
+}
+
+func TestFECRecoveredPacketValidated(t *testing.T) {
+	w := new(datagramWriter)
+	enc := NewEncoder(w, nil)
+	if err := enc.SetFEC(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	// an empty payload fails the transfer validator
+	for _, payload := range []string{"payload", ""} {
+		if err := enc.Encode(NewTransferMessage([]byte(payload))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var counts decodeCounts
+	var stream bytes.Buffer
+	stream.Write(w.datagrams[0])
+	stream.Write(w.datagrams[2])
+	dec := NewDecoder(&stream, nil)
+	dec.EnableFEC()
+	dec.Metrics = &counts
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if pack, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("expected the invalid packet to be dropped, got %v, %v", pack, err)
+	}
+	records := dec.RecentErrors()
+	if len(records) != 1 || !errors.Is(records[0].Err, ErrorInvalidPayload) {
+		t.Fatalf("expected the invalid recovered packet in the recent errors, got %v", records)
+	}
+	if counts.failed != 1 {
+		t.Fatalf("expected the invalid recovered packet to be reported, got %+v", counts)
+	}
+}
+
+func TestFECInconsistentGroupDropped(t *testing.T) {
+	// two encoders both number their first group 0, with different shard
+	// lengths
+	first, _ := fecDatagrams(t, nil, 2, 2, 2)
+	w := new(datagramWriter)
+	enc := NewEncoder(w, nil)
+	if err := enc.SetFEC(2, 2); err != nil {
+		t.Fatal(err)
+	}
+	payloads := [][]byte{[]byte("longer payload"), []byte("another longer payload")}
+	for _, payload := range payloads {
+		if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := w.datagrams
+
+	var stream bytes.Buffer
+	for _, datagram := range [][]byte{first[2], second[3], second[0], second[1]} {
+		stream.Write(datagram)
+	}
+	dec := NewDecoder(&stream, nil)
+	dec.EnableFEC()
+	for i, payload := range payloads {
+		pack, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if got := pack.Data.Msg.(TransferMessage); !bytes.Equal(got, payload) {
+			t.Fatalf("packet %d: got %q", i, got)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("expected %v, got %v", io.EOF, err)
+	}
+	records := dec.RecentErrors()
+	if len(records) != 1 || !errors.Is(records[0].Err, ErrorInvalidFEC) {
+		t.Fatalf("expected the dropped group in the recent errors, got %v", records)
+	}
+	if len(dec.fec.groups) != 0 || len(dec.fec.groupRing) != 0 {
+		t.Fatalf("group kept after the error: %v", dec.fec.groupRing)
+	}
+}
+
+func TestFECParityWithoutDecoderFEC(t *testing.T) {
+	datagrams, _ := fecDatagrams(t, nil, 2, 1, 2)
+	pack, err := Decode(bytes.NewReader(datagrams[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := AsMessage[FECMessage](pack); !ok || m.DataShards() != 2 || m.ParityShards() != 1 {
+		t.Fatalf("unexpected fec packet %+v", pack.Data)
+	}
+}
+
+func TestSetFECInvalid(t *testing.T) {
+	enc := NewEncoder(new(bytes.Buffer), nil)
+	for _, shards := range [][2]int{{0, 1}, {1, 0}, {200, 56}} {
+		if err := enc.SetFEC(shards[0], shards[1]); !errors.Is(err, ErrorInvalidFEC) {
+			t.Errorf("%v: expected %v, got %v", shards, ErrorInvalidFEC, err)
+		}
+	}
+}
+
+func fecPacket(t *testing.T) *Packet {
+	fec, err := newFECEncoder(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parity, err := fec.add(NewTransferMessage([]byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parity[0]
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// bodyFieldFlags are the header flags of the optional body fields.
+const bodyFieldFlags = FlagPriority | FlagRoute | FlagTimestamp | FlagMessageID
+
+// bodyField describes an optional body field. The fields follow the type
+// byte in the order of bodyFields, each on the wire when the header carries
+// its flag. Everything reading, writing, authenticating or locating them
+// walks bodyFields, so a new field is added there alone.
+type bodyField struct {
+	flag uint8
+	len  int
+	// spans name the parts of the field in DecodeAnnotated, splitting its
+	// length evenly
+	spans []string
+	// err reports the field where it is not allowed or cut short
+	err error
+
+	present  func(b Body) bool
+	appendTo func(b Body, dst []byte) []byte
+	parse    func(b *Body, data []byte) error
+	check    func(head Header, t uint8) error
+}
+
+var bodyFields = [...]bodyField{
+	{
+		flag:     FlagPriority,
+		len:      1,
+		spans:    []string{"priority"},
+		err:      ErrorInvalidPriority,
+		present:  func(b Body) bool { return b.Priority != 0 },
+		appendTo: func(b Body, dst []byte) []byte { return append(dst, b.Priority) },
+		parse: func(b *Body, data []byte) error {
+			b.Priority = data[0]
+			return nil
+		},
+		check: checkPriority,
+	},
+	{
+		flag:     FlagRoute,
+		len:      routeLen,
+		spans:    []string{"source", "destination"},
+		err:      ErrorInvalidRoute,
+		present:  Body.hasRoute,
+		appendTo: Body.appendRoute,
+		parse: func(b *Body, data []byte) error {
+			b.Source = net.IP(data[:net.IPv4len:net.IPv4len])
+			b.Destination = net.IP(data[net.IPv4len:routeLen:routeLen])
+			return nil
+		},
+		check: checkRoute,
+	},
+	{
+		flag:     FlagTimestamp,
+		len:      timestampLen,
+		spans:    []string{"sent"},
+		err:      ErrorInvalidTimestamp,
+		present:  Body.hasTimestamp,
+		appendTo: Body.appendTimestamp,
+		parse: func(b *Body, data []byte) error {
+			b.SentAt = parseTimestamp(data)
+			return nil
+		},
+		check: checkTimestamp,
+	},
+	{
+		flag:     FlagMessageID,
+		len:      messageIDLen,
+		spans:    []string{"id"},
+		err:      ErrorInvalidMessageID,
+		present:  Body.hasMessageID,
+		appendTo: Body.appendMessageID,
+		parse: func(b *Body, data []byte) error {
+			b.MessageID = binary.BigEndian.Uint32(data)
+			if b.MessageID == 0 {
+				// zero means untagged, so it is never sent
+				return fmt.Errorf("%w: zero id", ErrorInvalidMessageID)
+			}
+			return nil
+		},
+		check: checkMessageID,
+	},
+}
+
+// fieldFlags returns the flags of the optional fields b carries.
+func (b Body) fieldFlags() uint8 {
+	var flags uint8
+	for i := range bodyFields {
+		if bodyFields[i].present(b) {
+			flags |= bodyFields[i].flag
+		}
+	}
+	return flags
+}
+
+// fieldsLen returns the wire size of the optional fields flagged in flags.
+func fieldsLen(flags uint8) int {
+	n := 0
+	for i := range bodyFields {
+		if flags&bodyFields[i].flag != 0 {
+			n += bodyFields[i].len
+		}
+	}
+	return n
+}
+
+// appendFields appends the wire form of the optional fields of b flagged in
+// flags.
+func (b Body) appendFields(dst []byte, flags uint8) []byte {
+	for i := range bodyFields {
+		if flags&bodyFields[i].flag != 0 {
+			dst = bodyFields[i].appendTo(b, dst)
+		}
+	}
+	return dst
+}
+
+// parseFields fills in the optional fields flagged in flags from data,
+// fieldsLen(flags) bytes in wire form. The fields may alias data.
+func (b *Body) parseFields(data []byte, flags uint8) error {
+	off := 0
+	for i := range bodyFields {
+		f := &bodyFields[i]
+		if flags&f.flag == 0 {
+			continue
+		}
+		if err := f.parse(b, data[off:off+f.len]); err != nil {
+			return err
+		}
+		off += f.len
+	}
+	return nil
+}
+
+// readFields reads the optional fields flagged in the header of pack from r
+// into its body, remain being the bytes left in the body. It returns the
+// bytes read.
+func readFields(r io.Reader, pack *Packet, remain int) (int, error) {
+	t, flags := pack.Data.Type, pack.Head.Flags
+	n := fieldsLen(flags)
+	if n == 0 {
+		return 0, nil
+	}
+	if remain < n {
+		return 0, &DecodeError{Type: t, Err: ErrorInvalidReadSize}
+	}
+	data := make([]byte, n)
+	if got, err := io.ReadFull(r, data); err != nil {
+		// blame the field the input ended in
+		off := 0
+		for i := range bodyFields {
+			f := &bodyFields[i]
+			if flags&f.flag == 0 {
+				continue
+			}
+			if got < off+f.len {
+				return 0, shortReadError(t, f.err, f.len, got-off, err)
+			}
+			off += f.len
+		}
+	}
+	if err := pack.Data.parseFields(data, flags); err != nil {
+		return 0, &DecodeError{Type: t, Err: err}
+	}
+	return n, nil
+}
+
+// checkFields verifies that the optional fields flagged in head are allowed
+// on packets of type t.
+func checkFields(head Header, t uint8) error {
+	for i := range bodyFields {
+		if err := bodyFields[i].check(head, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFieldFlags verifies that b carries exactly the optional fields
+// flagged in head.
+func checkFieldFlags(head Header, b Body) error {
+	carried := b.fieldFlags()
+	for i := range bodyFields {
+		f := &bodyFields[i]
+		if carried&f.flag != head.Flags&f.flag {
+			return fmt.Errorf("%w: field and flags %#x disagree", f.err, head.Flags)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Fingerprint returns a SHA-256 identifier of the content of p, so the same
+// packet seen over several paths of the mesh can be recognized. It covers
+// the type and the plain message bytes. The header is left out, as are the
+// vector, which is random per packet, and the priority, checksum and
+// compression flags, which only change how the content travels.
+func Fingerprint(p *Packet) [32]byte {
+	var content bytes.Buffer
+	content.WriteByte(p.Data.Type)
+	if p.Data.Msg != nil {
+		p.Data.Msg.WriteTo(&content)
+	}
+	return sha256.Sum256(content.Bytes())
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	a := NewTransferMessage([]byte("tunnelled ip packet"))
+	b := NewTransferMessage([]byte("tunnelled ip packet"))
+	b.SetChecksum(ChecksumCRC32C)
+	if a.Data.Vector[0] == b.Data.Vector[0] {
+		b.Data.Vector[0]++
+	}
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Fatal("identical payloads with different vectors and checksums differ")
+	}
+
+	// a decoded copy of the packet is the same packet
+	decoded, err := encodeDecode(t, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(decoded) != Fingerprint(a) {
+		t.Fatal("decoded packet differs from the original")
+	}
+
+	for _, other := range []*Packet{
+		NewTransferMessage([]byte("tunnelled ip packeT")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+	} {
+		if Fingerprint(other) == Fingerprint(a) {
+			t.Fatalf("%s packet shares the fingerprint", typeName(other.Data.Type))
+		}
+	}
+	prioritized := NewTransferMessage([]byte("tunnelled ip packet"))
+	prioritized.SetPriority(5)
+	if Fingerprint(prioritized) != Fingerprint(a) {
+		t.Fatal("priority changes the fingerprint")
+	}
+}
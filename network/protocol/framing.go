@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrorFramePrefix = errors.New("frame prefix must be 0, 1, 2 or 4 bytes")
+)
+
+// checkFramePrefix validates a frame prefix width, zero meaning none.
+func checkFramePrefix(width int) error {
+	switch width {
+	case 0, 1, 2, 4:
+		return nil
+	}
+	return fmt.Errorf("%w, got %d", ErrorFramePrefix, width)
+}
+
+// appendFrame appends data to b prefixed with its length as a big endian
+// integer of width bytes. A frame too long for the width fails with
+// ErrorFrameTooLarge.
+func appendFrame(b []byte, width int, data []byte) ([]byte, error) {
+	if uint64(len(data)) >= 1<<(8*width) {
+		return nil, fmt.Errorf("%w: %d bytes for a %d byte prefix", ErrorFrameTooLarge, len(data), width)
+	}
+	switch width {
+	case 1:
+		b = append(b, byte(len(data)))
+	case 2:
+		b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	case 4:
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	}
+	return append(b, data...), nil
+}
+
+// readFrame reads one frame prefixed with its length as a big endian integer
+// of width bytes. It returns io.EOF only when r ends before the prefix.
+func readFrame(r io.Reader, width int) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[4-width:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length > maxDelimitedLen {
+		return nil, ErrorFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
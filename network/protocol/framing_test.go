@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFramePrefix(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	for _, width := range []int{1, 2, 4} {
+		packets := []*Packet{
+			NewOkMessage(),
+			NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+			NewTransferMessage(bytes.Repeat([]byte{0xab}, 200)),
+		}
+		if width > 1 {
+			packets = append(packets, NewTransferMessage(bytes.Repeat([]byte{0xcd}, 60000)))
+		}
+
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream, iSend)
+		if err := enc.SetFramePrefix(width); err != nil {
+			t.Fatal(err)
+		}
+		for _, pack := range packets {
+			if err := enc.Encode(pack); err != nil {
+				t.Fatalf("width %d: %v", width, err)
+			}
+		}
+
+		dec := NewDecoder(&stream, rRecv)
+		if err := dec.SetFramePrefix(width); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range packets {
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("width %d, packet %d: %v", width, i, err)
+			}
+			if got.Data.Type != want.Data.Type || got.Data.Msg.Len() != want.Data.Msg.Len() {
+				t.Fatalf("width %d, packet %d: expected %#v, got %#v", width, i, want, got)
+			}
+		}
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Fatalf("width %d: expected EOF, got %v", width, err)
+		}
+	}
+}
+
+func TestFramePrefixTooLarge(t *testing.T) {
+	enc := NewEncoder(io.Discard, nil)
+	if err := enc.SetFramePrefix(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(NewTransferMessage(make([]byte, 255))); !errors.Is(err, ErrorFrameTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrorFrameTooLarge, err)
+	}
+	if err := enc.Encode(NewTransferMessage(make([]byte, 200))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.SetFramePrefix(3); !errors.Is(err, ErrorFramePrefix) {
+		t.Fatalf("expected %v, got %v", ErrorFramePrefix, err)
+	}
+}
+
+func TestFramePrefixMismatch(t *testing.T) {
+	data, err := Encode(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the frame holds a byte more than the packet
+	frame, err := appendFrame(nil, 2, append(data, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(bytes.NewReader(frame), nil)
+	dec.SetFramePrefix(2)
+	if _, err := dec.Decode(); !errors.Is(err, ErrorFrameLenMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorFrameLenMismatch, err)
+	}
+}
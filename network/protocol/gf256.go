@@ -0,0 +1,97 @@
+package protocol
+
+import "errors"
+
+// Arithmetic in GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1, the field of
+// the Reed-Solomon code used for forward error correction.
+
+var (
+	gfExp, gfLog = gfTables()
+
+	errorSingularMatrix = errors.New("singular matrix")
+)
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+	return
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a, which must not be zero.
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfMulAdd adds c times src to dst.
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	logC := int(gfLog[c])
+	for i, b := range src {
+		if b != 0 {
+			dst[i] ^= gfExp[logC+int(gfLog[b])]
+		}
+	}
+}
+
+// cauchy returns the coefficient of data shard j in parity shard i of a code
+// with k data shards. Every square submatrix of the identity stacked on the
+// Cauchy matrix is invertible, so any k shards recover the data.
+func cauchy(i, j, k int) byte {
+	return gfInv(byte(k+i) ^ byte(j))
+}
+
+// gfInvert inverts the square matrix m in place using Gauss-Jordan
+// elimination.
+func gfInvert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errorSingularMatrix
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		if c := gfInv(m[col][col]); c != 1 {
+			for j := 0; j < n; j++ {
+				m[col][j] = gfMul(m[col][j], c)
+				inv[col][j] = gfMul(inv[col][j], c)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if c := m[row][col]; row != col && c != 0 {
+				gfMulAdd(m[row], m[col], c)
+				gfMulAdd(inv[row], inv[col], c)
+			}
+		}
+	}
+	return inv, nil
+}
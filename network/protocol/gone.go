@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	GoneReasonShutdown uint8 = iota
+	// GoneReasonReplaced is followed by the endpoints of the nodes taking
+	// over, in the peer table format of PeerInfoMessage.
+	GoneReasonReplaced
+)
+
+type (
+	// GoneMessage announces that the sender is leaving the mesh.
+	GoneMessage []byte
+)
+
+// NewGoneMessage builds a Gone packet. Successors are only sent with
+// GoneReasonReplaced and ignored otherwise.
+func NewGoneMessage(reason uint8, successors []PeerEntry) *Packet {
+	msg := GoneMessage{reason}
+	if reason == GoneReasonReplaced {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(successors)))
+		for _, entry := range successors {
+			msg = entry.appendTo(msg)
+		}
+	}
+
+	body := Body{
+		Type: TypeGone,
+		Msg:  msg,
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+func (m GoneMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m GoneMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m GoneMessage) Reason() uint8 {
+	return m[0]
+}
+
+// Successors returns the endpoints peers should reconnect to, only present
+// when the reason is GoneReasonReplaced.
+func (m GoneMessage) Successors() ([]PeerEntry, error) {
+	if m.Reason() != GoneReasonReplaced {
+		return nil, nil
+	}
+	return parsePeerEntries(m[1:])
+}
+
+func decodeGone(data []byte) (Message, error) {
+	return GoneMessage(data), nil
+}
+
+func validateGone(msg Message) error {
+	m := msg.(GoneMessage)
+	if len(m) == 0 {
+		return fmt.Errorf("gone message without reason")
+	}
+	switch m.Reason() {
+	case GoneReasonShutdown:
+		if len(m) != 1 {
+			return fmt.Errorf("unexpected %d bytes after gone reason", len(m)-1)
+		}
+	case GoneReasonReplaced:
+		if _, err := m.Successors(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown gone reason %d", m.Reason())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestGoneWithRedirects(t *testing.T) {
+	successors := []PeerEntry{
+		{PrivateIP: net.IPv4(10, 0, 0, 2), PublicIP: net.IPv4(203, 0, 113, 2), Port: 7002},
+		{PrivateIP: net.IPv4(10, 0, 0, 3), PublicIP: net.IPv4(203, 0, 113, 3), Port: 7003},
+	}
+	pack, err := encodeDecode(t, NewGoneMessage(GoneReasonReplaced, successors))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := pack.Data.Msg.(GoneMessage)
+	if msg.Reason() != GoneReasonReplaced {
+		t.Fatalf("unexpected reason %d", msg.Reason())
+	}
+	got, err := msg.Successors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 successors, got %d", len(got))
+	}
+	for i, entry := range got {
+		want := successors[i]
+		if !entry.PrivateIP.Equal(want.PrivateIP) || !entry.PublicIP.Equal(want.PublicIP) || entry.Port != want.Port {
+			t.Errorf("successor %d: expected %+v, got %+v", i, want, entry)
+		}
+	}
+}
+
+func TestGonePlain(t *testing.T) {
+	pack, err := encodeDecode(t, NewGoneMessage(GoneReasonShutdown, []PeerEntry{{Port: 1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := pack.Data.Msg.(GoneMessage)
+	if msg.Len() != 1 || msg.Reason() != GoneReasonShutdown {
+		t.Fatalf("unexpected gone message %v", msg)
+	}
+	if successors, err := msg.Successors(); err != nil || successors != nil {
+		t.Fatalf("expected no successors, got %v %v", successors, err)
+	}
+}
+
+func TestGoneRejectsTruncatedRedirects(t *testing.T) {
+	msg := GoneMessage{GoneReasonReplaced, 0, 2, 10, 0, 0, 2}
+	if _, err := encodeDecode(t, newPacket(TypeGone, msg)); !errors.Is(err, ErrorInvalidPayload) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPayload, err)
+	}
+}
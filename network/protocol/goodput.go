@@ -0,0 +1,82 @@
+package protocol
+
+import "sync/atomic"
+
+type (
+	// GoodputMeter compares the tunnelled payload to the bytes on the wire,
+	// per direction. Every packet counts towards the wire bytes, only the
+	// messages of Transfer packets towards the payload, so control traffic,
+	// headers, vectors and tags lower the ratio while compression raises it.
+	// It is a Metrics implementation, install it with SetMetrics or as a
+	// Decoder's Metrics.
+	GoodputMeter struct {
+		wireSent        atomic.Uint64
+		wireReceived    atomic.Uint64
+		payloadSent     atomic.Uint64
+		payloadReceived atomic.Uint64
+	}
+
+	// GoodputSnapshot holds the byte counts of a GoodputMeter.
+	GoodputSnapshot struct {
+		WireSent        uint64
+		WireReceived    uint64
+		PayloadSent     uint64
+		PayloadReceived uint64
+	}
+)
+
+func NewGoodputMeter() *GoodputMeter {
+	return new(GoodputMeter)
+}
+
+func (g *GoodputMeter) PacketEncoded(t uint8, size int) {
+	g.wireSent.Add(uint64(size))
+}
+
+func (g *GoodputMeter) PacketDecoded(t uint8, size int) {
+	g.wireReceived.Add(uint64(size))
+}
+
+func (g *GoodputMeter) DecodeFailed(err error) {}
+
+func (g *GoodputMeter) PayloadEncoded(t uint8, size int) {
+	if t == TypeTransfer {
+		g.payloadSent.Add(uint64(size))
+	}
+}
+
+func (g *GoodputMeter) PayloadDecoded(t uint8, size int) {
+	if t == TypeTransfer {
+		g.payloadReceived.Add(uint64(size))
+	}
+}
+
+// Snapshot copies the current counts.
+func (g *GoodputMeter) Snapshot() GoodputSnapshot {
+	return GoodputSnapshot{
+		WireSent:        g.wireSent.Load(),
+		WireReceived:    g.wireReceived.Load(),
+		PayloadSent:     g.payloadSent.Load(),
+		PayloadReceived: g.payloadReceived.Load(),
+	}
+}
+
+// SentRatio returns the payload bytes sent per wire byte, zero before any
+// packet was sent. It exceeds 1 when compression saves more than the
+// overhead costs.
+func (s GoodputSnapshot) SentRatio() float64 {
+	return GoodputRatio(s.PayloadSent, s.WireSent)
+}
+
+// ReceivedRatio is SentRatio for the received direction.
+func (s GoodputSnapshot) ReceivedRatio() float64 {
+	return GoodputRatio(s.PayloadReceived, s.WireReceived)
+}
+
+// GoodputRatio returns payload per wire byte, zero when wire is.
+func GoodputRatio(payload, wire uint64) float64 {
+	if wire == 0 {
+		return 0
+	}
+	return float64(payload) / float64(wire)
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestGoodputMeter(t *testing.T) {
+	for name, tc := range map[string]struct {
+		payload  []byte
+		compress bool
+	}{
+		"compressible":   {bytes.Repeat([]byte("tunnelled ip packet "), 60), true},
+		"incompressible": {randomBytes(1200), false},
+	} {
+		g := NewGoodputMeter()
+		SetMetrics(g)
+
+		var stream bytes.Buffer
+		enc := NewEncoder(&stream, nil)
+		if tc.compress {
+			if err := enc.SetCompressionLevel(MaxCompressionLevel); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			if err := enc.Encode(NewTransferMessage(tc.payload)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))); err != nil {
+			t.Fatal(err)
+		}
+		wire := uint64(stream.Len())
+
+		dec := NewDecoder(&stream, nil)
+		for i := 0; i < 11; i++ {
+			if _, err := dec.Decode(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		SetMetrics(nil)
+
+		s := g.Snapshot()
+		payload := uint64(10 * len(tc.payload))
+		if s.WireSent != wire || s.WireReceived != wire || s.PayloadSent != payload || s.PayloadReceived != payload {
+			t.Fatalf("%s: expected %d payload bytes in %d wire bytes, got %+v", name, payload, wire, s)
+		}
+		want := float64(payload) / float64(wire)
+		if s.SentRatio() != want || s.ReceivedRatio() != want {
+			t.Fatalf("%s: expected ratio %.3f, got %.3f sent and %.3f received", name, want, s.SentRatio(), s.ReceivedRatio())
+		}
+		if tc.compress != (want > 1) {
+			t.Fatalf("%s: unexpected ratio %.3f", name, want)
+		}
+	}
+
+	if r := GoodputRatio(10, 0); r != 0 {
+		t.Fatalf("expected a zero ratio without traffic, got %v", r)
+	}
+}
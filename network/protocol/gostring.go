@@ -0,0 +1,95 @@
+package protocol
+
+import "fmt"
+
+// The GoString methods keep %#v from dumping vectors and message bytes, which
+// may hold keys and tunnelled payloads, into logs: only their sizes show.
+
+func (p *Packet) GoString() string {
+	if p == nil {
+		return "(*protocol.Packet)(nil)"
+	}
+	return fmt.Sprintf("&protocol.Packet{Head:%#v, Data:%#v}", p.Head, p.Data)
+}
+
+func (b Body) GoString() string {
+	msg := "nil"
+	switch m := b.Msg.(type) {
+	case nil:
+	case fmt.GoStringer:
+		msg = m.GoString()
+	default:
+		msg = redacted(fmt.Sprintf("%T", m), int(m.Len()))
+	}
+	// the send time in unix nanoseconds, as on the wire
+	var sent int64
+	if b.hasTimestamp() {
+		sent = b.SentAt.UnixNano()
+	}
+	return fmt.Sprintf("protocol.Body{Type:%s, Priority:%d, Source:%v, Destination:%v, SentAt:%d, MessageID:%d, Vector:<%d bytes>, Msg:%s, Raw:<%d bytes>}",
+		typeName(b.Type), b.Priority, b.Source, b.Destination, sent, b.MessageID, len(b.Vector), msg, len(b.Raw))
+}
+
+func redacted(name string, n int) string {
+	return fmt.Sprintf("%s{<%d bytes>}", name, n)
+}
+
+func (m encodedMessage) GoString() string {
+	return redacted("protocol.encodedMessage", len(m))
+}
+
+func (m RawMessage) GoString() string {
+	return redacted("protocol.RawMessage", len(m))
+}
+
+func (m HandshakeMessage) GoString() string {
+	return redacted("protocol.HandshakeMessage", len(m))
+}
+
+func (m OkMessage) GoString() string {
+	return redacted("protocol.OkMessage", len(m))
+}
+
+func (m HeartbeatMessage) GoString() string {
+	return redacted("protocol.HeartbeatMessage", len(m))
+}
+
+func (m TransferMessage) GoString() string {
+	return redacted("protocol.TransferMessage", len(m))
+}
+
+func (m PeerInfoMessage) GoString() string {
+	return redacted("protocol.PeerInfoMessage", len(m))
+}
+
+func (m ErrorMessage) GoString() string {
+	return redacted("protocol.ErrorMessage", len(m))
+}
+
+func (m TransferBatchMessage) GoString() string {
+	return redacted("protocol.TransferBatchMessage", len(m))
+}
+
+func (m GoneMessage) GoString() string {
+	return redacted("protocol.GoneMessage", len(m))
+}
+
+func (m ResetMessage) GoString() string {
+	return redacted("protocol.ResetMessage", len(m))
+}
+
+func (m QueryMessage) GoString() string {
+	return redacted("protocol.QueryMessage", len(m))
+}
+
+func (m ResponseMessage) GoString() string {
+	return redacted("protocol.ResponseMessage", len(m))
+}
+
+func (m FECMessage) GoString() string {
+	return redacted("protocol.FECMessage", len(m))
+}
+
+func (m *StreamMessage) GoString() string {
+	return redacted("protocol.StreamMessage", m.n)
+}
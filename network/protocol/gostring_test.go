@@ -0,0 +1,24 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPacketGoStringRedacts(t *testing.T) {
+	payload := []byte("top secret tunnelled payload")
+	pack := NewTransferMessage(payload)
+	pack.Data.Vector = bytes.Repeat([]byte{0xab}, bodyVectorLen)
+
+	for _, out := range []string{fmt.Sprintf("%#v", pack), fmt.Sprintf("%#v", pack.Data)} {
+		if strings.Contains(out, string(payload)) || strings.Contains(out, fmt.Sprint(pack.Data.Vector)) ||
+			strings.Contains(out, "171") || strings.Contains(out, "0xab") {
+			t.Fatalf("raw bytes in %s", out)
+		}
+		if !strings.Contains(out, fmt.Sprintf("protocol.TransferMessage{<%d bytes>}", len(payload))) {
+			t.Fatalf("message size missing from %s", out)
+		}
+	}
+}
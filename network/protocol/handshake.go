@@ -2,17 +2,49 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/meshbird/meshbird/secure"
 	"io"
 )
 
+// Optional handshake fields follow the session key, each encoded as a one
+// byte tag, a uint16 value length and the value.
+const (
+	HandshakeResumeToken uint8 = iota + 1
+	HandshakeCapabilities
+	HandshakeNodeID
+	HandshakeTransports
+	HandshakeCompressionLevel
+	HandshakeMaxStreams
+	HandshakeNonce
+	HandshakeKeepalive
+	HandshakeCipherSuite
+
+	// lastHandshakeField is the highest tag this version understands.
+	lastHandshakeField = HandshakeCipherSuite
+)
+
+const (
+	sessionKeyLen     = 16
+	handshakeFieldLen = 3
+)
+
 var (
 	magicKey = []byte{'M', 'E', 'S', 'H', 'B', 'I', 'R', 'D'}
+
+	ErrorMalformedHandshakeField = errors.New("malformed handshake field")
+	ErrorSelfConnection          = errors.New("connected to self")
 )
 
 type (
 	HandshakeMessage []byte
+
+	HandshakeField struct {
+		Tag   uint8
+		Value []byte
+	}
 )
 
 func IsMagicValid(data []byte) bool {
@@ -20,8 +52,13 @@ func IsMagicValid(data []byte) bool {
 	return bytes.HasPrefix(data, magicKey)
 }
 
-func NewHandshakePacket(sessionKey []byte, networkSecret *secure.NetworkSecret) *Packet {
+func NewHandshakePacket(sessionKey []byte, networkSecret *secure.NetworkSecret, fields ...HandshakeField) *Packet {
 	sessionKey = append(magicKey, sessionKey...)
+	for _, field := range fields {
+		sessionKey = append(sessionKey, field.Tag)
+		sessionKey = binary.BigEndian.AppendUint16(sessionKey, uint16(len(field.Value)))
+		sessionKey = append(sessionKey, field.Value...)
+	}
 	data := networkSecret.Encode(sessionKey)
 
 	body := Body{
@@ -51,7 +88,126 @@ func (m HandshakeMessage) Bytes() []byte {
 }
 
 func (m HandshakeMessage) SessionKey() []byte {
-	return m[len(magicKey):]
+	return m[len(magicKey) : len(magicKey)+sessionKeyLen]
+}
+
+// Fields returns the optional fields following the session key. They form
+// a TLV block: every field is length-delimited, so a field with a tag added
+// by a later version is returned like any other and can be skipped without
+// knowing its layout. A field whose length runs past the message fails with
+// ErrorMalformedHandshakeField.
+func (m HandshakeMessage) Fields() ([]HandshakeField, error) {
+	var fields []HandshakeField
+
+	data := m[len(magicKey)+sessionKeyLen:]
+	for len(data) > 0 {
+		if len(data) < handshakeFieldLen {
+			return nil, ErrorMalformedHandshakeField
+		}
+		tag, valueLen := data[0], int(binary.BigEndian.Uint16(data[1:]))
+		data = data[handshakeFieldLen:]
+		if len(data) < valueLen {
+			return nil, ErrorMalformedHandshakeField
+		}
+		fields = append(fields, HandshakeField{Tag: tag, Value: data[:valueLen:valueLen]})
+		data = data[valueLen:]
+	}
+	return fields, nil
+}
+
+// KnownFields returns the fields whose tags this version understands, in
+// the order they were sent, skipping those added by later versions.
+func (m HandshakeMessage) KnownFields() ([]HandshakeField, error) {
+	fields, err := m.Fields()
+	if err != nil {
+		return nil, err
+	}
+	known := fields[:0]
+	for _, field := range fields {
+		if field.Tag >= HandshakeResumeToken && field.Tag <= lastHandshakeField {
+			known = append(known, field)
+		}
+	}
+	return known, nil
+}
+
+// Field returns the value of the first field tagged tag.
+func (m HandshakeMessage) Field(tag uint8) ([]byte, bool) {
+	fields, err := m.Fields()
+	if err != nil {
+		return nil, false
+	}
+	for _, field := range fields {
+		if field.Tag == tag {
+			return field.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (m HandshakeMessage) ResumeToken() []byte {
+	token, _ := m.Field(HandshakeResumeToken)
+	return token
+}
+
+// Capabilities returns the capability bits advertised by the peer.
+func (m HandshakeMessage) Capabilities() uint8 {
+	if caps, ok := m.Field(HandshakeCapabilities); ok && len(caps) == 1 {
+		return caps[0]
+	}
+	return 0
+}
+
+// NodeID returns the identity the peer announced, nil if it sent none.
+func (m HandshakeMessage) NodeID() []byte {
+	id, _ := m.Field(HandshakeNodeID)
+	return id
+}
+
+// CheckSelfConnection returns ErrorSelfConnection when the handshake was
+// sent by the local node itself, i.e. it dialed one of its own addresses.
+func CheckSelfConnection(m HandshakeMessage, localID []byte) error {
+	if id := m.NodeID(); id != nil && bytes.Equal(id, localID) {
+		return ErrorSelfConnection
+	}
+	return nil
+}
+
+func decodeHandshake(data []byte) (Message, error) {
+	return HandshakeMessage(data), nil
+}
+
+func validateHandshake(msg Message) error {
+	m := msg.(HandshakeMessage)
+	if vectorPrefixed(m, magicKey) {
+		return fmt.Errorf("%w before handshake", ErrorUnexpectedVector)
+	}
+	if len(m) < len(magicKey)+sessionKeyLen {
+		return fmt.Errorf("handshake too short, %d bytes", len(m))
+	}
+	if _, err := m.Fields(); err != nil {
+		return err
+	}
+	if _, err := m.Transports(); err != nil {
+		return err
+	}
+	if nonce, ok := m.Field(HandshakeNonce); ok && len(nonce) != connectionNonceLen {
+		return fmt.Errorf("%w: %d byte nonce", ErrorMalformedHandshakeField, len(nonce))
+	}
+	if interval, ok := m.Field(HandshakeKeepalive); ok && len(interval) != keepaliveFieldLen {
+		return fmt.Errorf("%w: %d byte keepalive interval", ErrorMalformedHandshakeField, len(interval))
+	}
+	if suite, ok := m.Field(HandshakeCipherSuite); ok && len(suite) != 1 {
+		return fmt.Errorf("%w: %d byte cipher suite", ErrorMalformedHandshakeField, len(suite))
+	}
+	return nil
+}
+
+// vectorPrefixed reports whether msg, expected to start with prefix, has it
+// only after a vector sized run of bytes: a sender wrote a vector on a type
+// that carries none, which would otherwise be read as message bytes.
+func vectorPrefixed(msg, prefix []byte) bool {
+	return !bytes.HasPrefix(msg, prefix) && len(msg) >= bodyVectorLen && bytes.HasPrefix(msg[bodyVectorLen:], prefix)
 }
 
 func ReadDecodeHandshake(r io.Reader) (HandshakeMessage, error) {
@@ -71,9 +227,9 @@ func ReadDecodeHandshake(r io.Reader) (HandshakeMessage, error) {
 	return handshakePack.Data.Msg.(HandshakeMessage), nil
 }
 
-func WriteEncodeHandshake(w io.Writer, sessionKey []byte, networkSecret *secure.NetworkSecret) (err error) {
+func WriteEncodeHandshake(w io.Writer, sessionKey []byte, networkSecret *secure.NetworkSecret, fields ...HandshakeField) (err error) {
 	logger.Debug("writing handshare message...")
-	if err = EncodeAndWrite(w, NewHandshakePacket(sessionKey, networkSecret)); err != nil {
+	if err = EncodeAndWrite(w, NewHandshakePacket(sessionKey, networkSecret, fields...)); err != nil {
 		err = fmt.Errorf("error on write handshare message, %v", err)
 	}
 	return
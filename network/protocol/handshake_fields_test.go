@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestHandshakeExtensionFields(t *testing.T) {
+	const futureTag = 200
+	pack := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{},
+		HandshakeField{Tag: futureTag, Value: []byte("from a later version")},
+		MaxStreamsField(4),
+		HandshakeField{Tag: futureTag + 1},
+		HandshakeField{Tag: HandshakeCapabilities, Value: []byte{3}},
+	)
+	got, err := encodeDecode(t, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := got.Data.Msg.(HandshakeMessage)
+
+	fields, err := m.Fields()
+	if err != nil || len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d: %v", len(fields), err)
+	}
+	known, err := m.KnownFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(known) != 2 || known[0].Tag != HandshakeMaxStreams || known[1].Tag != HandshakeCapabilities {
+		t.Fatalf("expected the max streams and capabilities fields, got %v", known)
+	}
+	if m.Capabilities() != 3 || NegotiateMaxStreams(8, m) != 4 {
+		t.Fatalf("known fields not read past unknown ones: capabilities %d", m.Capabilities())
+	}
+	if value, ok := m.Field(futureTag); !ok || string(value) != "from a later version" {
+		t.Fatalf("unknown field not skipped by its length: %q", value)
+	}
+}
+
+func TestHandshakeFieldOverrun(t *testing.T) {
+	msg := append(append([]byte{}, magicKey...), bytes.Repeat([]byte{1}, sessionKeyLen)...)
+	// the field announces 16 bytes but only 2 follow
+	msg = append(msg, HandshakeCapabilities, 0, 16, 1, 2)
+	m := HandshakeMessage(msg)
+
+	if _, err := m.Fields(); err != ErrorMalformedHandshakeField {
+		t.Fatalf("expected %v, got %v", ErrorMalformedHandshakeField, err)
+	}
+	if _, err := m.KnownFields(); err != ErrorMalformedHandshakeField {
+		t.Fatalf("expected %v, got %v", ErrorMalformedHandshakeField, err)
+	}
+	if err := validateHandshake(m); !errors.Is(err, ErrorMalformedHandshakeField) {
+		t.Fatalf("expected %v, got %v", ErrorMalformedHandshakeField, err)
+	}
+}
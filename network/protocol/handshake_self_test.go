@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestHandshakeSelfConnection(t *testing.T) {
+	localID := net.IPv4(10, 0, 0, 1).To4()
+	sessionKey := bytes.Repeat([]byte{1}, sessionKeyLen)
+	dialer, listener := net.Pipe()
+	defer dialer.Close()
+	defer listener.Close()
+
+	// the node dials its own listener
+	go WriteEncodeHandshake(dialer, sessionKey, &secure.NetworkSecret{},
+		HandshakeField{Tag: HandshakeNodeID, Value: localID})
+
+	listenErr := make(chan error, 1)
+	go func() {
+		msg, err := ReadDecodeHandshake(listener)
+		if err == nil {
+			if err = CheckSelfConnection(msg, localID); err != nil {
+				EncodeAndWrite(listener, NewRejectMessage(OkRejectedSelf))
+			}
+		}
+		listenErr <- err
+	}()
+
+	ok, err := ReadDecodeOk(dialer)
+	if !errors.Is(err, ErrorRejected) || ok.Status() != OkRejectedSelf {
+		t.Fatalf("expected %s rejection, got %v", OkRejectedSelf, err)
+	}
+	if err = <-listenErr; err != ErrorSelfConnection {
+		t.Fatalf("expected %v, got %v", ErrorSelfConnection, err)
+	}
+}
+
+func TestHandshakeOtherNode(t *testing.T) {
+	pack, err := encodeDecode(t, NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{},
+		HandshakeField{Tag: HandshakeNodeID, Value: []byte{10, 0, 0, 2}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := pack.Data.Msg.(HandshakeMessage)
+	if err = CheckSelfConnection(msg, []byte{10, 0, 0, 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// peers predating the node id can not be checked and are let through
+	pack, err = encodeDecode(t, NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSelfConnection(pack.Data.Msg.(HandshakeMessage), []byte{10, 0, 0, 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestUnexpectedVector(t *testing.T) {
+	handshake := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{})
+	for _, pack := range []*Packet{handshake, NewOkMessage(), NewRejectMessage(OkRejectedBusy)} {
+		// a sender wrongly writing a vector on a type without one
+		var msg bytes.Buffer
+		msg.Write(bytes.Repeat([]byte{0xaa}, bodyVectorLen))
+		pack.Data.Msg.WriteTo(&msg)
+		pack.Data.Msg = RawMessage(msg.Bytes())
+
+		if _, err := encodeDecode(t, pack); !errors.Is(err, ErrorUnexpectedVector) || !errors.Is(err, ErrorInvalidPayload) {
+			t.Fatalf("%s: expected %v, got %v", typeName(pack.Data.Type), ErrorUnexpectedVector, err)
+		}
+	}
+}
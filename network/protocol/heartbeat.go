@@ -1,8 +1,28 @@
 package protocol
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"time"
+)
+
+// heartbeatTimedLen is the length of a heartbeat carrying the private IP
+// followed by the send time in unix nanoseconds.
+const heartbeatTimedLen = net.IPv4len + 8
+
+// heartbeatEchoLen is the length of a timed heartbeat followed by the send
+// time of the peer heartbeat it answers.
+const heartbeatEchoLen = heartbeatTimedLen + 8
+
+// FlagPeerDelta marks a Heartbeat body whose message is followed by a peer
+// table of changes since the last update, see SetPeerDelta.
+const FlagPeerDelta uint8 = 1 << 4
+
+var (
+	ErrorInvalidPeerDelta = errors.New("peer delta only allowed on heartbeat packets from flags version on")
 )
 
 type (
@@ -23,6 +43,123 @@ func NewHeartbeatMessage(privateIP net.IP) *Packet {
 	}
 }
 
+// NewTimedHeartbeatMessage is like NewHeartbeatMessage but stamps the
+// heartbeat with its send time, letting the receiver drop replays.
+func NewTimedHeartbeatMessage(privateIP net.IP, sent time.Time) *Packet {
+	msg := make(HeartbeatMessage, heartbeatTimedLen)
+	copy(msg, privateIP.To4())
+	binary.BigEndian.PutUint64(msg[net.IPv4len:], uint64(sent.UnixNano()))
+	return newHeartbeatPacket(msg)
+}
+
+// NewEchoHeartbeatMessage is like NewTimedHeartbeatMessage but also echoes
+// echo, the send time of the peer heartbeat it answers, so the peer can
+// match the answer to its probe by its own clock. Only peers knowing the
+// layout accept it.
+func NewEchoHeartbeatMessage(privateIP net.IP, sent, echo time.Time) *Packet {
+	msg := make(HeartbeatMessage, heartbeatEchoLen)
+	copy(msg, privateIP.To4())
+	binary.BigEndian.PutUint64(msg[net.IPv4len:], uint64(sent.UnixNano()))
+	binary.BigEndian.PutUint64(msg[heartbeatTimedLen:], uint64(echo.UnixNano()))
+	return newHeartbeatPacket(msg)
+}
+
+func newHeartbeatPacket(msg HeartbeatMessage) *Packet {
+	body := Body{
+		Type: TypeHeartbeat,
+		Msg:  msg,
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+// SetPeerDelta appends delta to p, a Heartbeat packet, as a peer table
+// announcing the peers that changed since the last update, upgrading its
+// header to FlagsVersion. A nil delta removes a delta set before. Like any
+// peer table, the delta is written in canonical order. It fails with
+// ErrorInvalidPeerDelta when p is not a Heartbeat.
+func (p *Packet) SetPeerDelta(delta []PeerEntry) error {
+	m, ok := p.Data.Msg.(HeartbeatMessage)
+	if !ok {
+		return ErrorInvalidPeerDelta
+	}
+	base := m.baseLen(p.Head.Flags&FlagPeerDelta != 0)
+	msg := make(HeartbeatMessage, 0, base+2+len(delta)*peerEntryLen)
+	msg = append(msg, m[:base]...)
+	if delta == nil {
+		p.Head.Flags &^= FlagPeerDelta
+	} else {
+		if p.Head.Version < FlagsVersion {
+			p.Head.Version = FlagsVersion
+		}
+		p.Head.Flags |= FlagPeerDelta
+		msg = appendPeerTable(msg, delta)
+	}
+	p.Data.Msg = msg
+	p.Head.Length = p.Data.Len() + p.Head.Checksum().Len()
+	return nil
+}
+
+func (m HeartbeatMessage) PrivateIP() net.IP {
+	return net.IP(m[:net.IPv4len])
+}
+
+// Timestamp returns the send time of a timed heartbeat, false for a plain
+// one.
+func (m HeartbeatMessage) Timestamp() (time.Time, bool) {
+	if m.baseLen(m.HasPeerDelta()) < heartbeatTimedLen {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(m[net.IPv4len:]))), true
+}
+
+// Echo returns the send time of the heartbeat this one answers, false when
+// it answers none, see NewEchoHeartbeatMessage.
+func (m HeartbeatMessage) Echo() (time.Time, bool) {
+	if m.baseLen(m.HasPeerDelta()) != heartbeatEchoLen {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(m[heartbeatTimedLen:]))), true
+}
+
+// HasPeerDelta reports whether the heartbeat carries a peer delta. Without
+// one it is exactly an address, optionally followed by a send time and an
+// echoed send time, long; a decoded heartbeat agrees with the FlagPeerDelta
+// of its header.
+func (m HeartbeatMessage) HasPeerDelta() bool {
+	return len(m) > net.IPv4len && len(m) != heartbeatTimedLen && len(m) != heartbeatEchoLen
+}
+
+// PeerDelta returns the peers announced with the heartbeat, empty when it
+// carries no delta.
+func (m HeartbeatMessage) PeerDelta() ([]PeerEntry, error) {
+	if !m.HasPeerDelta() {
+		return nil, nil
+	}
+	return parsePeerEntries(m[m.baseLen(true):])
+}
+
+// baseLen returns the length of the heartbeat without its peer delta, delta
+// telling whether it carries one, see FlagPeerDelta. The table length is a
+// multiple of the entry size plus its count, which tells the plain, the
+// timed and the echo layout apart.
+func (m HeartbeatMessage) baseLen(delta bool) int {
+	if !delta {
+		return len(m)
+	}
+	for _, base := range []int{heartbeatEchoLen, heartbeatTimedLen} {
+		if len(m) >= base+2 && (len(m)-base-2)%peerEntryLen == 0 {
+			return base
+		}
+	}
+	return net.IPv4len
+}
+
 func (m HeartbeatMessage) Len() uint16 {
 	return uint16(len(m))
 }
@@ -31,3 +168,38 @@ func (m HeartbeatMessage) WriteTo(w io.Writer) (int64, error) {
 	n, err := w.Write(m)
 	return int64(n), err
 }
+
+func decodeHeartbeat(data []byte) (Message, error) {
+	return HeartbeatMessage(data), nil
+}
+
+func validateHeartbeat(msg Message) error {
+	m := msg.(HeartbeatMessage)
+	if !m.HasPeerDelta() {
+		if len(m) != net.IPv4len && len(m) != heartbeatTimedLen && len(m) != heartbeatEchoLen {
+			return fmt.Errorf("heartbeat must carry an IPv4 address, optional timestamps and optional peer delta, got %d bytes", len(m))
+		}
+		return nil
+	}
+	_, err := checkPeerTable(m[m.baseLen(true):])
+	return err
+}
+
+// checkPeerDelta verifies that a peer delta is only carried where the format
+// allows it.
+func checkPeerDelta(head Header, t uint8) error {
+	if head.Flags&FlagPeerDelta != 0 && (head.Version < FlagsVersion || t != TypeHeartbeat) {
+		return ErrorInvalidPeerDelta
+	}
+	return nil
+}
+
+// checkHeartbeatDelta verifies that a decoded heartbeat carries a peer delta
+// exactly when its header is flagged so.
+func checkHeartbeatDelta(head Header, msg Message) error {
+	m, ok := msg.(HeartbeatMessage)
+	if ok && m.HasPeerDelta() != (head.Flags&FlagPeerDelta != 0) {
+		return ErrorInvalidPeerDelta
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPeerDelta(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 1)
+	sent := time.Unix(0, 1700000000123456789)
+	echo := sent.Add(-time.Millisecond)
+	delta := []PeerEntry{
+		{PrivateIP: net.IPv4(10, 0, 0, 2).To4(), PublicIP: net.IPv4(192, 0, 2, 2).To4(), Port: 7001},
+		{PrivateIP: net.IPv4(10, 0, 0, 3).To4(), PublicIP: net.IPv4(192, 0, 2, 3).To4(), Port: 7002},
+	}
+
+	for name, pack := range map[string]*Packet{
+		"plain": NewHeartbeatMessage(ip),
+		"timed": NewTimedHeartbeatMessage(ip, sent),
+		"echo":  NewEchoHeartbeatMessage(ip, sent, echo),
+	} {
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if msg := decoded.Data.Msg.(HeartbeatMessage); msg.HasPeerDelta() || decoded.Head.Flags&FlagPeerDelta != 0 {
+			t.Fatalf("%s: unexpected peer delta on %#v", name, decoded)
+		}
+
+		if err := pack.SetPeerDelta(delta); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err = encodeDecode(t, pack)
+		if err != nil {
+			t.Fatalf("%s with delta: %v", name, err)
+		}
+		msg := decoded.Data.Msg.(HeartbeatMessage)
+		if !msg.PrivateIP().Equal(ip) {
+			t.Fatalf("%s with delta: private ip %v", name, msg.PrivateIP())
+		}
+		if ts, ok := msg.Timestamp(); ok != (name != "plain") || ok && !ts.Equal(sent) {
+			t.Fatalf("%s with delta: timestamp %v, %v", name, ts, ok)
+		}
+		if ts, ok := msg.Echo(); ok != (name == "echo") || ok && !ts.Equal(echo) {
+			t.Fatalf("%s with delta: echo %v, %v", name, ts, ok)
+		}
+		entries, err := msg.PeerDelta()
+		if err != nil {
+			t.Fatalf("%s with delta: %v", name, err)
+		}
+		if len(entries) != len(delta) {
+			t.Fatalf("%s with delta: expected %d entries, got %d", name, len(delta), len(entries))
+		}
+		for i, entry := range entries {
+			if !entry.PrivateIP.Equal(delta[i].PrivateIP) || !entry.PublicIP.Equal(delta[i].PublicIP) || entry.Port != delta[i].Port {
+				t.Fatalf("%s with delta: entry %d is %+v", name, i, entry)
+			}
+		}
+
+		if err := pack.SetPeerDelta(nil); err != nil {
+			t.Fatal(err)
+		}
+		if pack.Data.Msg.(HeartbeatMessage).HasPeerDelta() || pack.Head.Flags&FlagPeerDelta != 0 {
+			t.Fatalf("%s: peer delta not removed", name)
+		}
+	}
+}
+
+func TestHeartbeatPeerDeltaFlag(t *testing.T) {
+	if err := NewOkMessage().SetPeerDelta(nil); !errors.Is(err, ErrorInvalidPeerDelta) {
+		t.Fatalf("expected %v on a non-heartbeat, got %v", ErrorInvalidPeerDelta, err)
+	}
+
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	if err := pack.SetPeerDelta([]PeerEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the flag and the message must agree
+	pack.Head.Flags &^= FlagPeerDelta
+	if _, err := Encode(pack); !errors.Is(err, ErrorInvalidPeerDelta) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPeerDelta, err)
+	}
+
+	transfer := NewTransferMessage([]byte{1})
+	transfer.Head.Version = FlagsVersion
+	transfer.Head.Flags |= FlagPeerDelta
+	if _, err := Encode(transfer); !errors.Is(err, ErrorInvalidPeerDelta) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPeerDelta, err)
+	}
+}
@@ -0,0 +1,73 @@
+package protocol
+
+import "sync/atomic"
+
+// SizeBuckets are the inclusive upper bounds of the SizeHistogram buckets;
+// larger packets fall into a final overflow bucket.
+var SizeBuckets = [...]int{64, 128, 256, 512, 1024, 1500, 4096, 16384}
+
+type (
+	// SizeHistogram counts packet sizes per type and direction. It is a
+	// Metrics implementation, install it with SetMetrics.
+	SizeHistogram struct {
+		sent     [256][len(SizeBuckets) + 1]atomic.Uint64
+		received [256][len(SizeBuckets) + 1]atomic.Uint64
+	}
+
+	// SizeSnapshot holds the bucket counts of every type seen so far; each
+	// slice is indexed like SizeBuckets plus the overflow bucket.
+	SizeSnapshot struct {
+		Sent     map[uint8][]uint64
+		Received map[uint8][]uint64
+	}
+)
+
+func NewSizeHistogram() *SizeHistogram {
+	return new(SizeHistogram)
+}
+
+func (h *SizeHistogram) PacketEncoded(t uint8, size int) {
+	h.sent[t][sizeBucket(size)].Add(1)
+}
+
+func (h *SizeHistogram) PacketDecoded(t uint8, size int) {
+	h.received[t][sizeBucket(size)].Add(1)
+}
+
+func (h *SizeHistogram) DecodeFailed(err error) {}
+
+// Snapshot copies the current counts.
+func (h *SizeHistogram) Snapshot() SizeSnapshot {
+	return SizeSnapshot{
+		Sent:     snapshotBuckets(&h.sent),
+		Received: snapshotBuckets(&h.received),
+	}
+}
+
+func snapshotBuckets(counts *[256][len(SizeBuckets) + 1]atomic.Uint64) map[uint8][]uint64 {
+	snapshot := make(map[uint8][]uint64)
+	for t := range counts {
+		var buckets []uint64
+		for i := range counts[t] {
+			if n := counts[t][i].Load(); n != 0 {
+				if buckets == nil {
+					buckets = make([]uint64, len(SizeBuckets)+1)
+				}
+				buckets[i] = n
+			}
+		}
+		if buckets != nil {
+			snapshot[uint8(t)] = buckets
+		}
+	}
+	return snapshot
+}
+
+func sizeBucket(size int) int {
+	for i, bound := range SizeBuckets {
+		if size <= bound {
+			return i
+		}
+	}
+	return len(SizeBuckets)
+}
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	h := NewSizeHistogram()
+	SetMetrics(h)
+	defer SetMetrics(nil)
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	for _, size := range []int{10, 100, 1400, 1400, 3000} {
+		if err := enc.Encode(NewTransferMessage(make([]byte, size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := EncodeAndWrite(&stream, NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(&stream, nil)
+	for i := 0; i < 6; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// transfer overhead is 20 bytes: 10 -> 30, 100 -> 120, 1400 -> 1420, 3000 -> 3020
+	want := []uint64{1, 1, 0, 0, 0, 2, 1, 0, 0}
+	snapshot := h.Snapshot()
+	for name, counts := range map[string]map[uint8][]uint64{"sent": snapshot.Sent, "received": snapshot.Received} {
+		if len(counts) != 2 {
+			t.Fatalf("%s: expected 2 types, got %v", name, counts)
+		}
+		for i, n := range counts[TypeTransfer] {
+			if n != want[i] {
+				t.Fatalf("%s: expected transfer buckets %v, got %v", name, want, counts[TypeTransfer])
+			}
+		}
+		if counts[TypeHeartbeat][0] != 1 {
+			t.Fatalf("%s: expected one small heartbeat, got %v", name, counts[TypeHeartbeat])
+		}
+	}
+}
+
+func BenchmarkDecodeMetricsDisabled(b *testing.B) {
+	data, _ := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	r := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if _, err := Decode(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
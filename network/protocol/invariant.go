@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorInvariant = errors.New("packet invariant violated")
+)
+
+type (
+	// InvariantFunc checks a whole packet of one type for consistency
+	// between its header and its message.
+	InvariantFunc func(p *Packet) error
+)
+
+// RegisterInvariant adds check to the invariants VerifyInvariants enforces
+// on packets of type t, replacing a check registered before.
+func RegisterInvariant(t uint8, check InvariantFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	invariants[t] = check
+}
+
+// VerifyInvariants checks p, as returned by Decode or built by one of the
+// New constructors, against the rules of its type: the header version and
+// flags, the vector, the validator of the message and the invariant
+// registered for the type. Decode already enforces all of them, the check is
+// meant for packets that were handed around or modified since. Violations
+// are reported as ErrorInvariant.
+func VerifyInvariants(p *Packet) error {
+	t := p.Data.Type
+	if err := verifyInvariants(p); err != nil {
+		return fmt.Errorf("%w: %s packet: %w", ErrorInvariant, typeName(t), err)
+	}
+	return nil
+}
+
+func verifyInvariants(p *Packet) error {
+	t := p.Data.Type
+	mt, _, ok := lookupType(t)
+	if !ok {
+		return ErrorUnknownType
+	}
+	if v := p.Head.Version; v < MinVersion || v > MaxVersion {
+		return fmt.Errorf("%w: %d", ErrorUnsupportedVersion, v)
+	}
+	if p.Head.Version < FlagsVersion && p.Head.Flags != 0 {
+		return fmt.Errorf("flags %#x before version %d", p.Head.Flags, FlagsVersion)
+	}
+	if err := checkFields(p.Head, t); err != nil {
+		return err
+	}
+	if err := checkFieldFlags(p.Head, p.Data); err != nil {
+		return err
+	}
+	if err := checkPeerDelta(p.Head, t); err != nil {
+		return err
+	}
+	if hasVector(t) && len(p.Data.Vector) != bodyVectorLen {
+		return fmt.Errorf("%w: %d bytes", ErrorUnableToReadVector, len(p.Data.Vector))
+	}
+	if !hasVector(t) && p.Data.Vector != nil {
+		return ErrorUnexpectedVector
+	}
+	if p.Data.Msg == nil {
+		return ErrorUnableToReadMessage
+	}
+	if mt.validate != nil {
+		if err := mt.validate(p.Data.Msg); err != nil {
+			return fmt.Errorf("%w: %w", ErrorInvalidPayload, err)
+		}
+	}
+
+	registryMu.RLock()
+	check := invariants[t]
+	registryMu.RUnlock()
+	if check != nil {
+		return check(p)
+	}
+	return nil
+}
+
+func heartbeatInvariant(p *Packet) error {
+	return checkHeartbeatDelta(p.Head, p.Data.Msg)
+}
+
+func peerInfoInvariant(p *Packet) error {
+	m := p.Data.Msg.(PeerInfoMessage)
+	entries, err := m.Entries()
+	if err != nil {
+		return err
+	}
+	if len(entries) != m.EntryCount() {
+		return fmt.Errorf("peer info announces %d entries, carries %d", m.EntryCount(), len(entries))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyInvariants(t *testing.T) {
+	prioritized := NewTransferMessage([]byte{1, 2, 3})
+	prioritized.SetPriority(3)
+	delta := NewTimedHeartbeatMessage(net.IPv4(10, 0, 0, 1), time.Unix(1700000000, 0))
+	if err := delta.SetPeerDelta([]PeerEntry{{PrivateIP: net.IPv4(10, 0, 0, 2), PublicIP: net.IPv4(192, 0, 2, 2), Port: 7001}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pack := range []*Packet{
+		NewOkMessage(),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		delta,
+		NewTransferMessage([]byte{1, 2, 3}),
+		prioritized,
+		NewPeerTableMessage(net.IPv4(10, 0, 0, 1), []PeerEntry{{PrivateIP: net.IPv4(10, 0, 0, 2), PublicIP: net.IPv4(192, 0, 2, 2), Port: 7001}}),
+	} {
+		if err := VerifyInvariants(pack); err != nil {
+			t.Fatalf("%#v: %v", pack, err)
+		}
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyInvariants(decoded); err != nil {
+			t.Fatalf("decoded %#v: %v", decoded, err)
+		}
+	}
+}
+
+func TestVerifyInvariantsViolation(t *testing.T) {
+	unflagged := NewTransferMessage([]byte{1})
+	unflagged.Data.Priority = 3
+
+	table := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), []PeerEntry{{PrivateIP: net.IPv4(10, 0, 0, 2), PublicIP: net.IPv4(192, 0, 2, 2), Port: 7001}})
+	msg := table.Data.Msg.(PeerInfoMessage)
+	binary.BigEndian.PutUint16(msg[net.IPv4len:], 2)
+
+	flagged := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	flagged.Head.Version = FlagsVersion
+	flagged.Head.Flags |= FlagPeerDelta
+
+	for _, tc := range []struct {
+		pack    *Packet
+		err     error
+		message string
+	}{
+		{unflagged, ErrorInvalidPriority, "transfer packet"},
+		{table, ErrorInvalidPayload, "table of 2 entries"},
+		{flagged, ErrorInvalidPeerDelta, "heartbeat packet"},
+	} {
+		err := VerifyInvariants(tc.pack)
+		if !errors.Is(err, ErrorInvariant) || !errors.Is(err, tc.err) {
+			t.Fatalf("%#v: expected %v, got %v", tc.pack, tc.err, err)
+		}
+		if !strings.Contains(err.Error(), tc.message) {
+			t.Fatalf("%q does not mention %q", err, tc.message)
+		}
+	}
+}
+
+func TestRegisterInvariant(t *testing.T) {
+	errOk := errors.New("ok packet after close")
+	RegisterInvariant(TypeOk, func(p *Packet) error { return errOk })
+	defer RegisterInvariant(TypeOk, nil)
+
+	if err := VerifyInvariants(NewOkMessage()); !errors.Is(err, errOk) {
+		t.Fatalf("expected %v, got %v", errOk, err)
+	}
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+)
+
+var (
+	ErrorMalformedIP = errors.New("malformed ip packet")
+	ErrorSpoofedIP   = errors.New("ip source outside allowed range")
+	ErrorNotTransfer = errors.New("not a transfer message")
+)
+
+// ValidateIPPayload checks that payload, the tunnelled packet of a Transfer,
+// is a well-formed IPv4 or IPv6 packet whose source lies in allowedSrc, the
+// range allocated to the peer it came from.
+func ValidateIPPayload(payload []byte, allowedSrc net.IPNet) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("%w: empty", ErrorMalformedIP)
+	}
+
+	var src net.IP
+	switch version := payload[0] >> 4; version {
+	case 4:
+		if len(payload) < ipv4HeaderLen {
+			return fmt.Errorf("%w: ipv4 header of %d bytes", ErrorMalformedIP, len(payload))
+		}
+		headerLen := int(payload[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(payload[2:]))
+		if headerLen < ipv4HeaderLen || headerLen > totalLen || totalLen > len(payload) {
+			return fmt.Errorf("%w: ipv4 header of %d and total of %d bytes in %d", ErrorMalformedIP, headerLen, totalLen, len(payload))
+		}
+		src = net.IP(payload[12:16])
+	case 6:
+		if len(payload) < ipv6HeaderLen {
+			return fmt.Errorf("%w: ipv6 header of %d bytes", ErrorMalformedIP, len(payload))
+		}
+		if payloadLen := int(binary.BigEndian.Uint16(payload[4:])); ipv6HeaderLen+payloadLen > len(payload) {
+			return fmt.Errorf("%w: ipv6 payload of %d bytes in %d", ErrorMalformedIP, payloadLen, len(payload)-ipv6HeaderLen)
+		}
+		src = net.IP(payload[8:24])
+	default:
+		return fmt.Errorf("%w: version %d", ErrorMalformedIP, version)
+	}
+
+	if !allowedSrc.Contains(src) {
+		return fmt.Errorf("%w: %s not in %s", ErrorSpoofedIP, src, &allowedSrc)
+	}
+	return nil
+}
+
+// IPPayloadValidator returns a validator running ValidateIPPayload on
+// Transfer messages.
+func IPPayloadValidator(allowedSrc net.IPNet) ValidateFunc {
+	return func(msg Message) error {
+		m, ok := msg.(TransferMessage)
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrorNotTransfer, msg)
+		}
+		if err := validateTransfer(m); err != nil {
+			return err
+		}
+		return ValidateIPPayload(m, allowedSrc)
+	}
+}
+
+// IPPayloadInterceptor rejects Transfer packets failing ValidateIPPayload.
+// Installed on the Decoder of a peer, it holds the peer to its own range.
+func IPPayloadInterceptor(allowedSrc net.IPNet) DecodeInterceptor {
+	validate := IPPayloadValidator(allowedSrc)
+	return func(pack *Packet) (*Packet, error) {
+		if pack.Data.Type != TypeTransfer {
+			return pack, nil
+		}
+		if err := validate(pack.Data.Msg); err != nil {
+			return nil, &DecodeError{Type: TypeTransfer, Err: fmt.Errorf("%w: %w", ErrorInvalidPayload, err)}
+		}
+		return pack, nil
+	}
+}
@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func ipv4Packet(src net.IP, payload []byte) []byte {
+	p := make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(payload))
+	p[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(p[2:], uint16(ipv4HeaderLen+len(payload)))
+	p[9] = 17
+	copy(p[12:], src.To4())
+	copy(p[16:], net.IPv4(10, 0, 0, 1).To4())
+	return append(p, payload...)
+}
+
+func ipv6Packet(src net.IP, payload []byte) []byte {
+	p := make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(payload))
+	p[0] = 6 << 4
+	binary.BigEndian.PutUint16(p[4:], uint16(len(payload)))
+	p[6] = 17
+	copy(p[8:], src.To16())
+	copy(p[24:], net.ParseIP("fd00::1"))
+	return append(p, payload...)
+}
+
+func TestValidateIPPayload(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.0.0.0/24")
+	_, v6, _ := net.ParseCIDR("fd00::/64")
+
+	for _, tt := range []struct {
+		name    string
+		payload []byte
+		allowed *net.IPNet
+		err     error
+	}{
+		{"ipv4", ipv4Packet(net.IPv4(10, 0, 0, 7), []byte("udp")), v4, nil},
+		{"ipv6", ipv6Packet(net.ParseIP("fd00::7"), []byte("udp")), v6, nil},
+		{"ipv4 spoofed", ipv4Packet(net.IPv4(10, 0, 1, 7), []byte("udp")), v4, ErrorSpoofedIP},
+		{"ipv6 spoofed", ipv6Packet(net.ParseIP("fd01::7"), []byte("udp")), v6, ErrorSpoofedIP},
+		{"ipv6 in ipv4 range", ipv6Packet(net.ParseIP("fd00::7"), nil), v4, ErrorSpoofedIP},
+		{"ipv4 truncated header", ipv4Packet(net.IPv4(10, 0, 0, 7), nil)[:12], v4, ErrorMalformedIP},
+		{"ipv6 truncated header", ipv6Packet(net.ParseIP("fd00::7"), nil)[:24], v6, ErrorMalformedIP},
+		{"ipv4 truncated payload", ipv4Packet(net.IPv4(10, 0, 0, 7), []byte("udp"))[:21], v4, ErrorMalformedIP},
+		{"unknown version", bytes.Repeat([]byte{0x50}, 40), v4, ErrorMalformedIP},
+	} {
+		if err := ValidateIPPayload(tt.payload, *tt.allowed); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestIPPayloadInterceptor(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/24")
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	enc.Encode(NewTransferMessage(ipv4Packet(net.IPv4(10, 0, 0, 7), []byte("udp"))))
+	enc.Encode(NewTransferMessage(ipv4Packet(net.IPv4(192, 168, 0, 7), []byte("udp"))))
+
+	dec := NewDecoder(&stream, nil)
+	dec.Use(IPPayloadInterceptor(*allowed))
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(); !errors.Is(err, ErrorSpoofedIP) || !errors.Is(err, ErrorInvalidPayload) {
+		t.Fatalf("expected %v, got %v", ErrorSpoofedIP, err)
+	}
+}
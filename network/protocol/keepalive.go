@@ -0,0 +1,310 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeepaliveMin = time.Second
+	DefaultKeepaliveMax = 30 * time.Second
+
+	// DefaultHeartbeatSkew is how far a heartbeat timestamp may be from the
+	// local clock, in either direction.
+	DefaultHeartbeatSkew = 30 * time.Second
+
+	// an RTT sample this many times above the smoothed RTT is a spike
+	rttSpikeFactor = 2
+
+	// the keepalive handshake field carries the interval in milliseconds
+	keepaliveFieldLen = 4
+)
+
+var (
+	ErrorStaleHeartbeat = errors.New("stale heartbeat")
+	ErrorProbeTimeout   = errors.New("probe unanswered")
+)
+
+// Keepalive decides how often heartbeats should be sent to a peer. The
+// interval backs off towards MaxInterval while the link is stable and is
+// halved, down to MinInterval, whenever a heartbeat is lost or the RTT spikes.
+type Keepalive struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// MaxSkew bounds the clock difference accepted by Received.
+	MaxSkew time.Duration
+
+	mu         sync.Mutex
+	now        func() time.Time
+	interval   time.Duration
+	negotiated time.Duration
+	srtt       time.Duration
+	lastSent   time.Time
+	lastHeard  time.Time
+	probes     []*keepaliveProbe
+}
+
+// ProbeResult is the outcome of ProbeNow: the round trip time, or why the
+// probe went unanswered.
+type ProbeResult struct {
+	RTT time.Duration
+	Err error
+}
+
+type keepaliveProbe struct {
+	sent   time.Time
+	result chan ProbeResult
+}
+
+func NewKeepalive(min, max time.Duration) *Keepalive {
+	if min <= 0 {
+		min = DefaultKeepaliveMin
+	}
+	if max < min {
+		max = min
+	}
+	return &Keepalive{
+		MinInterval: min,
+		MaxInterval: max,
+		MaxSkew:     DefaultHeartbeatSkew,
+		now:         time.Now,
+		interval:    min,
+	}
+}
+
+// KeepaliveIntervalField builds the handshake field announcing the longest
+// heartbeat interval the local node is willing to use.
+func KeepaliveIntervalField(interval time.Duration) HandshakeField {
+	ms := min(interval.Milliseconds(), int64(^uint32(0)))
+	return HandshakeField{Tag: HandshakeKeepalive, Value: binary.BigEndian.AppendUint32(nil, uint32(ms))}
+}
+
+// KeepaliveInterval returns the heartbeat interval the peer announced, false
+// if it sent none.
+func (m HandshakeMessage) KeepaliveInterval() (time.Duration, bool) {
+	value, ok := m.Field(HandshakeKeepalive)
+	if !ok || len(value) != keepaliveFieldLen {
+		return 0, false
+	}
+	return time.Duration(binary.BigEndian.Uint32(value)) * time.Millisecond, true
+}
+
+// Negotiate settles the heartbeat interval with the peer: the lower of local
+// and the interval announced in the peer's handshake, so neither side times
+// the other out. The Keepalive then sends at that interval and never backs
+// off beyond it. A peer announcing none, or announcing zero, gets local.
+func (k *Keepalive) Negotiate(local time.Duration, m HandshakeMessage) time.Duration {
+	interval := local
+	if remote, ok := m.KeepaliveInterval(); ok && remote > 0 {
+		interval = min(interval, remote)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.negotiated = interval
+	k.MaxInterval = interval
+	k.MinInterval = min(k.MinInterval, interval)
+	k.interval = interval
+	return interval
+}
+
+// Negotiated returns the interval settled by Negotiate, zero before.
+func (k *Keepalive) Negotiated() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.negotiated
+}
+
+// Interval returns the current heartbeat interval.
+func (k *Keepalive) Interval() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.interval
+}
+
+// RTT returns the smoothed round trip time, zero until a sample is observed.
+func (k *Keepalive) RTT() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.srtt
+}
+
+// Due reports whether a heartbeat should be sent now.
+func (k *Keepalive) Due() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lastSent.IsZero() || k.now().Sub(k.lastSent) >= k.interval
+}
+
+// Sent records that a heartbeat has just been sent.
+func (k *Keepalive) Sent() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastSent = k.now()
+}
+
+// Received checks a heartbeat from the peer before it counts towards
+// liveness. A timed heartbeat must be newer than the last one accepted and
+// within MaxSkew of the local clock, otherwise ErrorStaleHeartbeat is
+// returned. Plain heartbeats from older peers carry no timestamp and are
+// accepted as is. An accepted heartbeat echoing the send time of a pending
+// probe answers it, see ProbeNow.
+func (k *Keepalive) Received(m HeartbeatMessage) error {
+	probes, now, err := k.accept(m)
+	if err != nil {
+		return err
+	}
+	for _, p := range probes {
+		rtt := now.Sub(p.sent)
+		k.ObserveRTT(rtt)
+		p.result <- ProbeResult{RTT: rtt}
+	}
+	return nil
+}
+
+// accept records m when it is fresh and returns the probes it answers
+// along with the time it was received. Only a heartbeat echoing the send
+// time of a probe answers it: the timestamp of the peer is taken on its
+// own clock, which may be up to MaxSkew off, so comparing it with the send
+// time would let a heartbeat that crossed the probe answer it.
+func (k *Keepalive) accept(m HeartbeatMessage) ([]*keepaliveProbe, time.Time, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	sent, ok := m.Timestamp()
+	if !ok {
+		return nil, now, nil
+	}
+	if !sent.After(k.lastHeard) {
+		return nil, now, ErrorStaleHeartbeat
+	}
+	if skew := now.Sub(sent); skew > k.MaxSkew || skew < -k.MaxSkew {
+		return nil, now, ErrorStaleHeartbeat
+	}
+	k.lastHeard = sent
+
+	echo, ok := m.Echo()
+	if !ok {
+		return nil, now, nil
+	}
+	var answered []*keepaliveProbe
+	pending := k.probes[:0]
+	for _, p := range k.probes {
+		if p.sent.UnixNano() == echo.UnixNano() {
+			answered = append(answered, p)
+		} else {
+			pending = append(pending, p)
+		}
+	}
+	clear(k.probes[len(pending):])
+	k.probes = pending
+	return answered, now, nil
+}
+
+// ProbeNow sends a timed heartbeat for privateIP with send right away,
+// outside the schedule, to confirm the peer is alive before relying on it.
+// The returned channel receives the round trip time once the peer's Reply
+// echoing the probe is Received, which also feeds ObserveRTT; any other
+// heartbeat leaves the probe pending. A probe unanswered after timeout
+// receives ErrorProbeTimeout and counts as a loss; one that could not be
+// sent receives the error of send.
+func (k *Keepalive) ProbeNow(send func(*Packet) error, privateIP net.IP, timeout time.Duration) <-chan ProbeResult {
+	k.mu.Lock()
+	p := &keepaliveProbe{
+		sent:   k.now(),
+		result: make(chan ProbeResult, 1),
+	}
+	k.probes = append(k.probes, p)
+	k.lastSent = p.sent
+	k.mu.Unlock()
+
+	if err := send(NewTimedHeartbeatMessage(privateIP, p.sent)); err != nil {
+		k.dropProbe(p, err)
+		return p.result
+	}
+	time.AfterFunc(timeout, func() {
+		if k.dropProbe(p, ErrorProbeTimeout) {
+			k.ObserveLoss()
+		}
+	})
+	return p.result
+}
+
+// Reply returns the heartbeat for privateIP answering m, a heartbeat of the
+// peer, by echoing its send time, or nil when m needs no answer: it is
+// untimed or an answer itself. Sent right away, it lets the probes of the
+// peer measure the round trip. It counts as the scheduled heartbeat.
+func (k *Keepalive) Reply(privateIP net.IP, m HeartbeatMessage) *Packet {
+	sent, ok := m.Timestamp()
+	if !ok {
+		return nil
+	}
+	if _, ok := m.Echo(); ok {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastSent = k.now()
+	return NewEchoHeartbeatMessage(privateIP, k.lastSent, sent)
+}
+
+// dropProbe fails p with err unless it was answered already, reporting
+// whether it was still pending.
+func (k *Keepalive) dropProbe(p *keepaliveProbe, err error) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, pending := range k.probes {
+		if pending == p {
+			k.probes = append(k.probes[:i], k.probes[i+1:]...)
+			p.result <- ProbeResult{Err: err}
+			return true
+		}
+	}
+	return false
+}
+
+// ObserveRTT feeds a round trip sample of an answered heartbeat.
+func (k *Keepalive) ObserveRTT(rtt time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.srtt == 0 {
+		k.srtt = rtt
+		return
+	}
+
+	if rtt > rttSpikeFactor*k.srtt {
+		k.tighten()
+	} else {
+		k.backOff()
+	}
+	// same smoothing as TCP, srtt = 7/8 srtt + 1/8 rtt
+	k.srtt += (rtt - k.srtt) / 8
+}
+
+// ObserveLoss records a heartbeat that went unanswered.
+func (k *Keepalive) ObserveLoss() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.tighten()
+}
+
+func (k *Keepalive) tighten() {
+	k.interval /= 2
+	if k.interval < k.MinInterval {
+		k.interval = k.MinInterval
+	}
+}
+
+func (k *Keepalive) backOff() {
+	k.interval += k.interval / 4
+	if k.interval > k.MaxInterval {
+		k.interval = k.MaxInterval
+	}
+}
@@ -0,0 +1,267 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestKeepalive(min, max time.Duration) (*Keepalive, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	k := NewKeepalive(min, max)
+	k.now = clock.Now
+	return k, clock
+}
+
+func TestKeepaliveBacksOffWhenStable(t *testing.T) {
+	k, _ := newTestKeepalive(time.Second, 10*time.Second)
+
+	prev := k.Interval()
+	for i := 0; i < 50; i++ {
+		k.ObserveRTT(50 * time.Millisecond)
+		if k.Interval() < prev {
+			t.Fatalf("interval shrank on stable link: %v -> %v", prev, k.Interval())
+		}
+		prev = k.Interval()
+	}
+	if k.Interval() != 10*time.Second {
+		t.Fatalf("expected interval to reach max, got %v", k.Interval())
+	}
+}
+
+func TestKeepaliveTightensOnSpikeAndLoss(t *testing.T) {
+	k, _ := newTestKeepalive(time.Second, 10*time.Second)
+	for i := 0; i < 50; i++ {
+		k.ObserveRTT(50 * time.Millisecond)
+	}
+
+	k.ObserveRTT(500 * time.Millisecond)
+	if k.Interval() != 5*time.Second {
+		t.Fatalf("expected interval to halve on RTT spike, got %v", k.Interval())
+	}
+
+	for i := 0; i < 10; i++ {
+		k.ObserveLoss()
+	}
+	if k.Interval() != time.Second {
+		t.Fatalf("expected interval clamped to min, got %v", k.Interval())
+	}
+}
+
+func TestKeepaliveDue(t *testing.T) {
+	k, clock := newTestKeepalive(2*time.Second, 10*time.Second)
+
+	if !k.Due() {
+		t.Fatal("first heartbeat should be due immediately")
+	}
+	k.Sent()
+	clock.Advance(time.Second)
+	if k.Due() {
+		t.Fatal("heartbeat due before interval elapsed")
+	}
+	clock.Advance(time.Second)
+	if !k.Due() {
+		t.Fatal("heartbeat not due after interval elapsed")
+	}
+}
+
+func TestKeepaliveReceivedTimestamps(t *testing.T) {
+	k, clock := newTestKeepalive(time.Second, 10*time.Second)
+	k.MaxSkew = 5 * time.Second
+	ip := net.IPv4(10, 0, 0, 1)
+
+	heartbeat := func(sent time.Time) HeartbeatMessage {
+		pack, err := encodeDecode(t, NewTimedHeartbeatMessage(ip, sent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pack.Data.Msg.(HeartbeatMessage)
+	}
+
+	first := heartbeat(clock.Now().Add(-time.Second))
+	if err := k.Received(first); err != nil {
+		t.Fatalf("in window heartbeat rejected: %v", err)
+	}
+	if err := k.Received(first); err != ErrorStaleHeartbeat {
+		t.Fatalf("replayed heartbeat: expected %v, got %v", ErrorStaleHeartbeat, err)
+	}
+	if err := k.Received(heartbeat(clock.Now().Add(-2 * time.Second))); err != ErrorStaleHeartbeat {
+		t.Fatalf("older heartbeat: expected %v, got %v", ErrorStaleHeartbeat, err)
+	}
+	if err := k.Received(heartbeat(clock.Now().Add(time.Minute))); err != ErrorStaleHeartbeat {
+		t.Fatalf("future heartbeat: expected %v, got %v", ErrorStaleHeartbeat, err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := k.Received(heartbeat(clock.Now().Add(-10 * time.Second))); err != ErrorStaleHeartbeat {
+		t.Fatalf("delayed heartbeat: expected %v, got %v", ErrorStaleHeartbeat, err)
+	}
+	if err := k.Received(heartbeat(clock.Now().Add(2 * time.Second))); err != nil {
+		t.Fatalf("slightly skewed heartbeat rejected: %v", err)
+	}
+
+	if err := k.Received(HeartbeatMessage(ip.To4())); err != nil {
+		t.Fatalf("plain heartbeat rejected: %v", err)
+	}
+}
+
+func TestKeepaliveNegotiate(t *testing.T) {
+	handshake := func(fields ...HandshakeField) HandshakeMessage {
+		pack := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}, fields...)
+		got, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Data.Msg.(HandshakeMessage)
+	}
+
+	a, _ := newTestKeepalive(time.Second, 30*time.Second)
+	b, clock := newTestKeepalive(time.Second, 30*time.Second)
+	ia := a.Negotiate(10*time.Second, handshake(KeepaliveIntervalField(4*time.Second)))
+	ib := b.Negotiate(4*time.Second, handshake(KeepaliveIntervalField(10*time.Second)))
+	if ia != 4*time.Second || ib != 4*time.Second {
+		t.Fatalf("peers settled on %v and %v, expected 4s", ia, ib)
+	}
+	if b.Negotiated() != 4*time.Second || b.Interval() != 4*time.Second {
+		t.Fatalf("negotiated interval not stored: %v, interval %v", b.Negotiated(), b.Interval())
+	}
+	if n := a.Negotiate(10*time.Second, handshake()); n != 10*time.Second {
+		t.Fatalf("expected the local interval with a peer announcing none, got %v", n)
+	}
+
+	sent := 0
+	for i := 0; i < 40; i++ {
+		if b.Due() {
+			b.Sent()
+			sent++
+		}
+		b.ObserveRTT(50 * time.Millisecond)
+		clock.Advance(time.Second)
+	}
+	if sent != 10 {
+		t.Fatalf("expected a heartbeat every 4s over 40s, sent %d", sent)
+	}
+	if b.Interval() != 4*time.Second {
+		t.Fatalf("interval backed off beyond the negotiated one: %v", b.Interval())
+	}
+
+	malformed := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{},
+		HandshakeField{Tag: HandshakeKeepalive, Value: []byte{1}})
+	if _, err := encodeDecode(t, malformed); !errors.Is(err, ErrorMalformedHandshakeField) {
+		t.Fatalf("expected %v for a truncated interval, got %v", ErrorMalformedHandshakeField, err)
+	}
+}
+
+func TestKeepaliveProbeNow(t *testing.T) {
+	k, clock := newTestKeepalive(time.Second, 10*time.Second)
+	local, remote := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	var sent []*Packet
+	send := func(pack *Packet) error {
+		sent = append(sent, pack)
+		return nil
+	}
+	result := k.ProbeNow(send, local, time.Second)
+	if len(sent) != 1 {
+		t.Fatalf("expected an immediate heartbeat, sent %d", len(sent))
+	}
+	if ts, ok := sent[0].Data.Msg.(HeartbeatMessage).Timestamp(); !ok || !ts.Equal(clock.Now()) {
+		t.Fatalf("probe not stamped with the send time: %v", ts)
+	}
+	if k.Due() {
+		t.Fatal("probe did not count as the scheduled heartbeat")
+	}
+
+	// heartbeats not echoing the probe do not answer it, even stamped
+	// after it by a clock running ahead
+	clock.Advance(10 * time.Millisecond)
+	for _, msg := range []HeartbeatMessage{
+		NewTimedHeartbeatMessage(remote, clock.Now().Add(time.Second)).Data.Msg.(HeartbeatMessage),
+		HeartbeatMessage(remote.To4()),
+		NewEchoHeartbeatMessage(remote, clock.Now().Add(2*time.Second), clock.Now().Add(-time.Millisecond)).Data.Msg.(HeartbeatMessage),
+	} {
+		if err := k.Received(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case r := <-result:
+		t.Fatalf("probe answered by an earlier heartbeat: %+v", r)
+	default:
+	}
+	if k.RTT() != 0 {
+		t.Fatalf("unmatched heartbeat observed as RTT %v", k.RTT())
+	}
+
+	// the peer answers the probe with its Reply
+	peer, peerClock := newTestKeepalive(time.Second, 10*time.Second)
+	peerClock.Advance(5 * time.Second)
+	reply := peer.Reply(remote, sent[0].Data.Msg.(HeartbeatMessage))
+	if reply == nil {
+		t.Fatal("no reply to the probe")
+	}
+	if peer.Reply(remote, reply.Data.Msg.(HeartbeatMessage)) != nil {
+		t.Fatal("a reply was answered")
+	}
+	clock.Advance(30 * time.Millisecond)
+	if err := k.Received(reply.Data.Msg.(HeartbeatMessage)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-result:
+		if r.Err != nil || r.RTT != 40*time.Millisecond {
+			t.Fatalf("expected a 40ms round trip, got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("answered probe did not fire")
+	}
+	if k.RTT() != 40*time.Millisecond {
+		t.Fatalf("probe round trip not observed, RTT %v", k.RTT())
+	}
+}
+
+func TestKeepaliveProbeTimeout(t *testing.T) {
+	k, _ := newTestKeepalive(time.Second, 10*time.Second)
+	for i := 0; i < 50; i++ {
+		k.ObserveRTT(50 * time.Millisecond)
+	}
+
+	result := k.ProbeNow(func(*Packet) error { return nil }, net.IPv4(10, 0, 0, 1), 20*time.Millisecond)
+	select {
+	case r := <-result:
+		if r.Err != ErrorProbeTimeout {
+			t.Fatalf("expected %v, got %+v", ErrorProbeTimeout, r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unanswered probe did not time out")
+	}
+	if k.Interval() != 5*time.Second {
+		t.Fatalf("expected the timeout to count as a loss, interval %v", k.Interval())
+	}
+
+	errSend := errors.New("link down")
+	r := <-k.ProbeNow(func(*Packet) error { return errSend }, net.IPv4(10, 0, 0, 1), time.Second)
+	if r.Err != errSend {
+		t.Fatalf("expected %v, got %+v", errSend, r)
+	}
+	// a heartbeat arriving after the probe gave up answers nothing
+	if err := k.Received(HeartbeatMessage(net.IPv4(10, 0, 0, 2).To4())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrorInvalidOptions = errors.New("invalid options")
+)
+
+// Validate checks that opts describes a layout packets can be encoded in.
+func (opts Options) Validate() error {
+	if opts.VectorLen != 0 && opts.VectorLen != bodyVectorLen {
+		return fmt.Errorf("%w: vector of %d bytes, the nonce is %d", ErrorInvalidOptions, opts.VectorLen, bodyVectorLen)
+	}
+	if vectorLen := opts.vectorLen(); opts.VectorOffset < 0 && opts.VectorOffset > -vectorLen {
+		return fmt.Errorf("%w: vector at %d would run past the message end", ErrorInvalidOptions, opts.VectorOffset)
+	}
+	if opts.VectorOffset > MaxMessageLen || opts.VectorOffset < -MaxMessageLen {
+		return fmt.Errorf("%w: vector offset %d beyond any message", ErrorInvalidOptions, opts.VectorOffset)
+	}
+	return nil
+}
+
+func (opts Options) vectorLen() int {
+	if opts.VectorLen == 0 {
+		return bodyVectorLen
+	}
+	return opts.VectorLen
+}
+
+// EncodeWithOptions is Encode with the vector placed as opts describes.
+// The checksum, if any, still covers the default layout.
+func EncodeWithOptions(pack *Packet, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := Encode(pack)
+	if err != nil {
+		return nil, err
+	}
+	if err = relocateVector(data, opts, true); err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+	return data, nil
+}
+
+// DecodeWithOptions is Decode for packets written by EncodeWithOptions with
+// the same opts.
+func DecodeWithOptions(r io.Reader, opts Options) (*Packet, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var head [maxHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:3]); err != nil {
+		return nil, err
+	}
+	headLen := int(Header{Version: head[2]}.Len())
+	frame := make([]byte, headLen+int(binary.BigEndian.Uint16(head[:])))
+	copy(frame, head[:3])
+	if _, err := io.ReadFull(r, frame[3:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if err := relocateVector(frame, opts, false); err != nil {
+		t := uint8(0)
+		if len(frame) > headLen {
+			t = frame[headLen]
+		}
+		return nil, &DecodeError{Type: t, Err: err}
+	}
+	return Decode(bytes.NewReader(frame))
+}
+
+// relocateVector moves the vector of the encoded packet in data from the
+// default position to the one of opts, or back when toWire is false.
+func relocateVector(data []byte, opts Options, toWire bool) error {
+	if opts.VectorOffset == 0 {
+		return nil
+	}
+	head := Header{Version: data[2]}
+	if head.Version >= FlagsVersion {
+		head.Flags = data[3]
+	}
+	start := int(head.Len())
+	if len(data) <= start || !hasVector(data[start]) {
+		return nil
+	}
+	start += 1 + fieldsLen(head.Flags) // type and optional fields
+	end := len(data) - int(head.Checksum().Len())
+
+	vectorLen := opts.vectorLen()
+	msgLen := end - start - vectorLen
+	pos := opts.VectorOffset
+	if pos < 0 {
+		pos += msgLen + vectorLen
+	}
+	if msgLen < 0 || pos < 0 || pos > msgLen {
+		return fmt.Errorf("%w: vector at %d of a %d byte message", ErrorUnableToReadVector, opts.VectorOffset, max(msgLen, 0))
+	}
+
+	// the vector and the message bytes preceding it swap places
+	region := data[start : start+vectorLen+pos]
+	if toWire {
+		rotate(region, vectorLen)
+	} else {
+		rotate(region, pos)
+	}
+	return nil
+}
+
+// rotate moves the first n bytes of b to its end.
+func rotate(b []byte, n int) {
+	reverse(b[:n])
+	reverse(b[n:])
+	reverse(b)
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestVectorLayoutRoundTrip(t *testing.T) {
+	payload := []byte("tunnelled ip packet")
+	for _, offset := range []int{0, 4, len(payload), -bodyVectorLen, -bodyVectorLen - 3} {
+		for _, alg := range []ChecksumAlgorithm{ChecksumNone, ChecksumCRC32C} {
+			opts := Options{VectorOffset: offset, VectorLen: bodyVectorLen}
+			pack := NewTransferMessage(payload)
+			pack.SetPriority(2)
+			if alg != ChecksumNone {
+				pack.SetChecksum(alg)
+			}
+
+			data, err := EncodeWithOptions(pack, opts)
+			if err != nil {
+				t.Fatalf("offset %d: %v", offset, err)
+			}
+			body := data[pack.Head.Len()+2:]
+			pos := offset
+			if pos < 0 {
+				pos += len(payload) + bodyVectorLen
+			}
+			if !bytes.Equal(body[pos:pos+bodyVectorLen], pack.Data.Vector) {
+				t.Fatalf("offset %d: vector not at %d of %x", offset, pos, body)
+			}
+
+			got, err := DecodeWithOptions(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatalf("offset %d: %v", offset, err)
+			}
+			if !samePacket(t, got, pack) {
+				t.Fatalf("offset %d: decoded %#v, expected %#v", offset, got, pack)
+			}
+		}
+	}
+}
+
+func TestVectorLayoutUnaffectedTypes(t *testing.T) {
+	opts := Options{VectorOffset: 3}
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	data, err := EncodeWithOptions(pack, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := Encode(pack); !bytes.Equal(data, plain) {
+		t.Fatal("layout applied to a type without vector")
+	}
+	if _, err = DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVectorLayoutValidate(t *testing.T) {
+	for _, opts := range []Options{
+		{VectorLen: 12},
+		{VectorOffset: -1},
+		{VectorOffset: -bodyVectorLen + 1},
+		{VectorOffset: MaxMessageLen + 1},
+	} {
+		if err := opts.Validate(); !errors.Is(err, ErrorInvalidOptions) {
+			t.Errorf("%+v: expected %v, got %v", opts, ErrorInvalidOptions, err)
+		}
+	}
+
+	// an offset past the end of the message
+	_, err := EncodeWithOptions(NewTransferMessage([]byte{1, 2}), Options{VectorOffset: 3})
+	if !errors.Is(err, ErrorUnableToReadVector) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadVector, err)
+	}
+}
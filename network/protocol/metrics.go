@@ -0,0 +1,60 @@
+package protocol
+
+import "sync/atomic"
+
+type (
+	// Metrics receives protocol events. Sizes are wire sizes in bytes.
+	// Implementations are called from the encoding and decoding goroutines
+	// and must be safe for concurrent use.
+	Metrics interface {
+		PacketEncoded(t uint8, size int)
+		PacketDecoded(t uint8, size int)
+		DecodeFailed(err error)
+	}
+
+	// PayloadMetrics is implemented by Metrics that also want the size of
+	// the message each packet carries: before compression and sealing when
+	// encoded, after opening and decompression when decoded. Compared to
+	// the wire sizes, it tells what compression saves and overhead costs.
+	PayloadMetrics interface {
+		PayloadEncoded(t uint8, size int)
+		PayloadDecoded(t uint8, size int)
+	}
+
+	metricsHolder struct {
+		m Metrics
+	}
+)
+
+var metrics atomic.Pointer[metricsHolder]
+
+// SetMetrics installs m to receive events of packets written by Encoder and
+// EncodeAndWrite and of all decoded packets. Passing nil disables reporting,
+// which costs a single atomic load per packet.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		metrics.Store(nil)
+		return
+	}
+	metrics.Store(&metricsHolder{m: m})
+}
+
+func currentMetrics() Metrics {
+	if h := metrics.Load(); h != nil {
+		return h.m
+	}
+	return nil
+}
+
+// reportEncoded reports pack, written as size bytes, to the installed
+// Metrics.
+func reportEncoded(pack *Packet, size int) {
+	m := currentMetrics()
+	if m == nil {
+		return
+	}
+	m.PacketEncoded(pack.Data.Type, size)
+	if pm, ok := m.(PayloadMetrics); ok && pack.Data.Msg != nil {
+		pm.PayloadEncoded(pack.Data.Type, int(pack.Data.Msg.Len()))
+	}
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FlagMessageID marks a body carrying a message id right after the send
+// time. A sender resending a lost control message keeps its id, so the
+// receiver can tell the copy from a new message, see Decoder.SeenID.
+const FlagMessageID uint8 = 1 << 7
+
+const (
+	// messageIDLen is the wire size of a message id.
+	messageIDLen = 4
+
+	// seenIDsLen is the number of message ids a Decoder remembers.
+	seenIDsLen = 64
+)
+
+var (
+	ErrorInvalidMessageID = errors.New("message id not allowed on handshake packets or before flags version")
+	ErrorNothingToResend  = errors.New("no control message to resend")
+)
+
+// seenIDs holds the most recent message ids, forgetting the oldest once
+// full.
+type seenIDs struct {
+	ids  map[uint32]struct{}
+	ring [seenIDsLen]uint32
+	next int
+}
+
+// SetMessageID tags p with id, upgrading its header to FlagsVersion when
+// needed. Zero removes the id. An Encoder with message ids enabled tags the
+// control messages it writes instead, see Encoder.SetMessageIDs.
+func (p *Packet) SetMessageID(id uint32) {
+	p.Data.MessageID = id
+	if id == 0 {
+		p.Head.Flags &^= FlagMessageID
+	} else {
+		if p.Head.Version < FlagsVersion {
+			p.Head.Version = FlagsVersion
+		}
+		p.Head.Flags |= FlagMessageID
+	}
+	p.Head.Length = p.Data.Len() + p.Head.Checksum().Len()
+}
+
+// SetMessageIDs makes Encode tag every control message, the types sent in
+// the clear but handshakes, with an id of its own, and keep the last one
+// for Resend. Tagged packets are emitted with at least FlagsVersion.
+func (e *Encoder) SetMessageIDs(enabled bool) {
+	e.ids = enabled
+}
+
+// Resend writes the last control message tagged by Encode again, with the
+// same id, for a peer that may have lost it. It fails with
+// ErrorNothingToResend when message ids are disabled or none was sent yet.
+func (e *Encoder) Resend() error {
+	if e.last == nil {
+		return ErrorNothingToResend
+	}
+	return e.write(e.last)
+}
+
+// tag returns pack tagged with the next message id when it is a control
+// message and message ids are enabled, remembering it for Resend. A packet
+// tagged already, by the caller or as the one resent, keeps its id.
+func (e *Encoder) tag(pack *Packet) *Packet {
+	if !e.ids || pack.Data.hasMessageID() || hasVector(pack.Data.Type) || pack.Data.Type == TypeHandshake {
+		return pack
+	}
+	e.lastID++
+	if e.lastID == 0 {
+		// zero means no id
+		e.lastID++
+	}
+	tagged := *pack
+	tagged.SetMessageID(e.lastID)
+	e.last = &tagged
+	return &tagged
+}
+
+// SeenID reports whether a packet with the message id of pack was decoded
+// before, remembering the id of pack. The ids of the last 64 tagged packets
+// are kept. Packets without id are never seen: an application dropping
+// duplicates calls it for every control message it handles.
+func (d *Decoder) SeenID(pack *Packet) bool {
+	id := pack.Data.MessageID
+	if id == 0 {
+		return false
+	}
+	if d.seen == nil {
+		d.seen = &seenIDs{ids: make(map[uint32]struct{}, seenIDsLen)}
+	}
+	if _, ok := d.seen.ids[id]; ok {
+		return true
+	}
+	if len(d.seen.ids) == seenIDsLen {
+		delete(d.seen.ids, d.seen.ring[d.seen.next])
+	}
+	d.seen.ids[id] = struct{}{}
+	d.seen.ring[d.seen.next] = id
+	d.seen.next = (d.seen.next + 1) % seenIDsLen
+	return false
+}
+
+// hasMessageID reports whether the body carries a message id on the wire.
+func (b Body) hasMessageID() bool {
+	return b.MessageID != 0
+}
+
+// appendMessageID appends the wire form of the message id of b.
+func (b Body) appendMessageID(dst []byte) []byte {
+	return binary.BigEndian.AppendUint32(dst, b.MessageID)
+}
+
+// checkMessageID verifies that a message id is only carried where the
+// format allows it, the same places as a send time.
+func checkMessageID(head Header, t uint8) error {
+	if head.Flags&FlagMessageID != 0 && (head.Version < FlagsVersion || t == TypeHandshake) {
+		return ErrorInvalidMessageID
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestMessageIDResend(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	if err := enc.Resend(); !errors.Is(err, ErrorNothingToResend) {
+		t.Fatalf("expected %v, got %v", ErrorNothingToResend, err)
+	}
+	enc.SetMessageIDs(true)
+
+	peers := NewPeerInfoMessage(net.IPv4(10, 0, 0, 2))
+	for _, pack := range []*Packet{peers, NewTransferMessage([]byte("payload"))} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the transfer is not a control message, the peer info is resent
+	if err := enc.Resend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(NewGoneMessage(GoneReasonShutdown, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Resend(); err != nil {
+		t.Fatal(err)
+	}
+	if peers.Data.MessageID != 0 {
+		t.Fatal("encoder tagged the caller's packet")
+	}
+
+	dec := NewDecoder(&stream, rRecv)
+	for _, want := range []struct {
+		t         uint8
+		id        uint32
+		duplicate bool
+	}{
+		{TypePeerInfo, 1, false},
+		{TypeTransfer, 0, false},
+		{TypePeerInfo, 1, true},
+		{TypeGone, 2, false},
+		{TypeGone, 2, true},
+	} {
+		pack, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pack.Data.Type != want.t || pack.Data.MessageID != want.id {
+			t.Fatalf("expected %s with id %d, got %s with id %d", typeName(want.t), want.id, typeName(pack.Data.Type), pack.Data.MessageID)
+		}
+		if err := VerifyInvariants(pack); err != nil {
+			t.Fatal(err)
+		}
+		if dec.SeenID(pack) != want.duplicate {
+			t.Fatalf("%s %d: expected duplicate %t", typeName(want.t), want.id, want.duplicate)
+		}
+	}
+}
+
+func TestMessageIDWindow(t *testing.T) {
+	dec := NewDecoder(nil, nil)
+	pack := NewOkMessage()
+	for id := uint32(1); id <= seenIDsLen+1; id++ {
+		pack.SetMessageID(id)
+		if dec.SeenID(pack) {
+			t.Fatalf("id %d seen before", id)
+		}
+	}
+	// the oldest id was forgotten, the newest are remembered
+	pack.SetMessageID(seenIDsLen + 1)
+	if !dec.SeenID(pack) {
+		t.Fatal("expected the last id to be seen")
+	}
+	pack.SetMessageID(1)
+	if dec.SeenID(pack) {
+		t.Fatal("expected the oldest id to be forgotten")
+	}
+}
+
+func TestMessageIDFlag(t *testing.T) {
+	pack := NewOkMessage()
+	pack.SetMessageID(42)
+	if pack.Head.Version < FlagsVersion || pack.Head.Flags&FlagMessageID == 0 {
+		t.Fatalf("expected flags version with FlagMessageID, got %+v", pack.Head)
+	}
+	got, err := encodeDecode(t, pack)
+	if err != nil || got.Data.MessageID != 42 {
+		t.Fatalf("expected id 42, got %d: %v", got.Data.MessageID, err)
+	}
+
+	pack.SetMessageID(0)
+	if pack.Head.Flags&FlagMessageID != 0 {
+		t.Fatal("expected the flag to be cleared")
+	}
+
+	// a flagged id of zero is rejected
+	pack.SetMessageID(1)
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[pack.Head.Len()+1:], make([]byte, messageIDLen))
+	if _, err := Decode(bytes.NewReader(data)); !errors.Is(err, ErrorInvalidMessageID) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidMessageID, err)
+	}
+	pack.SetMessageID(0)
+
+	// the id is authenticated along with a sealed message
+	iSend, _, _, rRecv := testDirectionKeys()
+	transfer := NewTransferMessage([]byte("payload"))
+	transfer.SetMessageID(7)
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(transfer); err != nil {
+		t.Fatal(err)
+	}
+	data = stream.Bytes()
+	data[transfer.Head.Len()+1+messageIDLen-1] ^= 1
+	if _, err := NewDecoder(bytes.NewReader(data), rRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+
+	hs := NewHandshakePacket(make([]byte, sessionKeyLen), &secure.NetworkSecret{})
+	hs.SetMessageID(1)
+	if _, err := Encode(hs); !errors.Is(err, ErrorInvalidMessageID) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidMessageID, err)
+	}
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+)
+
+// connectionNonceLen is the size of the nonce a handshake announces and its
+// Ok echoes.
+const connectionNonceLen = 8
+
+var (
+	ErrorNonceMismatch = errors.New("ok does not echo the handshake nonce")
+)
+
+// ConnectionNonceField builds the handshake field carrying a fresh random
+// connection nonce. The peer echoes it in its Ok, which binds the reply to
+// this handshake rather than one sent on another connection.
+func ConnectionNonceField() HandshakeField {
+	return HandshakeField{Tag: HandshakeNonce, Value: randomBytes(connectionNonceLen)}
+}
+
+// ConnectionNonce returns the nonce the handshake carries, nil if none.
+func (m HandshakeMessage) ConnectionNonce() []byte {
+	nonce, _ := m.Field(HandshakeNonce)
+	return nonce
+}
+
+// NewOkReply builds the answer to hs with status, echoing the connection
+// nonce of hs. A handshake without nonce gets the reply of NewOkMessage or
+// NewRejectMessage, which peers predating nonces understand.
+func NewOkReply(hs HandshakeMessage, status OkStatus) *Packet {
+	nonce := hs.ConnectionNonce()
+	if nonce == nil {
+		if status == OkAccepted {
+			return NewOkMessage()
+		}
+		return NewRejectMessage(status)
+	}
+
+	msg := make(OkMessage, 0, len(onMessage)+1+len(nonce))
+	msg = append(msg, onMessage...)
+	msg = append(msg, byte(status))
+	msg = append(msg, nonce...)
+	return newOkPacket(msg)
+}
+
+// Nonce returns the connection nonce the Ok echoes, nil if none.
+func (o OkMessage) Nonce() []byte {
+	nonce, _, _ := o.split()
+	return nonce
+}
+
+// VerifyOk checks that ok answers hs: it must echo the connection nonce of
+// hs, or carry none when hs had none. It fails with ErrorNonceMismatch
+// otherwise.
+func VerifyOk(hs HandshakeMessage, ok OkMessage) error {
+	if !bytes.Equal(hs.ConnectionNonce(), ok.Nonce()) {
+		return ErrorNonceMismatch
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestVerifyOk(t *testing.T) {
+	secret := &secure.NetworkSecret{}
+	handshake := func(fields ...HandshakeField) HandshakeMessage {
+		pack, err := encodeDecode(t, NewHandshakePacket(make([]byte, sessionKeyLen), secret, fields...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pack.Data.Msg.(HandshakeMessage)
+	}
+	reply := func(hs HandshakeMessage, status OkStatus) OkMessage {
+		pack, err := encodeDecode(t, NewOkReply(hs, status))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pack.Data.Msg.(OkMessage)
+	}
+
+	hs := handshake(ConnectionNonceField())
+	if len(hs.ConnectionNonce()) != connectionNonceLen {
+		t.Fatalf("expected a %d byte nonce, got %x", connectionNonceLen, hs.ConnectionNonce())
+	}
+	ok := reply(hs, OkAccepted)
+	if err := VerifyOk(hs, ok); err != nil {
+		t.Fatal(err)
+	}
+	if !ok.Accepted() {
+		t.Fatalf("expected accepted, got %s", ok.Status())
+	}
+	if rejected := reply(hs, OkRejectedBusy); VerifyOk(hs, rejected) != nil || rejected.Status() != OkRejectedBusy {
+		t.Fatalf("unexpected reject %x", []byte(rejected))
+	}
+
+	// the Ok of another connection
+	other := handshake(ConnectionNonceField())
+	if err := VerifyOk(other, ok); err != ErrorNonceMismatch {
+		t.Fatalf("expected %v, got %v", ErrorNonceMismatch, err)
+	}
+	if err := VerifyOk(hs, reply(other, OkAccepted)); err != ErrorNonceMismatch {
+		t.Fatalf("expected %v, got %v", ErrorNonceMismatch, err)
+	}
+
+	// peers predating nonces exchange the plain messages
+	plain := handshake()
+	if err := VerifyOk(plain, reply(plain, OkAccepted)); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyOk(hs, reply(plain, OkAccepted)); err != ErrorNonceMismatch {
+		t.Fatalf("expected %v, got %v", ErrorNonceMismatch, err)
+	}
+}
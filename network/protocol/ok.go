@@ -1,22 +1,76 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+)
+
+const (
+	OkAccepted OkStatus = iota
+	OkRejectedVersion
+	OkRejectedAuth
+	OkRejectedBusy
+	OkRejectedSelf
 )
 
 var (
 	onMessage = []byte{'O', 'K'}
+
+	ErrorRejected = errors.New("rejected by peer")
 )
 
 type (
+	// OkMessage answers a handshake. The plain "OK" accepts it; a trailing
+	// status byte, when present and non-zero, rejects it with a reason. The
+	// status byte is followed by the connection nonce of the handshake when
+	// it carried one, see NewOkReply, then by the address assigned to the
+	// peer when the reply carries one, see NewOkAssignment: a prefix length
+	// and an IPv4 or IPv6 address. The lengths of the two tell them apart.
 	OkMessage []byte
+
+	OkStatus uint8
 )
 
 func NewOkMessage() *Packet {
+	return newOkPacket(OkMessage(onMessage))
+}
+
+// NewRejectMessage builds the reply refusing a handshake for the given
+// reason. Peers that predate the status byte still see an Ok packet but fail
+// its validation, so they drop the connection as before.
+func NewRejectMessage(status OkStatus) *Packet {
+	return newOkPacket(OkMessage(append(onMessage[:len(onMessage):len(onMessage)], byte(status))))
+}
+
+// NewOkAssignment builds the reply accepting hs, echoing its connection
+// nonce, that assigns the peer the address of assigned within the mesh
+// subnet of assigned, e.g. 10.7.0.5/24. The peer configures its interface
+// and routes from it. A nil assigned.IP sends no assignment.
+func NewOkAssignment(hs HandshakeMessage, assigned net.IPNet) *Packet {
+	reply := NewOkReply(hs, OkAccepted)
+	if assigned.IP == nil {
+		return reply
+	}
+	msg := reply.Data.Msg.(OkMessage)
+	if len(msg) == len(onMessage) {
+		msg = append(msg[:len(msg):len(msg)], byte(OkAccepted))
+	}
+	ip := assigned.IP.To4()
+	if ip == nil {
+		ip = assigned.IP.To16()
+	}
+	ones, _ := assigned.Mask.Size()
+	msg = append(append(msg, byte(ones)), ip...)
+	return newOkPacket(msg)
+}
+
+func newOkPacket(msg OkMessage) *Packet {
 	body := Body{
 		Type: TypeOk,
-		Msg:  OkMessage(onMessage),
+		Msg:  msg,
 	}
 	return &Packet{
 		Head: Header{
@@ -36,6 +90,89 @@ func (o OkMessage) WriteTo(w io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// Status returns the status byte, OkAccepted for the plain "OK".
+func (o OkMessage) Status() OkStatus {
+	if len(o) > len(onMessage) {
+		return OkStatus(o[len(onMessage)])
+	}
+	return OkAccepted
+}
+
+func (o OkMessage) Accepted() bool {
+	return o.Status() == OkAccepted
+}
+
+// Assignment returns the address assigned by the peer with its subnet
+// mask, false if the reply carries none.
+func (o OkMessage) Assignment() (net.IPNet, bool) {
+	_, assignment, ok := o.split()
+	if !ok || assignment == nil {
+		return net.IPNet{}, false
+	}
+	ip := net.IP(assignment[1:])
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(int(assignment[0]), len(ip)*8)}, true
+}
+
+// split returns the nonce and the assignment following the status byte,
+// each nil when absent, and false when the trailing bytes are neither.
+func (o OkMessage) split() (nonce, assignment []byte, ok bool) {
+	if len(o) <= len(onMessage)+1 {
+		return nil, nil, true
+	}
+	rest := o[len(onMessage)+1:]
+	if len(rest) >= connectionNonceLen && len(rest) != 1+net.IPv6len {
+		nonce, rest = rest[:connectionNonceLen], rest[connectionNonceLen:]
+	}
+	switch len(rest) {
+	case 0:
+	case 1 + net.IPv4len, 1 + net.IPv6len:
+		assignment = rest
+	default:
+		return nil, nil, false
+	}
+	return nonce, assignment, true
+}
+
+func (s OkStatus) String() string {
+	switch s {
+	case OkAccepted:
+		return "accepted"
+	case OkRejectedVersion:
+		return "unsupported version"
+	case OkRejectedAuth:
+		return "authentication failed"
+	case OkRejectedBusy:
+		return "busy"
+	case OkRejectedSelf:
+		return "self connection"
+	}
+	return fmt.Sprintf("status %d", uint8(s))
+}
+
+func decodeOk(data []byte) (Message, error) {
+	return OkMessage(data), nil
+}
+
+func validateOk(msg Message) error {
+	ok := msg.(OkMessage)
+	if vectorPrefixed(ok, onMessage) {
+		return fmt.Errorf("%w before ok message", ErrorUnexpectedVector)
+	}
+	_, assignment, valid := ok.split()
+	if !valid || !bytes.HasPrefix(ok, onMessage) {
+		return fmt.Errorf("unexpected ok message %q", msg)
+	}
+	if assignment != nil {
+		if !ok.Accepted() {
+			return fmt.Errorf("address assigned by a %s reply", ok.Status())
+		}
+		if bits := 8 * (len(assignment) - 1); int(assignment[0]) > bits {
+			return fmt.Errorf("prefix length %d of a %d bit address", assignment[0], bits)
+		}
+	}
+	return nil
+}
+
 func ReadDecodeOk(r io.Reader) (OkMessage, error) {
 	logger.Debug("reading ok message...")
 
@@ -50,7 +187,11 @@ func ReadDecodeOk(r io.Reader) (OkMessage, error) {
 	}
 
 	logger.Debug("message, %v", okPack.Data.Msg)
-	return okPack.Data.Msg.(OkMessage), nil
+	ok := okPack.Data.Msg.(OkMessage)
+	if !ok.Accepted() {
+		return ok, fmt.Errorf("%w: %s", ErrorRejected, ok.Status())
+	}
+	return ok, nil
 }
 
 func WriteEncodeOk(w io.Writer) (err error) {
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestOkAccepted(t *testing.T) {
+	pack, err := encodeDecode(t, NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := pack.Data.Msg.(OkMessage)
+	if !ok.Accepted() || ok.Status() != OkAccepted {
+		t.Fatalf("expected accepted, got %s", ok.Status())
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEncodeOk(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDecodeOk(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOkRejected(t *testing.T) {
+	pack, err := encodeDecode(t, NewRejectMessage(OkRejectedAuth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := pack.Data.Msg.(OkMessage)
+	if ok.Accepted() || ok.Status() != OkRejectedAuth {
+		t.Fatalf("expected %s, got %s", OkRejectedAuth, ok.Status())
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeAndWrite(&buf, NewRejectMessage(OkRejectedVersion)); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = ReadDecodeOk(&buf)
+	if !errors.Is(err, ErrorRejected) {
+		t.Fatalf("expected %v, got %v", ErrorRejected, err)
+	}
+	if ok.Status() != OkRejectedVersion {
+		t.Fatalf("expected %s, got %s", OkRejectedVersion, ok.Status())
+	}
+}
+
+func TestOkAssignment(t *testing.T) {
+	secret := &secure.NetworkSecret{}
+	for _, cidr := range []string{"10.7.0.5/24", "fd00:7::5/64"} {
+		ip, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assigned := net.IPNet{IP: ip, Mask: subnet.Mask}
+		for _, fields := range [][]HandshakeField{nil, {ConnectionNonceField()}} {
+			pack, err := encodeDecode(t, NewHandshakePacket(make([]byte, sessionKeyLen), secret, fields...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hs := pack.Data.Msg.(HandshakeMessage)
+			pack, err = encodeDecode(t, NewOkAssignment(hs, assigned))
+			if err != nil {
+				t.Fatalf("%s: %v", cidr, err)
+			}
+			ok := pack.Data.Msg.(OkMessage)
+			if err := VerifyOk(hs, ok); err != nil {
+				t.Fatalf("%s: %v", cidr, err)
+			}
+			got, present := ok.Assignment()
+			if !present || got.String() != cidr {
+				t.Fatalf("expected assignment %s, got %s (%t)", cidr, got.String(), present)
+			}
+		}
+	}
+
+	pack, err := encodeDecode(t, NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, present := pack.Data.Msg.(OkMessage).Assignment(); present {
+		t.Fatal("unexpected assignment in a plain ok")
+	}
+	if err := validateOk(OkMessage("OK\x00\x21\x0a\x07\x00\x05")); err == nil {
+		t.Fatal("expected a /33 IPv4 assignment to be rejected")
+	}
+	if err := validateOk(OkMessage("OK\x02\x18\x0a\x07\x00\x05")); err == nil {
+		t.Fatal("expected an assignment in a reject to be rejected")
+	}
+}
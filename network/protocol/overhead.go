@@ -0,0 +1,53 @@
+package protocol
+
+// Options describes the wire layout a packet is encoded with.
+type Options struct {
+	Version  uint8
+	Type     uint8
+	Checksum ChecksumAlgorithm
+	Cipher   CipherSuite
+
+	// VectorOffset moves the vector into the message, for framings that
+	// expect it elsewhere: it is the number of message bytes preceding it,
+	// or when negative counted from the end of the message so that
+	// -VectorLen places it after the message. Zero is the default layout.
+	// Only EncodeWithOptions and DecodeWithOptions honor it.
+	VectorOffset int
+	// VectorLen is the size of the vector. It doubles as the AES-GCM nonce
+	// and must be zero, meaning the default, or 16.
+	VectorLen int
+}
+
+// Overhead returns the number of bytes a packet encoded with opts adds on top
+// of its message: header, type byte, vector, authentication tag and checksum
+// trailer. The largest tunnel MTU is the path MTU minus this value.
+func Overhead(opts Options) int {
+	head := Header{Version: opts.Version}
+	if opts.Version < FlagsVersion {
+		opts.Checksum = ChecksumNone
+	}
+
+	overhead := int(head.Len()) + 1 + int(opts.Checksum.Len())
+	if hasVector(opts.Type) {
+		overhead += bodyVectorLen + opts.Cipher.TagLen()
+	}
+	return overhead
+}
+
+// MaxPayload returns the largest message a packet encoded with opts can
+// carry: the largest Header.Length less the part of Overhead that follows
+// the header. The optional fields a packet may carry on top, a priority,
+// route, send time or message id, take their size off it too.
+func MaxPayload(opts Options) int {
+	return maxBodyLen - (Overhead(opts) - int(Header{Version: opts.Version}.Len()))
+}
+
+// EncryptedOverhead returns the overhead of a Transfer packet, the carrier of
+// tunnelled traffic, as written by an Encoder sealing with suite.
+func EncryptedOverhead(suite CipherSuite) int {
+	return Overhead(Options{
+		Version: CurrentVersion,
+		Type:    TypeTransfer,
+		Cipher:  suite,
+	})
+}
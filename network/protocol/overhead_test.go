@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestOverheadMatchesEncode(t *testing.T) {
+	payload := make([]byte, 1000)
+	for _, pack := range []*Packet{
+		NewTransferMessage(payload),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		NewOkMessage(),
+	} {
+		for _, version := range []uint8{CurrentVersion, FlagsVersion} {
+			for _, alg := range []ChecksumAlgorithm{ChecksumNone, ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash} {
+				pack.Head.Version = version
+				pack.Head.Flags = 0
+				if alg != ChecksumNone {
+					pack.SetChecksum(alg)
+				}
+
+				data, err := Encode(pack)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				opts := Options{
+					Version:  pack.Head.Version,
+					Type:     pack.Data.Type,
+					Checksum: alg,
+				}
+				measured := len(data) - int(pack.Data.Msg.Len())
+				if overhead := Overhead(opts); overhead != measured {
+					t.Errorf("%+v: expected overhead %d, got %d", opts, measured, overhead)
+				}
+			}
+		}
+	}
+}
+
+func TestOverheadIgnoresChecksumWithoutFlags(t *testing.T) {
+	opts := Options{Version: CurrentVersion, Type: TypeTransfer, Checksum: ChecksumCRC32}
+	if overhead := Overhead(opts); overhead != 3+1+bodyVectorLen {
+		t.Fatalf("unexpected overhead %d", overhead)
+	}
+}
+
+func TestEncryptedOverheadMatchesEncoder(t *testing.T) {
+	iSend, _, _, _ := testDirectionKeys()
+	for _, suite := range []struct {
+		cipher CipherSuite
+		key    []byte
+	}{{CipherNone, nil}, {CipherAES256GCM, iSend}} {
+		for _, size := range []int{1, 1000, 1400} {
+			var stream bytes.Buffer
+			if err := NewEncoder(&stream, suite.key).Encode(NewTransferMessage(make([]byte, size))); err != nil {
+				t.Fatal(err)
+			}
+			if overhead := EncryptedOverhead(suite.cipher); overhead != stream.Len()-size {
+				t.Errorf("%s: expected overhead %d, got %d", suite.cipher, stream.Len()-size, overhead)
+			}
+		}
+	}
+	if overhead := EncryptedOverhead(CipherAES256GCM); overhead != 36 {
+		t.Fatalf("unexpected aes-256-gcm overhead %d", overhead)
+	}
+}
+
+func TestMaxPayloadBoundary(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	for _, tt := range []struct {
+		name     string
+		key      []byte
+		opts     Options
+		checksum ChecksumAlgorithm
+		want     int
+	}{
+		{"plain", nil, Options{Version: CurrentVersion, Type: TypeTransfer}, ChecksumNone, 65518},
+		{"sealed", iSend, Options{Version: CurrentVersion, Type: TypeTransfer, Cipher: CipherAES256GCM}, ChecksumNone, 65502},
+		{"checksum", nil, Options{Version: FlagsVersion, Type: TypeTransfer, Checksum: ChecksumCRC32}, ChecksumCRC32, 65514},
+		{"no vector", nil, Options{Version: CurrentVersion, Type: TypeTransferBatch}, ChecksumNone, 65534},
+	} {
+		maxLen := MaxPayload(tt.opts)
+		if maxLen != tt.want {
+			t.Fatalf("%s: max payload %d, expected %d", tt.name, maxLen, tt.want)
+		}
+
+		build := func(n int) *Packet {
+			if tt.opts.Type == TypeTransferBatch {
+				// a single frame of n minus the count and length prefixes
+				pack, err := NewTransferBatchMessage([][]byte{make([]byte, n-2*batchLenSize)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return pack
+			}
+			pack := NewTransferMessage(bytes.Repeat([]byte{7}, n))
+			if tt.checksum != ChecksumNone {
+				pack.SetChecksum(tt.checksum)
+			}
+			return pack
+		}
+
+		var stream bytes.Buffer
+		if err := NewEncoder(&stream, tt.key).Encode(build(maxLen)); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if length := binary.BigEndian.Uint16(stream.Bytes()); length != 1<<16-1 {
+			t.Fatalf("%s: header length %d at the boundary", tt.name, length)
+		}
+		receiveKey := []byte(nil)
+		if tt.key != nil {
+			receiveKey = rRecv
+		}
+		pack, err := NewDecoder(&stream, receiveKey).Decode()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if int(pack.Data.Msg.Len()) != maxLen {
+			t.Fatalf("%s: decoded %d bytes, expected %d", tt.name, pack.Data.Msg.Len(), maxLen)
+		}
+
+		if tt.opts.Type == TypeTransferBatch {
+			continue // NewTransferBatchMessage refuses the larger batch itself
+		}
+		for _, n := range []int{maxLen + 1, 1<<16 + 100} {
+			if err = NewEncoder(io.Discard, tt.key).Encode(build(n)); !errors.Is(err, ErrorPacketTooLarge) {
+				t.Fatalf("%s: %d bytes, expected %v, got %v", tt.name, n, ErrorPacketTooLarge, err)
+			}
+		}
+	}
+}
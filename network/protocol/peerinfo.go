@@ -1,13 +1,34 @@
 package protocol
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"slices"
+)
+
+// peerEntryLen is the wire size of a PeerEntry: private IPv4, public IPv4
+// and port.
+const peerEntryLen = 2*net.IPv4len + 2
+
+var (
+	ErrorTooManyPeers = errors.New("too many peer entries")
 )
 
 type (
+	// PeerInfoMessage carries the private IP of the sender, optionally
+	// followed by a uint16 count and that many peer entries.
 	PeerInfoMessage []byte
+
+	// PeerEntry describes a peer known to the sender.
+	PeerEntry struct {
+		PrivateIP net.IP
+		PublicIP  net.IP
+		Port      uint16
+	}
 )
 
 func NewPeerInfoMessage(privateIP net.IP) *Packet {
@@ -24,6 +45,27 @@ func NewPeerInfoMessage(privateIP net.IP) *Packet {
 	}
 }
 
+// NewPeerTableMessage is NewPeerInfoMessage followed by a table of peers.
+// The table is a set: entries are written in canonical order, see
+// appendPeerTable.
+func NewPeerTableMessage(privateIP net.IP, entries []PeerEntry) *Packet {
+	msg := make(PeerInfoMessage, 0, net.IPv4len+2+len(entries)*peerEntryLen)
+	msg = append(msg, privateIP.To4()...)
+	msg = appendPeerTable(msg, entries)
+
+	body := Body{
+		Type: TypePeerInfo,
+		Msg:  msg,
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
 func (m PeerInfoMessage) Len() uint16 {
 	return uint16(len(m))
 }
@@ -34,7 +76,111 @@ func (m PeerInfoMessage) WriteTo(w io.Writer) (int64, error) {
 }
 
 func (m PeerInfoMessage) PrivateIP() net.IP {
-	return net.IP(m)
+	return net.IP(m[:net.IPv4len])
+}
+
+// EntryCount returns the number of peers the table announces, without
+// parsing it.
+func (m PeerInfoMessage) EntryCount() int {
+	if len(m) < net.IPv4len+2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(m[net.IPv4len:]))
+}
+
+// Entries returns the peer table, empty when the message carries none.
+func (m PeerInfoMessage) Entries() ([]PeerEntry, error) {
+	if len(m) == net.IPv4len {
+		return nil, nil
+	}
+	return parsePeerEntries(m[net.IPv4len:])
+}
+
+func (e PeerEntry) appendTo(b []byte) []byte {
+	b = append(b, e.PrivateIP.To4()...)
+	b = append(b, e.PublicIP.To4()...)
+	return binary.BigEndian.AppendUint16(b, e.Port)
+}
+
+// appendPeerTable appends a uint16 count followed by entries sorted by their
+// wire form. The same peers thus encode to the same bytes whatever order
+// they were collected in, e.g. from a map, and signatures over the table
+// can be reproduced.
+func appendPeerTable(dst []byte, entries []PeerEntry) []byte {
+	rows := make([][peerEntryLen]byte, len(entries))
+	for i, entry := range entries {
+		entry.appendTo(rows[i][:0])
+	}
+	slices.SortFunc(rows, func(a, b [peerEntryLen]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(entries)))
+	for i := range rows {
+		dst = append(dst, rows[i][:]...)
+	}
+	return dst
+}
+
+// parsePeerEntries parses a uint16 count followed by that many entries.
+func parsePeerEntries(data []byte) ([]PeerEntry, error) {
+	count, err := checkPeerTable(data)
+	if err != nil {
+		return nil, err
+	}
+	data = data[2:]
+
+	entries := make([]PeerEntry, count)
+	for i := range entries {
+		entries[i] = PeerEntry{
+			PrivateIP: net.IP(data[0:4:4]),
+			PublicIP:  net.IP(data[4:8:8]),
+			Port:      binary.BigEndian.Uint16(data[8:]),
+		}
+		data = data[peerEntryLen:]
+	}
+	return entries, nil
+}
+
+// checkPeerTable checks the size of a peer table against its count without
+// allocating the entries.
+func checkPeerTable(data []byte) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("peer table without count")
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	if len(data)-2 != count*peerEntryLen {
+		return 0, fmt.Errorf("peer table of %d entries has %d bytes", count, len(data)-2)
+	}
+	return count, nil
+}
+
+func decodePeerInfo(data []byte) (Message, error) {
+	if len(data) < net.IPv4len+2 {
+		return PeerInfoMessage(data), nil
+	}
+	// the table announces its own size, the rest of the body is not ours
+	tableLen := net.IPv4len + 2 + int(binary.BigEndian.Uint16(data[net.IPv4len:]))*peerEntryLen
+	if tableLen < len(data) {
+		return PeerInfoMessage(data[:tableLen]), nil
+	}
+	return PeerInfoMessage(data), nil
+}
+
+func validatePeerInfo(msg Message) error {
+	m := msg.(PeerInfoMessage)
+	if len(m) < net.IPv4len {
+		return fmt.Errorf("peer info must carry an IPv4 address, got %d bytes", len(m))
+	}
+	if m.PrivateIP().IsUnspecified() {
+		return fmt.Errorf("peer info carries unspecified address")
+	}
+	if len(m) > net.IPv4len {
+		if _, err := checkPeerTable(m[net.IPv4len:]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func ReadDecodePeerInfo(r io.Reader) (PeerInfoMessage, error) {
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPeerInfoLengthMismatch(t *testing.T) {
+	data, err := Encode(NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// declare five more bytes than the one entry table holds
+	data = append(data, 1, 2, 3, 4, 5)
+	binary.BigEndian.PutUint16(data, binary.BigEndian.Uint16(data)+5)
+
+	_, err = Decode(bytes.NewReader(data))
+	if !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorLengthMismatch, err)
+	}
+	if ErrorCodeFor(err) != ErrorCodeMalformed {
+		t.Fatalf("unexpected error code %d", ErrorCodeFor(err))
+	}
+}
+
+func TestPeerInfoExactLength(t *testing.T) {
+	for _, pack := range []*Packet{
+		NewPeerInfoMessage(net.IPv4(10, 0, 0, 1)),
+		NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(3)),
+	} {
+		if _, err := encodeDecode(t, pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecoderMaxPeerEntries(t *testing.T) {
+	const max = 8
+	for _, tc := range []struct {
+		entries int
+		err     error
+	}{
+		{0, nil},
+		{max, nil},
+		{max + 1, ErrorTooManyPeers},
+	} {
+		var stream bytes.Buffer
+		if err := NewEncoder(&stream, nil).Encode(NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(tc.entries))); err != nil {
+			t.Fatal(err)
+		}
+		dec := NewDecoder(&stream, nil)
+		dec.MaxPeerEntries = max
+
+		pack, err := dec.Decode()
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%d entries: expected %v, got %v", tc.entries, tc.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		entries, err := pack.Data.Msg.(PeerInfoMessage).Entries()
+		if err != nil || len(entries) != tc.entries {
+			t.Fatalf("%d entries: got %d, %v", tc.entries, len(entries), err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrorPeerBusy = errors.New("too many concurrent decodes for peer")
+)
+
+// PeerDecodeLimit bounds the decodes in flight for each remote peer, so one
+// noisy peer cannot take up every CPU opening its packets. A decode beyond
+// Limit waits for a slot when Block is set and fails with ErrorPeerBusy
+// otherwise. A Limit of 1 serializes the decodes of a peer. Only peers with
+// decodes in flight are tracked. The zero value is ready to use, with a
+// Limit of 1. It is safe for concurrent use.
+type PeerDecodeLimit struct {
+	Limit int
+	Block bool
+
+	mu       sync.Mutex
+	released sync.Cond
+	inFlight map[string]int
+}
+
+func NewPeerDecodeLimit(limit int, block bool) *PeerDecodeLimit {
+	if limit < 1 {
+		limit = 1
+	}
+	return &PeerDecodeLimit{
+		Limit: limit,
+		Block: block,
+	}
+}
+
+// Acquire takes a decode slot of peer, returning the function releasing
+// it. Calling release more than once gives back the slot only once.
+func (l *PeerDecodeLimit) Acquire(peer string) (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight == nil {
+		l.inFlight = make(map[string]int)
+		l.released.L = &l.mu
+	}
+	limit := max(l.Limit, 1)
+	for l.inFlight[peer] >= limit {
+		if !l.Block {
+			return nil, fmt.Errorf("%w: %s has %d in flight", ErrorPeerBusy, peer, l.inFlight[peer])
+		}
+		l.released.Wait()
+	}
+	l.inFlight[peer]++
+	var once sync.Once
+	return func() { once.Do(func() { l.release(peer) }) }, nil
+}
+
+// release gives back a decode slot of peer, forgetting the peer once none
+// is taken.
+func (l *PeerDecodeLimit) release(peer string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n := l.inFlight[peer] - 1; n > 0 {
+		l.inFlight[peer] = n
+	} else {
+		delete(l.inFlight, peer)
+	}
+	l.released.Broadcast()
+}
+
+// Do runs decode, any of the decode functions of this package, within a
+// decode slot of peer.
+func (l *PeerDecodeLimit) Do(peer string, decode func() (*Packet, error)) (*Packet, error) {
+	release, err := l.Acquire(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return decode()
+}
+
+// Decode decodes data, a datagram received from peer, opening sealed
+// messages with key when it is set. Bytes in data after the packet fail
+// with ErrorLengthMismatch.
+func (l *PeerDecodeLimit) Decode(peer string, data, key []byte) (*Packet, error) {
+	return l.Do(peer, func() (*Packet, error) {
+		return decodeDatagram(data, decodeOptions{key: key})
+	})
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeerDecodeLimit(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	data, err := encode(NewTransferMessage([]byte("payload")), iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []bool{true, false} {
+		const limit, workers = 2, 16
+		l := NewPeerDecodeLimit(limit, block)
+		var (
+			inFlight, peak, busy atomic.Int32
+			wg                   sync.WaitGroup
+		)
+		// all workers contend for the slots of one peer while its first
+		// decodes are held up
+		gate := make(chan struct{})
+		wg.Add(workers)
+		for range workers {
+			go func() {
+				defer wg.Done()
+				_, err := l.Do("noisy", func() (*Packet, error) {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+					}
+					<-gate
+					return decode(bytes.NewReader(data), decodeOptions{key: rRecv})
+				})
+				if errors.Is(err, ErrorPeerBusy) {
+					busy.Add(1)
+				} else if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+
+		// the slots are taken, and without blocking every other decode
+		// failed
+		for inFlight.Load() < limit || !block && busy.Load() < workers-limit {
+			time.Sleep(time.Millisecond)
+		}
+		// another peer is not held up by the noisy one
+		if _, err := l.Decode("quiet", data, rRecv); err != nil {
+			t.Fatal(err)
+		}
+		close(gate)
+		wg.Wait()
+
+		if peak.Load() != limit {
+			t.Fatalf("block %t: %d decodes in flight, limit %d", block, peak.Load(), limit)
+		}
+		if block && busy.Load() != 0 {
+			t.Fatalf("expected blocking decodes to wait, %d failed busy", busy.Load())
+		}
+		if !block && busy.Load() != workers-limit {
+			t.Fatalf("expected %d decodes to fail busy, got %d", workers-limit, busy.Load())
+		}
+		if len(l.inFlight) != 0 {
+			t.Fatalf("block %t: idle peers still tracked: %v", block, l.inFlight)
+		}
+	}
+}
+
+func TestPeerDecodeLimitZeroValue(t *testing.T) {
+	var l PeerDecodeLimit
+	release, err := l.Acquire("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire("peer"); !errors.Is(err, ErrorPeerBusy) {
+		t.Fatalf("expected %v, got %v", ErrorPeerBusy, err)
+	}
+	release()
+	if _, err := l.Acquire("peer"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeerDecodeLimitReleaseTwice(t *testing.T) {
+	l := NewPeerDecodeLimit(1, false)
+	release, err := l.Acquire("peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err = l.Acquire("peer"); err != nil {
+		t.Fatal(err)
+	}
+	// a second call must not give back the slot taken since
+	release()
+	if _, err = l.Acquire("peer"); !errors.Is(err, ErrorPeerBusy) {
+		t.Fatalf("expected %v, got %v", ErrorPeerBusy, err)
+	}
+}
+
+func TestPeerDecodeLimitTrailingBytes(t *testing.T) {
+	data, err := Encode(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewPeerDecodeLimit(1, false)
+	if _, err = l.Decode("peer", append(data, 0), nil); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorLengthMismatch, err)
+	}
+}
@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+type decodeScratch struct {
+	r      bytes.Reader
+	head   [maxHeaderLen + 1]byte
+	vector [bodyVectorLen]byte
+}
+
+var (
+	packetPool = sync.Pool{
+		New: func() any {
+			return &Packet{Data: Body{Vector: make([]byte, 0, bodyVectorLen)}}
+		},
+	}
+	scratchPool = sync.Pool{
+		New: func() any {
+			return new(decodeScratch)
+		},
+	}
+)
+
+// AcquirePacket returns an empty packet from a pool, to decode into with
+// DecodeInto and hand back with ReleasePacket.
+func AcquirePacket() *Packet {
+	return packetPool.Get().(*Packet)
+}
+
+// ReleasePacket resets p and returns it to the pool. The vector storage of
+// p is reused by the next DecodeInto: a caller keeping the vector, the
+// message or anything else referenced by p must copy it out before the
+// release, and must not use p afterwards.
+func ReleasePacket(p *Packet) {
+	if p == nil {
+		return
+	}
+	p.reset()
+	packetPool.Put(p)
+}
+
+// reset clears p, keeping the storage of its vector.
+func (p *Packet) reset() {
+	vector := p.Data.Vector[:0]
+	*p = Packet{}
+	p.Data.Vector = vector
+}
+
+// DecodeInto decodes the single packet in data into p, typically one from
+// AcquirePacket, opening it with key when set. Every field of p is reset
+// first. Bytes in data after the packet fail with ErrorLengthMismatch.
+// Neither the packet nor the reader over data is allocated, leaving
+// plain Transfer packets, the bulk of the traffic, with their message. The
+// reader goes back to a pool on return, so the message of a type registered
+// with RegisterStreamType is read in full and streamed from a copy.
+func DecodeInto(p *Packet, data []byte, key []byte) error {
+	p.reset()
+
+	scratch := scratchPool.Get().(*decodeScratch)
+	defer scratchPool.Put(scratch)
+	scratch.r.Reset(data)
+	defer scratch.r.Reset(nil)
+
+	err := decodeInto(&scratch.r, p, key, scratch.head[:])
+	if err == nil {
+		err = checkDatagram(p, len(data))
+	}
+	reportDecoded(p, err)
+	return err
+}
+
+func decodeInto(r *bytes.Reader, p *Packet, key []byte, head []byte) error {
+	if err := readHeader(r, p, head); err != nil {
+		return err
+	}
+	if p.Data.Type == TypeTransfer && p.Head.Flags == 0 && key == nil && transferFastPath.Load() {
+		vector := p.Data.Vector
+		if cap(vector) < bodyVectorLen {
+			vector = make([]byte, bodyVectorLen)
+		}
+		_, err := decodeTransferFast(r, p, vector[:bodyVectorLen], false)
+		return err
+	}
+	_, err := decodeBody(r, p, decodeOptions{key: key, copyStream: true})
+	return err
+}
+
+// DecodeTransferInto decodes the single Transfer packet in data, opening it
+// with key when set, and copies its message into dst, reusing the storage
+// of dst. A plain Transfer is decoded without any allocation once dst has
+// grown to the packet size: no Packet is built and no Message is boxed. Any
+// other packet fails with ErrorNotTransfer, and bytes after the packet with
+// ErrorLengthMismatch. The header and vector are not returned, use
+// DecodeInto for those.
+func DecodeTransferInto(data []byte, key []byte, dst *TransferMessage) error {
+	scratch := scratchPool.Get().(*decodeScratch)
+	defer scratchPool.Put(scratch)
+	scratch.r.Reset(data)
+	defer scratch.r.Reset(nil)
+
+	err := decodeTransferInto(scratch, key, dst)
+	if err == nil && scratch.r.Len() != 0 {
+		err = trailingError(TypeTransfer, scratch.r.Len())
+	}
+	if m := currentMetrics(); m != nil {
+		if err != nil {
+			reportDecodedTo(m, nil, err)
+		} else {
+			// reportDecodedTo would need a Packet with a boxed message
+			m.PacketDecoded(TypeTransfer, len(data))
+			if pm, ok := m.(PayloadMetrics); ok {
+				pm.PayloadDecoded(TypeTransfer, len(*dst))
+			}
+		}
+	}
+	return err
+}
+
+func decodeTransferInto(scratch *decodeScratch, key []byte, dst *TransferMessage) error {
+	var p Packet
+	if err := readHeader(&scratch.r, &p, scratch.head[:]); err != nil {
+		return err
+	}
+	if p.Data.Type != TypeTransfer {
+		return &DecodeError{Type: p.Data.Type, Err: ErrorNotTransfer}
+	}
+	if p.Head.Flags != 0 || key != nil || !transferFastPath.Load() {
+		return decodeTransferCopy(&scratch.r, p.Head, key, dst)
+	}
+
+	remainLength := int(p.Head.Length) - 1 - bodyVectorLen
+	if remainLength < 0 {
+		return &DecodeError{Type: TypeTransfer, Err: ErrorInvalidReadSize}
+	}
+	if n, err := io.ReadFull(&scratch.r, scratch.vector[:]); err != nil {
+		return shortReadError(TypeTransfer, ErrorUnableToReadVector, bodyVectorLen, n, err)
+	}
+	msg := slices.Grow((*dst)[:0], remainLength)[:remainLength]
+	if n, err := io.ReadFull(&scratch.r, msg); err != nil {
+		return shortReadError(TypeTransfer, ErrorUnableToReadMessage, remainLength, n, err)
+	}
+	if err := validateTransferLen(len(msg)); err != nil {
+		return &DecodeError{Type: TypeTransfer, Err: fmt.Errorf("%w: %w", ErrorInvalidPayload, err)}
+	}
+	*dst = msg
+	return nil
+}
+
+// decodeTransferCopy is DecodeTransferInto for the transfers the fast path
+// does not handle, taking the generic path and copying the message out.
+func decodeTransferCopy(r io.Reader, head Header, key []byte, dst *TransferMessage) error {
+	pack := &Packet{Head: head, Data: Body{Type: TypeTransfer}}
+	if _, err := decodeBody(r, pack, decodeOptions{key: key}); err != nil {
+		return err
+	}
+	msg, ok := pack.Data.Msg.(TransferMessage)
+	if !ok {
+		// a decoder registered over the built-in one
+		return &DecodeError{Type: TypeTransfer, Err: ErrorNotTransfer}
+	}
+	*dst = append((*dst)[:0], msg...)
+	return nil
+}
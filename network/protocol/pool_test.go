@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeIntoResetsPacket(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	transfer := NewTransferMessage([]byte("tunnelled ip packet"))
+	transfer.SetPriority(3)
+	sealed, err := encode(transfer, iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := AcquirePacket()
+	if err = DecodeInto(p, sealed, rRecv); err != nil {
+		t.Fatal(err)
+	}
+	if p.Data.Priority != 3 || len(p.Data.Vector) != bodyVectorLen || p.Head.Flags == 0 {
+		t.Fatalf("unexpected transfer %#v", p)
+	}
+	ReleasePacket(p)
+
+	p = AcquirePacket()
+	if err = DecodeInto(p, heartbeat, nil); err != nil {
+		t.Fatal(err)
+	}
+	want, err := Decode(bytes.NewReader(heartbeat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing of the transfer decoded before may leak into the heartbeat
+	if p.Head != want.Head || p.Data.Type != want.Data.Type || p.Data.Priority != 0 || len(p.Data.Vector) != 0 ||
+		p.Data.Raw != nil || !reflect.DeepEqual(p.Data.Msg, want.Data.Msg) {
+		t.Fatalf("expected %#v, got %#v", want, p)
+	}
+	ReleasePacket(p)
+}
+
+func TestDecodeIntoMatchesDecode(t *testing.T) {
+	data, err := Encode(NewTransferMessage(bytes.Repeat([]byte{9}, 1400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := AcquirePacket()
+	defer ReleasePacket(p)
+	for i := 0; i < 3; i++ {
+		if err = DecodeInto(p, data, nil); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := Decode(bytes.NewReader(data))
+		if !samePacket(t, p, want) {
+			t.Fatalf("decoded %#v, expected %#v", p, want)
+		}
+	}
+}
+
+func TestDecodeTransferInto(t *testing.T) {
+	iSend, iRecv, _, rRecv := testDirectionKeys()
+	payload := bytes.Repeat([]byte("tunnelled ip packet "), 50)
+	plain, err := Encode(NewTransferMessage(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	urgent := NewTransferMessage(payload)
+	urgent.SetPriority(3)
+	sealed, err := encode(urgent, iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dst TransferMessage
+	for _, tc := range []struct {
+		data []byte
+		key  []byte
+	}{{plain, nil}, {sealed, rRecv}, {plain, nil}} {
+		if err := DecodeTransferInto(tc.data, tc.key, &dst); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst, payload) {
+			t.Fatalf("decoded %q", dst)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { DecodeTransferInto(plain, nil, &dst) }); allocs != 0 {
+		t.Fatalf("plain transfer decoded with %v allocations", allocs)
+	}
+
+	heartbeat, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeTransferInto(heartbeat, nil, &dst); !errors.Is(err, ErrorNotTransfer) {
+		t.Fatalf("expected %v, got %v", ErrorNotTransfer, err)
+	}
+	if err := DecodeTransferInto(plain[:len(plain)-1], nil, &dst); !errors.Is(err, ErrorUnableToReadMessage) {
+		t.Fatalf("expected %v for a truncated transfer, got %v", ErrorUnableToReadMessage, err)
+	}
+	if err := DecodeTransferInto(sealed, iRecv, &dst); err == nil {
+		t.Fatal("transfer opened with the wrong key")
+	}
+	if err := DecodeTransferInto(append(plain, 0), nil, &dst); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v for trailing bytes, got %v", ErrorLengthMismatch, err)
+	}
+	if err := DecodeTransferInto(append(sealed, 0), rRecv, &dst); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v for trailing bytes, got %v", ErrorLengthMismatch, err)
+	}
+}
+
+// decodeCounts counts the decode events it receives as Metrics.
+type decodeCounts struct {
+	decoded, failed int
+}
+
+func (c *decodeCounts) PacketEncoded(uint8, int) {}
+func (c *decodeCounts) PacketDecoded(uint8, int) { c.decoded++ }
+func (c *decodeCounts) DecodeFailed(error)       { c.failed++ }
+
+func TestDecodeTransferIntoMetrics(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	plain, err := Encode(NewTransferMessage([]byte("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encode(NewTransferMessage([]byte("payload")), iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var counts decodeCounts
+	SetMetrics(&counts)
+	defer SetMetrics(nil)
+	var dst TransferMessage
+	DecodeTransferInto(plain, nil, &dst)
+	DecodeTransferInto(sealed, rRecv, &dst)
+	DecodeTransferInto(append(plain, 0), nil, &dst)
+	DecodeTransferInto(append(sealed, 0), rRecv, &dst)
+	DecodeTransferInto(plain[:len(plain)-1], nil, &dst)
+	if counts.decoded != 2 || counts.failed != 3 {
+		t.Fatalf("expected 2 decoded and 3 failed, got %+v", counts)
+	}
+}
+
+func BenchmarkDecodeTransfer(b *testing.B) {
+	data, err := Encode(NewTransferMessage(bytes.Repeat([]byte{9}, 1400)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Decode", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Decode(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("DecodeInto", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := AcquirePacket()
+			if err := DecodeInto(p, data, nil); err != nil {
+				b.Fatal(err)
+			}
+			ReleasePacket(p)
+		}
+	})
+	b.Run("DecodeTransferInto", func(b *testing.B) {
+		var dst TransferMessage
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := DecodeTransferInto(data, nil, &dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecodeIntoTrailingBytes(t *testing.T) {
+	data, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := AcquirePacket()
+	defer ReleasePacket(p)
+	if err = DecodeInto(p, append(data, 0), nil); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorLengthMismatch, err)
+	}
+}
+
+func TestDecodeIntoStreamType(t *testing.T) {
+	const typeStream uint8 = 209
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	data, err := Encode(newPacket(typeStream, RawMessage("streamed payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := AcquirePacket()
+	defer ReleasePacket(p)
+	if err = DecodeInto(p, data, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the pooled reader moves on to another datagram
+	other := AcquirePacket()
+	defer ReleasePacket(other)
+	heartbeat, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = DecodeInto(other, heartbeat, nil); err != nil {
+		t.Fatal(err)
+	}
+	clear(data)
+
+	msg, ok := AsMessage[*StreamMessage](p)
+	if !ok {
+		t.Fatalf("unexpected %#v", p.Data.Msg)
+	}
+	if payload, err := io.ReadAll(msg); err != nil || string(payload) != "streamed payload" {
+		t.Fatalf("unexpected payload %q, %v", payload, err)
+	}
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+)
+
+// FlagPriority marks a Transfer body carrying a priority byte right after
+// its type. Higher priorities are sent first.
+const FlagPriority uint8 = 1 << 3
+
+var (
+	ErrorInvalidPriority = errors.New("priority only allowed on transfer packets from flags version on")
+)
+
+type (
+	// PriorityQueue orders outgoing packets by Body.Priority, the highest
+	// first and packets of equal priority in the order they were pushed. It
+	// is safe for concurrent use.
+	PriorityQueue struct {
+		mu    sync.Mutex
+		items priorityItems
+		seq   uint64
+	}
+
+	priorityItem struct {
+		pack *Packet
+		seq  uint64
+	}
+	priorityItems []priorityItem
+)
+
+// SetPriority marks p, a Transfer packet, with priority, upgrading its
+// header to FlagsVersion when needed. Priority zero is the default and is
+// not put on the wire.
+func (p *Packet) SetPriority(priority uint8) {
+	p.Data.Priority = priority
+	if priority == 0 {
+		p.Head.Flags &^= FlagPriority
+	} else {
+		if p.Head.Version < FlagsVersion {
+			p.Head.Version = FlagsVersion
+		}
+		p.Head.Flags |= FlagPriority
+	}
+	p.Head.Length = p.Data.Len() + p.Head.Checksum().Len()
+}
+
+// checkPriority verifies that a priority is only carried where the format
+// allows it.
+func checkPriority(head Header, t uint8) error {
+	if head.Flags&FlagPriority != 0 && (head.Version < FlagsVersion || t != TypeTransfer) {
+		return ErrorInvalidPriority
+	}
+	return nil
+}
+
+func NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{}
+}
+
+func (q *PriorityQueue) Push(pack *Packet) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	heap.Push(&q.items, priorityItem{pack: pack, seq: q.seq})
+	q.seq++
+}
+
+// Pop removes and returns the packet to send next, nil when q is empty.
+func (q *PriorityQueue) Pop() *Packet {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.items).(priorityItem).pack
+}
+
+func (q *PriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (s priorityItems) Len() int {
+	return len(s)
+}
+
+func (s priorityItems) Less(i, j int) bool {
+	if pi, pj := s[i].pack.Data.Priority, s[j].pack.Data.Priority; pi != pj {
+		return pi > pj
+	}
+	return s[i].seq < s[j].seq
+}
+
+func (s priorityItems) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s *priorityItems) Push(x any) {
+	*s = append(*s, x.(priorityItem))
+}
+
+func (s *priorityItems) Pop() any {
+	old := *s
+	item := old[len(old)-1]
+	old[len(old)-1] = priorityItem{}
+	*s = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestTransferPriority(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	for _, priority := range []uint8{0, 1, 7, 255} {
+		for _, keyed := range []bool{false, true} {
+			pack := NewTransferMessage([]byte("voice frame"))
+			pack.SetPriority(priority)
+
+			sendKey, receiveKey := []byte(nil), []byte(nil)
+			if keyed {
+				sendKey, receiveKey = iSend, rRecv
+			}
+			var stream bytes.Buffer
+			if err := NewEncoder(&stream, sendKey).Encode(pack); err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewDecoder(&stream, receiveKey).Decode()
+			if err != nil {
+				t.Fatalf("priority %d: %v", priority, err)
+			}
+			if got.Data.Priority != priority || string(got.Data.Msg.(TransferMessage)) != "voice frame" {
+				t.Fatalf("expected priority %d, got %d with %q", priority, got.Data.Priority, got.Data.Msg)
+			}
+		}
+	}
+}
+
+func TestTransferPriorityChecksum(t *testing.T) {
+	pack := NewTransferMessage([]byte("voice frame"))
+	pack.SetPriority(4)
+	pack.SetChecksum(ChecksumCRC32C)
+
+	got, err := encodeDecode(t, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Data.Priority != 4 {
+		t.Fatalf("expected priority 4, got %d", got.Data.Priority)
+	}
+}
+
+func TestTransferPriorityAuthenticated(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	pack := NewTransferMessage([]byte("voice frame"))
+	pack.SetPriority(1)
+	data, err := encode(pack, iSend, nil, defaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the priority byte follows the header and the type
+	data[pack.Head.Len()+1] = 200
+	if _, err = NewDecoder(bytes.NewReader(data), rRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+}
+
+func TestPriorityOnlyOnTransfer(t *testing.T) {
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	pack.Data.Priority = 1
+	if _, err := Encode(pack); !errors.Is(err, ErrorInvalidPriority) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPriority, err)
+	}
+
+	pack.Data.Priority = 0
+	pack.SetChecksum(ChecksumNone)
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[3] |= FlagPriority
+	if _, err = Decode(bytes.NewReader(data)); !errors.Is(err, ErrorInvalidPriority) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPriority, err)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue()
+	for i, priority := range []uint8{0, 5, 0, 9, 5} {
+		pack := NewTransferMessage([]byte{byte(i)})
+		pack.SetPriority(priority)
+		q.Push(pack)
+	}
+
+	// highest priority first, equal priorities in push order
+	for _, want := range []byte{3, 1, 4, 0, 2} {
+		pack := q.Pop()
+		if got := pack.Data.Msg.(TransferMessage)[0]; got != want {
+			t.Fatalf("expected packet %d, got %d", want, got)
+		}
+	}
+	if q.Len() != 0 || q.Pop() != nil {
+		t.Fatal("queue not empty")
+	}
+}
@@ -0,0 +1,98 @@
+// Package prommetrics exports the events of the protocol package as
+// Prometheus metrics, keeping the Prometheus client out of the core
+// package.
+//
+//	c := prommetrics.New()
+//	prometheus.MustRegister(c)
+//	protocol.SetMetrics(c)
+//
+// It builds against github.com/prometheus/client_golang v1.24, fetched with
+// the other dependencies by make depends.
+package prommetrics
+
+import (
+	"errors"
+	"io"
+
+	"github.com/meshbird/meshbird/network/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "meshbird_protocol"
+
+// Collector implements both protocol.Metrics and prometheus.Collector.
+type Collector struct {
+	packets      *prometheus.CounterVec
+	bytes        *prometheus.CounterVec
+	decodeErrors *prometheus.CounterVec
+}
+
+var _ protocol.Metrics = (*Collector)(nil)
+
+func New() *Collector {
+	return &Collector{
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_total",
+			Help:      "Packets encoded and decoded, by direction and type.",
+		}, []string{"direction", "type"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bytes_total",
+			Help:      "Wire bytes of encoded and decoded packets, by direction.",
+		}, []string{"direction"}),
+		decodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decode_errors_total",
+			Help:      "Packets that failed to decode, by kind of error.",
+		}, []string{"kind"}),
+	}
+}
+
+func (c *Collector) PacketEncoded(t uint8, size int) {
+	c.packets.WithLabelValues("out", protocol.PacketType(t).String()).Inc()
+	c.bytes.WithLabelValues("out").Add(float64(size))
+}
+
+func (c *Collector) PacketDecoded(t uint8, size int) {
+	c.packets.WithLabelValues("in", protocol.PacketType(t).String()).Inc()
+	c.bytes.WithLabelValues("in").Add(float64(size))
+}
+
+func (c *Collector) DecodeFailed(err error) {
+	c.decodeErrors.WithLabelValues(errorKind(err)).Inc()
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.packets.Describe(ch)
+	c.bytes.Describe(ch)
+	c.decodeErrors.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.packets.Collect(ch)
+	c.bytes.Collect(ch)
+	c.decodeErrors.Collect(ch)
+}
+
+// errorKind names the class of a decode error with a bounded set of label
+// values.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, protocol.ErrorDecryption):
+		return "decryption"
+	case errors.Is(err, protocol.ErrorChecksumMismatch):
+		return "checksum"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "truncated"
+	}
+	switch protocol.ErrorCodeFor(err) {
+	case protocol.ErrorCodeUnknownType:
+		return "unknown_type"
+	case protocol.ErrorCodeMalformed:
+		return "malformed"
+	case protocol.ErrorCodeInvalidPayload:
+		return "invalid_payload"
+	}
+	return "other"
+}
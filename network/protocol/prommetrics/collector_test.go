@@ -0,0 +1,47 @@
+package prommetrics
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/meshbird/meshbird/network/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectorScrape(t *testing.T) {
+	c := New()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+	protocol.SetMetrics(c)
+	defer protocol.SetMetrics(nil)
+
+	var stream bytes.Buffer
+	if err := protocol.EncodeAndWrite(&stream, protocol.NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := protocol.Decode(&stream); err != nil {
+		t.Fatal(err)
+	}
+	// an unknown type
+	protocol.Decode(bytes.NewReader([]byte{0, 1, protocol.CurrentVersion, 200}))
+
+	expected := `
+# HELP meshbird_protocol_decode_errors_total Packets that failed to decode, by kind of error.
+# TYPE meshbird_protocol_decode_errors_total counter
+meshbird_protocol_decode_errors_total{kind="unknown_type"} 1
+# HELP meshbird_protocol_packets_total Packets encoded and decoded, by direction and type.
+# TYPE meshbird_protocol_packets_total counter
+meshbird_protocol_packets_total{direction="in",type="heartbeat"} 1
+meshbird_protocol_packets_total{direction="out",type="heartbeat"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"meshbird_protocol_packets_total", "meshbird_protocol_decode_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := testutil.GatherAndCount(registry, "meshbird_protocol_bytes_total"); err != nil || n != 2 {
+		t.Fatalf("expected bytes for both directions, got %d series, %v", n, err)
+	}
+}
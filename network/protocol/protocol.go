@@ -2,10 +2,14 @@ package protocol
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/meshbird/meshbird/log"
 	"io"
+	"net"
+	"time"
 )
 
 const (
@@ -14,11 +18,36 @@ const (
 	TypeHeartbeat
 	TypeTransfer
 	TypePeerInfo
+	TypeError
+	TypeTransferBatch
+	TypeGone
+	TypeQuery
+	TypeResponse
+	TypeFEC
+	TypeReset
 )
 
 const (
 	CurrentVersion = 1
-	bodyVectorLen  = 16
+	// FlagsVersion is the first version whose header carries a flags byte.
+	FlagsVersion = 2
+	// ReservedVersion is the first version whose header carries a reserved
+	// byte after the flags. It must be zero until a later version assigns
+	// it a meaning.
+	ReservedVersion = 3
+	// MinVersion and MaxVersion bound the versions an Encoder emits.
+	MinVersion    = 1
+	MaxVersion    = ReservedVersion
+	bodyVectorLen = 16
+	maxHeaderLen  = 5
+
+	// maxBodyLen is the largest Header.Length, a uint16. It covers the body
+	// and the checksum trailer.
+	maxBodyLen = 1<<16 - 1
+	// MaxMessageLen is the largest message that fits a body: the type byte
+	// is always part of the body. See MaxPayload for the space left by the
+	// other fields.
+	MaxMessageLen = maxBodyLen - 1
 )
 
 var (
@@ -27,14 +56,13 @@ var (
 	ErrorUnableToReadVector  = errors.New("unable to read vector")
 	ErrorUnableToReadMessage = errors.New("unable to read message")
 	ErrorUnknownType         = errors.New("unknown type")
-
-	knownTypes = []uint8{
-		TypeHandshake,
-		TypeOk,
-		TypeHeartbeat,
-		TypeTransfer,
-		TypePeerInfo,
-	}
+	ErrorInvalidPayload      = errors.New("invalid payload")
+	ErrorPacketTooLarge      = errors.New("packet too large")
+	ErrorInvalidReadSize     = errors.New("header length too small for packet type")
+	ErrorLengthMismatch      = errors.New("length does not match message")
+	ErrorUnsupportedVersion  = errors.New("unsupported version")
+	ErrorUnexpectedVector    = errors.New("unexpected vector")
+	ErrorReservedNonZero     = errors.New("reserved header byte not zero")
 )
 
 type (
@@ -44,14 +72,35 @@ type (
 		Len() uint16
 	}
 
+	// Header is written in network byte order, like every multi-byte field
+	// of the packet. From ReservedVersion on it ends with a reserved byte
+	// that is always written as zero and rejected otherwise.
 	Header struct {
 		Length  uint16
 		Version uint8
+		// Flags is only present on the wire from FlagsVersion on.
+		Flags uint8
 	}
 	Body struct {
-		Type   uint8
-		Vector []byte
-		Msg    Message
+		Type uint8
+		// Priority is only present on the wire when the header carries
+		// FlagPriority, see SetPriority.
+		Priority uint8
+		// Source and Destination are only present on the wire when the
+		// header carries FlagRoute, see SetRoute.
+		Source      net.IP
+		Destination net.IP
+		// SentAt is only present on the wire when the header carries
+		// FlagTimestamp, see SetSentAt.
+		SentAt time.Time
+		// MessageID is only present on the wire when the header carries
+		// FlagMessageID, see SetMessageID.
+		MessageID uint32
+		Vector    []byte
+		Msg       Message
+		// Raw is a copy of the message bytes as read, after opening and
+		// decompression, when the Decoder was asked to keep them.
+		Raw []byte
 	}
 	Packet struct {
 		Head Header
@@ -59,98 +108,538 @@ type (
 	}
 )
 
+// encodedMessage holds a message already in its wire form, such as after
+// compression or sealing.
+type encodedMessage []byte
+
+func (m encodedMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m encodedMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+// RawMessage is the message of a known type that has no decoder; it holds
+// the body bytes as read.
+type RawMessage []byte
+
+func (m RawMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m RawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
 func (h Header) Len() uint16 {
+	switch {
+	case h.Version >= ReservedVersion:
+		return 5
+	case h.Version >= FlagsVersion:
+		return 4
+	}
 	return 3
 }
 
 func (h *Header) WriteTo(w io.Writer) (n int64, err error) {
 	binary.Write(w, binary.BigEndian, h.Length)
 	binary.Write(w, binary.BigEndian, h.Version)
+	if h.Version >= FlagsVersion {
+		binary.Write(w, binary.BigEndian, h.Flags)
+	}
+	if h.Version >= ReservedVersion {
+		binary.Write(w, binary.BigEndian, uint8(0))
+	}
 	return
 }
 
+// Len returns the size of the body on the wire: the type byte, the
+// priority, route, send time and message id when set, the vector and the
+// message. A body without message is only its overhead.
 func (b Body) Len() uint16 {
-	return b.Msg.Len() + uint16(len(b.Vector)+1)
+	n := uint16(len(b.Vector) + 1 + fieldsLen(b.fieldFlags()))
+	if b.Msg != nil {
+		n += b.Msg.Len()
+	}
+	return n
 }
 
 func (b *Body) WriteTo(w io.Writer) (n int64, err error) {
 	binary.Write(w, binary.BigEndian, b.Type)
+	if flags := b.fieldFlags(); flags != 0 {
+		w.Write(b.appendFields(nil, flags))
+	}
 	if len(b.Vector) > 0 {
 		binary.Write(w, binary.BigEndian, b.Vector)
 	}
-	b.Msg.WriteTo(w)
+	if b.Msg != nil {
+		b.Msg.WriteTo(w)
+	}
 	return
 }
 
 func (p Packet) Len() uint16 {
-	return p.Head.Len() + p.Data.Len()
+	return p.Head.Len() + p.Data.Len() + p.Head.Checksum().Len()
+}
+
+// AsMessage returns the decoded message of p as the concrete type T, or
+// false when p carries a message of another type.
+func AsMessage[T Message](p *Packet) (T, bool) {
+	var zero T
+	if p == nil || p.Data.Msg == nil {
+		return zero, false
+	}
+	msg, ok := p.Data.Msg.(T)
+	return msg, ok
 }
 
 func Decode(r io.Reader) (*Packet, error) {
-	var pack Packet
+	return decode(r, decodeOptions{})
+}
 
-	if err := binary.Read(r, binary.BigEndian, &pack.Head.Length); err != nil {
-		return nil, err
+// DecodeWithKeys is Decode for sessions keyed per type: sealed messages are
+// opened with the key keyFor returns for the packet type, and a nil key
+// decodes the type as plaintext, as for the unsealed control messages.
+func DecodeWithKeys(r io.Reader, keyFor KeyResolver) (*Packet, error) {
+	return decode(r, decodeOptions{keyFor: keyFor})
+}
+
+// decodeOptions configures how a packet is decoded. The zero value decodes
+// plaintext with the defaults.
+type decodeOptions struct {
+	// key opens sealed messages when set.
+	key []byte
+	// keyFor replaces key with the key it resolves for the packet type.
+	keyFor KeyResolver
+	// open replaces AES-GCM.
+	open OpenFunc
+	// raw fills in Body.Raw.
+	raw bool
+	// copyStream reads the message of a stream type in full, for readers
+	// that are reused once decode returns.
+	copyStream bool
+	// maxPlain bounds the size compressed messages may inflate to,
+	// MaxMessageLen when zero.
+	maxPlain int
+	// stats collects the costs of decoding when set.
+	stats *DecodeStats
+	// ctx, when set, is checked before opening and decompressing the
+	// message.
+	ctx context.Context
+}
+
+// canceled returns the error of the context of o once it is done.
+func (o decodeOptions) canceled() error {
+	if o.ctx == nil {
+		return nil
 	}
-	if err := binary.Read(r, binary.BigEndian, &pack.Head.Version); err != nil {
-		return nil, err
+	return o.ctx.Err()
+}
+
+// decode reads one packet from r as opts say and reports the outcome to the
+// installed Metrics. With debug logging enabled, failures are logged along
+// with the start of the input.
+func decode(r io.Reader, opts decodeOptions) (*Packet, error) {
+	return decodeFrom(r, opts, -1)
+}
+
+// decodeDatagram is decode for data holding exactly one packet: bytes after
+// the packet fail with ErrorLengthMismatch, see checkDatagram.
+func decodeDatagram(data []byte, opts decodeOptions) (*Packet, error) {
+	return decodeFrom(bytes.NewReader(data), opts, len(data))
+}
+
+// decodeFrom is decode, checking the packet with checkDatagram when size,
+// the length of a datagram, is not negative.
+func decodeFrom(r io.Reader, opts decodeOptions, size int) (*Packet, error) {
+	var recorder *prefixRecorder
+	if logger.Level() >= log.LevelDebug {
+		recorder = &prefixRecorder{r: r}
+		r = recorder
+	}
+	pack, err := decodePacket(r, opts)
+	if err == nil && size >= 0 {
+		if err = checkDatagram(pack, size); err != nil {
+			pack = nil
+		}
 	}
-	if err := binary.Read(r, binary.BigEndian, &pack.Data.Type); err != nil {
+	reportDecoded(pack, err)
+	if err != nil && err != io.EOF && recorder != nil {
+		logDecodeFailure(err, recorder.buf[:recorder.n])
+	}
+	return pack, err
+}
+
+// reportDecoded reports the outcome of decoding pack to the installed
+// Metrics. io.EOF between packets is no failure.
+func reportDecoded(pack *Packet, err error) {
+	if m := currentMetrics(); m != nil {
+		reportDecodedTo(m, pack, err)
+	}
+}
+
+func reportDecodedTo(m Metrics, pack *Packet, err error) {
+	if err == nil {
+		m.PacketDecoded(pack.Data.Type, int(pack.Head.Len())+int(pack.Head.Length))
+		if pm, ok := m.(PayloadMetrics); ok && pack.Data.Msg != nil {
+			pm.PayloadDecoded(pack.Data.Type, int(pack.Data.Msg.Len()))
+		}
+	} else if err != io.EOF {
+		m.DecodeFailed(err)
+	}
+}
+
+func decodePacket(r io.Reader, opts decodeOptions) (*Packet, error) {
+	// one allocation for the packet, the header scratch space and the
+	// vector of the transfer fast path
+	st := new(struct {
+		pack   Packet
+		head   [maxHeaderLen + 1]byte
+		vector [bodyVectorLen]byte
+	})
+	if err := readHeader(r, &st.pack, st.head[:]); err != nil {
 		return nil, err
 	}
+	if opts.keyFor != nil {
+		opts.key = opts.keyFor(st.pack.Data.Type)
+	}
+
+	// the fast path collects no stats
+	if st.pack.Data.Type == TypeTransfer && st.pack.Head.Flags == 0 && opts.key == nil && opts.stats == nil && transferFastPath.Load() {
+		return decodeTransferFast(r, &st.pack, st.vector[:], opts.raw)
+	}
+	return decodeBody(r, &st.pack, opts)
+}
+
+// readHeader reads the header and the body type into pack, using buf of at
+// least maxHeaderLen+1 bytes as scratch space.
+func readHeader(r io.Reader, pack *Packet, buf []byte) error {
+	if _, err := io.ReadFull(r, buf[:3]); err != nil {
+		return err
+	}
+	pack.Head.Length = binary.BigEndian.Uint16(buf)
+	pack.Head.Version = buf[2]
+
+	rest := buf[3 : pack.Head.Len()+1]
+	if _, err := io.ReadFull(r, rest); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if pack.Head.Version >= FlagsVersion {
+		pack.Head.Flags = rest[0]
+	}
+	pack.Data.Type = rest[len(rest)-1]
+	if pack.Head.Version >= ReservedVersion && rest[1] != 0 {
+		return &DecodeError{Type: pack.Data.Type, Err: ErrorReservedNonZero}
+	}
+	return nil
+}
+
+// decodeBody is the generic decode path for packets whose header and type
+// have already been read into pack. The key of opts is used as is, keyFor
+// was resolved by the caller.
+func decodeBody(r io.Reader, pack *Packet, opts decodeOptions) (*Packet, error) {
+	key, raw, stats := opts.key, opts.raw, opts.stats
 	if !isKnownType(pack.Data.Type) {
-		return nil, ErrorUnknownType
+		return nil, &DecodeError{Type: pack.Data.Type, Err: ErrorUnknownType}
 	}
 
-	remainLength := int(pack.Head.Length) - 1 // minus type
+	if err := checkFields(pack.Head, pack.Data.Type); err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+	if err := checkPeerDelta(pack.Head, pack.Data.Type); err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
 
-	// Only `TypeTransfer` has vector
-	if TypeTransfer == pack.Data.Type {
-		vector := make([]byte, bodyVectorLen)
-		if n, err := r.Read(vector); err != nil || n != bodyVectorLen {
-			if n != bodyVectorLen {
-				err = ErrorUnableToReadVector
+	checksum := pack.Head.Checksum()
+	remainLength := int(pack.Head.Length) - 1 - int(checksum.Len()) // minus type and checksum
+	n, err := readFields(r, pack, remainLength)
+	if err != nil {
+		return nil, err
+	}
+	remainLength -= n
+	if hasVector(pack.Data.Type) && remainLength >= 0 {
+		if key != nil && remainLength < bodyVectorLen {
+			// a sealed message without its nonce can never be opened
+			return nil, &DecodeError{
+				Type:     pack.Data.Type,
+				Err:      fmt.Errorf("%w: encrypted packet without vector", ErrorUnableToReadVector),
+				Expected: bodyVectorLen,
+				Got:      remainLength,
 			}
-			return nil, err
 		}
-		pack.Data.Vector = vector
 		remainLength -= bodyVectorLen
 	}
+	if remainLength < 0 {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: ErrorInvalidReadSize}
+	}
+
+	if hasVector(pack.Data.Type) {
+		vector := make([]byte, bodyVectorLen)
+		if n, err := io.ReadFull(r, vector); err != nil {
+			return nil, shortReadError(pack.Data.Type, ErrorUnableToReadVector, bodyVectorLen, n, err)
+		}
+		pack.Data.Vector = vector
+		if stats != nil {
+			stats.Allocated += bodyVectorLen
+		}
+	}
+
+	stream := streamDecoder(pack.Data.Type)
+	sealed := key != nil && hasVector(pack.Data.Type)
+	if stream != nil && !sealed && !raw && !opts.copyStream && checksum == ChecksumNone && pack.Head.Flags&FlagCompressed == 0 {
+		// nothing to verify, the message is read by the caller
+		return decodeStream(pack, stream, newStreamMessage(r, remainLength))
+	}
 
 	message := make([]byte, remainLength)
-	if n, err := r.Read(message); err != nil || n != remainLength {
-		if n != remainLength {
-			err = ErrorUnableToReadMessage
+	if n, err := io.ReadFull(r, message); err != nil {
+		return nil, shortReadError(pack.Data.Type, ErrorUnableToReadMessage, remainLength, n, err)
+	}
+	if stats != nil {
+		stats.MessageBytes = remainLength
+		stats.Allocated += remainLength
+	}
+
+	if checksum != ChecksumNone {
+		if err := verifyChecksum(r, pack, message); err != nil {
+			return nil, err
 		}
-		return nil, err
 	}
+	message, err = unsealMessage(pack, message, opts)
+	if err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+
+	if raw {
+		pack.Data.Raw = bytes.Clone(message)
+	}
+
+	if stream != nil {
+		return decodeStream(pack, stream, newStreamMessage(bytes.NewReader(message), len(message)))
+	}
+
+	start := stats.start()
+	msg, err := decodeMessage(pack.Data.Type, message)
+	if err == nil {
+		err = checkHeartbeatDelta(pack.Head, msg)
+	}
+	if err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+	pack.Data.Msg = msg
+	if stats != nil {
+		stats.Decode = time.Since(start)
+		stats.PlainBytes = len(message)
+	}
+
+	return pack, nil
+}
+
+// wireLen returns the number of bytes p spans on the wire as its header
+// tells, which counts the message of a stream type not read yet too.
+func (p *Packet) wireLen() int {
+	return int(p.Head.Len()) + int(p.Head.Length)
+}
+
+// checkDatagram fails unless pack, decoded from a datagram of size bytes,
+// spans all of it. What is left in the reader can not tell, a streamed
+// message being still unread.
+func checkDatagram(pack *Packet, size int) error {
+	switch n := size - pack.wireLen(); {
+	case n > 0:
+		return trailingError(pack.Data.Type, n)
+	case n < 0:
+		// only a streamed message gets here, the others were read in full
+		return shortReadError(pack.Data.Type, ErrorUnableToReadMessage, pack.wireLen(), size, io.EOF)
+	}
+	return nil
+}
+
+// trailingError reports n bytes left in a datagram after its packet of
+// type t.
+func trailingError(t uint8, n int) error {
+	return &DecodeError{Type: t, Err: fmt.Errorf("%w: %d trailing bytes", ErrorLengthMismatch, n)}
+}
+
+// shortReadError reports a body that ended after got of the expected bytes.
+// Running out of input inside a frame is io.ErrUnexpectedEOF; io.EOF is
+// only ever returned at a packet boundary.
+func shortReadError(t uint8, what error, expected, got int, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &DecodeError{
+		Type:     t,
+		Err:      fmt.Errorf("%w: %w", what, err),
+		Expected: expected,
+		Got:      got,
+	}
+}
 
-	switch pack.Data.Type {
-	case TypeHandshake:
-		pack.Data.Msg = HandshakeMessage(message)
-	case TypeOk:
-		pack.Data.Msg = OkMessage(message)
-	case TypePeerInfo:
-		pack.Data.Msg = PeerInfoMessage(message)
-	case TypeTransfer:
-		pack.Data.Msg = TransferMessage(message)
-	case TypeHeartbeat:
-		pack.Data.Msg = HeartbeatMessage(message)
+// unsealMessage opens and decompresses message as pack requires. The
+// intermediate plaintext of a message that was both sealed and compressed is
+// wiped before returning.
+func unsealMessage(pack *Packet, message []byte, opts decodeOptions) ([]byte, error) {
+	key, stats := opts.key, opts.stats
+	sealed := key != nil && hasVector(pack.Data.Type)
+	if sealed {
+		if err := opts.canceled(); err != nil {
+			return nil, err
+		}
+		start := stats.start()
+		var err error
+		if message, err = openMessage(key, opts.open, pack, message); err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			stats.Open = time.Since(start)
+			stats.Allocated += len(message)
+		}
+	}
+	if pack.Head.Flags&FlagCompressed != 0 {
+		if err := opts.canceled(); err != nil {
+			if sealed {
+				wipe(message)
+			}
+			return nil, err
+		}
+		start := stats.start()
+		plain, err := decompressMessage(pack.Data.Type, message, opts.maxPlain)
+		if sealed {
+			wipe(message)
+		}
+		if err != nil {
+			return nil, err
+		}
+		message = plain
+		if stats != nil {
+			stats.Decompress = time.Since(start)
+			stats.Allocated += cap(message)
+		}
 	}
+	return message, nil
+}
 
-	return &pack, nil
+// DecodeContext decodes the packet in data, opening it with key when set,
+// and gives up with ctx.Err() once ctx is done. The context is checked
+// before each costly step, opening and decompressing the message, so a
+// deadline bounds the time spent on a hostile packet. Like the other
+// datagram decoders, bytes in data after the packet fail with
+// ErrorLengthMismatch.
+func DecodeContext(ctx context.Context, data, key []byte) (*Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pack, err := decodeDatagram(data, decodeOptions{key: key, ctx: ctx})
+	if err != nil {
+		if errCtx := ctx.Err(); errCtx != nil {
+			return nil, errCtx
+		}
+		return nil, err
+	}
+	return pack, nil
 }
 
+// Encode marshals pack into its wire form. It never logs, reports nothing
+// to Metrics and writes no package state. Besides pack it reads the type
+// registry, under its lock, so it is safe to call from many goroutines at
+// once, also while types are registered. Use EncodeAndWrite for the logging
+// variant.
+//
+// The length written to the header is computed from the body, so a stale
+// pack.Head.Length is not carried onto the wire.
+//
+// The encoding is canonical: the same packet always encodes to the same
+// bytes, as signatures over them require. Nothing depends on map order,
+// and the peer tables of PeerInfo and Heartbeat are sorted when the packet
+// is built. Handshake fields and Gone successors keep the order they were
+// given in, which the caller controls. A fresh vector, see
+// NewTransferMessage, or a send time stamped by an Encoder makes a
+// different packet.
 func Encode(pack *Packet) ([]byte, error) {
+	return encode(pack, nil, nil, defaultCompressionLevel)
+}
+
+// encode marshals pack, sealing the message with key when it is set and the
+// type carries a vector. A nil seal uses AES-GCM. Messages flagged as
+// compressed are deflated at level.
+func encode(pack *Packet, key []byte, seal SealFunc, level int) ([]byte, error) {
+	body := pack.Data
+	head := pack.Head
+	head.Flags = head.Flags&^bodyFieldFlags | body.fieldFlags()
+	if err := checkFields(head, body.Type); err != nil {
+		return nil, err
+	}
+	if body.hasRoute() && (len(body.Source) != net.IPv4len || len(body.Destination) != net.IPv4len) {
+		return nil, ErrorInvalidRoute
+	}
+	if err := checkPeerDelta(head, body.Type); err != nil {
+		return nil, err
+	}
+	if err := checkHeartbeatDelta(head, body.Msg); err != nil {
+		return nil, err
+	}
+	if !hasVector(body.Type) {
+		body.Vector = nil
+	} else if len(body.Vector) != bodyVectorLen {
+		return nil, fmt.Errorf("%w: %s packet needs a %d byte vector", ErrorUnableToReadVector, typeName(body.Type), bodyVectorLen)
+	}
+	if pack.Head.Flags&FlagCompressed != 0 {
+		msg, err := compressMessage(body.Type, body.Msg, level)
+		if err != nil {
+			return nil, err
+		}
+		body.Msg = msg
+	}
+	if key != nil && hasVector(body.Type) {
+		msg, err := sealMessage(key, seal, head, body)
+		if pack.Head.Flags&FlagCompressed != 0 {
+			// the compressed plaintext was scratch space
+			wipeMessage(body.Msg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		body.Msg = msg
+	}
+
+	head.Length = body.Len() + head.Checksum().Len()
+
 	writer := new(bytes.Buffer)
 	writer.Grow(int(pack.Len()))
 
-	pack.Head.WriteTo(writer)
-	pack.Data.WriteTo(writer)
+	head.WriteTo(writer)
+	body.WriteTo(writer)
+	// Len is a uint16 sum and wraps around for oversized bodies, count the
+	// bytes actually written instead
+	if length := writer.Len() - int(head.Len()) + int(head.Checksum().Len()); length > maxBodyLen {
+		return nil, fmt.Errorf("%w: %s body of %d bytes, at most %d", ErrorPacketTooLarge, typeName(body.Type), length, maxBodyLen)
+	}
+	if checksum := head.Checksum(); checksum != ChecksumNone {
+		binary.Write(writer, binary.BigEndian, checksum.Sum(writer.Bytes()))
+	}
 
 	return writer.Bytes(), nil
 }
 
+// EncodeTo writes the wire form of pack to w with the same guarantees as
+// Encode; w is written exactly once. Unlike EncodeAndWrite and Encoder it
+// does not report to Metrics.
+func EncodeTo(w io.Writer, pack *Packet) error {
+	data, err := Encode(pack)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func ReadAndDecode(r io.Reader) (*Packet, error) {
 	pack, errDecode := Decode(r)
 	if errDecode != nil {
@@ -180,14 +669,6 @@ func EncodeAndWrite(w io.Writer, pack *Packet) error {
 	}
 
 	logger.Debug("message sent, %d of %d bytes", n, len(reply))
+	reportEncoded(pack, len(reply))
 	return nil
 }
-
-func isKnownType(needle uint8) bool {
-	for _, t := range knownTypes {
-		if needle == t {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestDecodeContextCancel(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	if err := enc.SetCompressionLevel(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(NewTransferMessage(bytes.Repeat([]byte("tunnelled ip packet "), 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	// canceled while opening, the message is not decompressed
+	ctx, cancel := context.WithCancel(context.Background())
+	open := func(key, nonce, sealed, ad []byte) ([]byte, error) {
+		cancel()
+		return gcmOpen(key, nonce, sealed, ad)
+	}
+	pack, err := decode(bytes.NewReader(stream.Bytes()), decodeOptions{key: rRecv, open: open, ctx: ctx})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v (packet %+v)", context.Canceled, err, pack)
+	}
+
+	// done before the first step
+	if _, err := DecodeContext(ctx, stream.Bytes(), rRecv); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestDecodeContextSuccess(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	if err := enc.SetCompressionLevel(1); err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("tunnelled ip packet "), 20)
+	if err := enc.Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := DecodeContext(context.Background(), stream.Bytes(), rRecv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := AsMessage[TransferMessage](pack); !ok || !bytes.Equal(msg, payload) {
+		t.Fatalf("unexpected %#v", pack.Data.Msg)
+	}
+
+	if _, err = DecodeContext(context.Background(), append(stream.Bytes(), 0), rRecv); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v for a trailing byte, got %v", ErrorLengthMismatch, err)
+	}
+}
+
+func TestAsMessage(t *testing.T) {
+	handshake := NewHandshakePacket([]byte("0123456789abcdef"), &secure.NetworkSecret{})
+	ok := NewOkMessage()
+	heartbeat := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	transfer := NewTransferMessage([]byte{1, 2, 3})
+	peerInfo := NewPeerInfoMessage(net.IPv4(10, 0, 0, 2))
+
+	if msg, found := AsMessage[HandshakeMessage](handshake); !found || !bytes.Equal(msg, handshake.Data.Msg.(HandshakeMessage)) {
+		t.Errorf("handshake not extracted, %v %v", msg, found)
+	}
+	if msg, found := AsMessage[OkMessage](ok); !found || string(msg) != "OK" {
+		t.Errorf("ok not extracted, %v %v", msg, found)
+	}
+	if msg, found := AsMessage[HeartbeatMessage](heartbeat); !found || !bytes.Equal(msg, []byte{10, 0, 0, 1}) {
+		t.Errorf("heartbeat not extracted, %v %v", msg, found)
+	}
+	if msg, found := AsMessage[TransferMessage](transfer); !found || !bytes.Equal(msg, []byte{1, 2, 3}) {
+		t.Errorf("transfer not extracted, %v %v", msg, found)
+	}
+	if msg, found := AsMessage[PeerInfoMessage](peerInfo); !found || !msg.PrivateIP().Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Errorf("peer info not extracted, %v %v", msg, found)
+	}
+}
+
+func TestAsMessageMismatch(t *testing.T) {
+	if _, found := AsMessage[TransferMessage](NewOkMessage()); found {
+		t.Error("ok packet extracted as transfer")
+	}
+	if _, found := AsMessage[HeartbeatMessage](NewPeerInfoMessage(net.IPv4(10, 0, 0, 2))); found {
+		t.Error("peer info packet extracted as heartbeat")
+	}
+	if _, found := AsMessage[OkMessage](nil); found {
+		t.Error("nil packet extracted")
+	}
+	if _, found := AsMessage[OkMessage](&Packet{}); found {
+		t.Error("packet without message extracted")
+	}
+}
+
+func TestDecodeRejectsTooSmallLength(t *testing.T) {
+	for _, data := range [][]byte{
+		{0, 0, CurrentVersion, TypeOk},                       // zero length
+		{0, 5, CurrentVersion, TypeTransfer, 1, 2, 3, 4},     // shorter than the vector
+		{0, 2, FlagsVersion, byte(ChecksumCRC32), TypeOk, 0}, // shorter than the checksum
+	} {
+		pack, err := Decode(bytes.NewReader(data))
+		if !errors.Is(err, ErrorInvalidReadSize) {
+			t.Errorf("%v: expected %v, got %v (packet %+v)", data, ErrorInvalidReadSize, err, pack)
+		}
+	}
+}
+
+func TestBodyLen(t *testing.T) {
+	vector := make([]byte, bodyVectorLen)
+	for _, tt := range []struct {
+		name string
+		body Body
+		want uint16
+	}{
+		{"nil message", Body{Type: TypeOk}, 1},
+		{"nil message with vector", Body{Type: TypeTransfer, Vector: vector}, 1 + bodyVectorLen},
+		{"empty vector", Body{Type: TypeOk, Msg: OkMessage("OK")}, 1 + 2},
+		{"populated vector", Body{Type: TypeTransfer, Vector: vector, Msg: TransferMessage("abc")}, 1 + bodyVectorLen + 3},
+		{"priority", Body{Type: TypeTransfer, Priority: 1, Vector: vector, Msg: TransferMessage("abc")}, 1 + 1 + bodyVectorLen + 3},
+	} {
+		if got := tt.body.Len(); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+		var buf bytes.Buffer
+		tt.body.WriteTo(&buf)
+		if buf.Len() != int(tt.want) {
+			t.Errorf("%s: Len %d, but %d bytes written", tt.name, tt.want, buf.Len())
+		}
+	}
+}
+
+func TestHeaderReservedByte(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	if err := enc.SetVersion(ReservedVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(NewTransferMessage([]byte{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+	if data[2] != ReservedVersion || data[4] != 0 {
+		t.Fatalf("expected a zero reserved byte, got header %v", data[:5])
+	}
+
+	pack, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pack.Head.Len() != 5 {
+		t.Fatalf("expected a 5 byte header, got %d", pack.Head.Len())
+	}
+	if msg, ok := AsMessage[TransferMessage](pack); !ok || !bytes.Equal(msg, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected %#v", pack)
+	}
+
+	data[4] = 1
+	if _, err := Decode(bytes.NewReader(data)); !errors.Is(err, ErrorReservedNonZero) {
+		t.Fatalf("expected %v, got %v", ErrorReservedNonZero, err)
+	}
+}
@@ -0,0 +1,172 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	queryIDLen = 4
+
+	DefaultQueryTimeout = 5 * time.Second
+)
+
+var (
+	ErrorQueryTimeout = errors.New("query timed out")
+)
+
+type (
+	// QueryMessage asks the peer an application defined question: a uint32
+	// query id followed by an opaque payload.
+	QueryMessage []byte
+
+	// ResponseMessage answers the query with the same id, in the same layout.
+	ResponseMessage []byte
+
+	// Queries correlates responses with the queries sent to one peer.
+	Queries struct {
+		Timeout time.Duration
+
+		mu      sync.Mutex
+		lastID  uint32
+		pending map[uint32]chan ResponseMessage
+	}
+)
+
+func NewQueryMessage(id uint32, payload []byte) *Packet {
+	return newQueryPacket(TypeQuery, id, payload)
+}
+
+func NewResponseMessage(id uint32, payload []byte) *Packet {
+	return newQueryPacket(TypeResponse, id, payload)
+}
+
+func newQueryPacket(t uint8, id uint32, payload []byte) *Packet {
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, queryIDLen+len(payload)), id)
+	msg = append(msg, payload...)
+
+	body := Body{
+		Type: t,
+	}
+	if t == TypeQuery {
+		body.Msg = QueryMessage(msg)
+	} else {
+		body.Msg = ResponseMessage(msg)
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+func (m QueryMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m QueryMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m QueryMessage) ID() uint32 {
+	return binary.BigEndian.Uint32(m)
+}
+
+func (m QueryMessage) Payload() []byte {
+	return m[queryIDLen:]
+}
+
+func (m ResponseMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m ResponseMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m ResponseMessage) ID() uint32 {
+	return binary.BigEndian.Uint32(m)
+}
+
+func (m ResponseMessage) Payload() []byte {
+	return m[queryIDLen:]
+}
+
+func decodeQuery(data []byte) (Message, error) {
+	return QueryMessage(data), nil
+}
+
+func decodeResponse(data []byte) (Message, error) {
+	return ResponseMessage(data), nil
+}
+
+func validateQuery(msg Message) error {
+	if msg.Len() < queryIDLen {
+		return fmt.Errorf("query id needs %d bytes, got %d", queryIDLen, msg.Len())
+	}
+	return nil
+}
+
+func NewQueries(timeout time.Duration) *Queries {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return &Queries{
+		Timeout: timeout,
+		pending: make(map[uint32]chan ResponseMessage),
+	}
+}
+
+// Ask sends a query carrying payload with send and waits for the matching
+// response, at most Timeout. It returns the payload of the response.
+func (q *Queries) Ask(send func(*Packet) error, payload []byte) ([]byte, error) {
+	reply := make(chan ResponseMessage, 1)
+
+	q.mu.Lock()
+	q.lastID++
+	id := q.lastID
+	q.pending[id] = reply
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, id)
+		q.mu.Unlock()
+	}()
+
+	if err := send(NewQueryMessage(id, payload)); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(q.Timeout)
+	defer timer.Stop()
+	select {
+	case m := <-reply:
+		return m.Payload(), nil
+	case <-timer.C:
+		return nil, ErrorQueryTimeout
+	}
+}
+
+// Deliver hands a received response to the query waiting for it. It returns
+// false when no query is waiting, e.g. because it already timed out.
+func (q *Queries) Deliver(m ResponseMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reply, ok := q.pending[m.ID()]
+	if !ok {
+		return false
+	}
+	delete(q.pending, m.ID())
+	reply <- m
+	return true
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// queryPeer answers every query by upper casing its payload.
+func queryPeer(t *testing.T, q *Queries, answer bool) func(*Packet) error {
+	return func(pack *Packet) error {
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			return err
+		}
+		query := decoded.Data.Msg.(QueryMessage)
+		if !answer {
+			return nil
+		}
+		go func() {
+			response, err := roundTrip(NewResponseMessage(query.ID(), bytes.ToUpper(query.Payload())))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			q.Deliver(response.Data.Msg.(ResponseMessage))
+		}()
+		return nil
+	}
+}
+
+func TestQueryResponse(t *testing.T) {
+	q := NewQueries(time.Second)
+	send := queryPeer(t, q, true)
+
+	for _, question := range []string{"route 10.0.1.0/24?", "route 10.0.2.0/24?"} {
+		answer, err := q.Ask(send, []byte(question))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(answer) != string(bytes.ToUpper([]byte(question))) {
+			t.Fatalf("unexpected answer %q to %q", answer, question)
+		}
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	q := NewQueries(20 * time.Millisecond)
+	if _, err := q.Ask(queryPeer(t, q, false), []byte("anyone?")); err != ErrorQueryTimeout {
+		t.Fatalf("expected %v, got %v", ErrorQueryTimeout, err)
+	}
+
+	// a late response finds nobody waiting
+	pack, err := encodeDecode(t, NewResponseMessage(1, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Deliver(pack.Data.Msg.(ResponseMessage)) {
+		t.Fatal("late response delivered")
+	}
+}
+
+func TestQueryValidation(t *testing.T) {
+	if _, err := encodeDecode(t, newPacket(TypeQuery, QueryMessage{1, 2})); err == nil {
+		t.Fatal("expected short query id to be rejected")
+	}
+}
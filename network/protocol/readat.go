@@ -0,0 +1,19 @@
+package protocol
+
+import "io"
+
+// ReadPacketAt decodes the packet starting at offset off of r, opening it
+// with key when set, and returns the number of bytes it spans so the caller
+// can continue at the next packet. Only the packet itself is read, which
+// suits capture files too large to load. The message of a type registered
+// with RegisterStreamType is left unread, yet counted in the span; it is
+// read from r at its own offset, so it stays readable after later calls.
+func ReadPacketAt(r io.ReaderAt, off int64, key []byte) (*Packet, int, error) {
+	cr := &countingReader{r: io.NewSectionReader(r, off, maxDelimitedLen)}
+	pack, err := decode(cr, decodeOptions{key: key})
+	if err != nil {
+		return nil, cr.n, err
+	}
+	// the header tells the span, cr has not seen a streamed message
+	return pack, pack.wireLen(), nil
+}
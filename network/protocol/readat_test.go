@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestReadPacketAt(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	packs := []*Packet{
+		NewOkMessage(),
+		NewTransferMessage([]byte("captured")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+	}
+	packs[2].SetChecksum(ChecksumXXHash)
+
+	var capture bytes.Buffer
+	var offsets []int64
+	enc := NewEncoder(&capture, iSend)
+	for _, pack := range packs {
+		offsets = append(offsets, int64(capture.Len()))
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bytes.NewReader(capture.Bytes())
+
+	// random access at the known offsets
+	for i := len(packs) - 1; i >= 0; i-- {
+		pack, n, err := ReadPacketAt(r, offsets[i], rRecv)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		end := int64(capture.Len())
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		if int64(n) != end-offsets[i] {
+			t.Fatalf("packet %d: expected %d bytes, got %d", i, end-offsets[i], n)
+		}
+		if pack.Data.Type != packs[i].Data.Type {
+			t.Fatalf("packet %d: expected %s, got %s", i, PacketType(packs[i].Data.Type), PacketType(pack.Data.Type))
+		}
+	}
+
+	// sequential walk using the consumed counts
+	var off int64
+	for i := range packs {
+		_, n, err := ReadPacketAt(r, off, rRecv)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		off += int64(n)
+	}
+	if off != int64(capture.Len()) {
+		t.Fatalf("walk ended at %d of %d", off, capture.Len())
+	}
+	if _, n, err := ReadPacketAt(r, off, rRecv); err != io.EOF || n != 0 {
+		t.Fatalf("expected EOF past the last packet, got %d bytes, %v", n, err)
+	}
+}
+
+func TestReadPacketAtStreamType(t *testing.T) {
+	const typeStream uint8 = 206
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	packs := []*Packet{
+		NewOkMessage(),
+		newPacket(typeStream, RawMessage("streamed payload")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		newPacket(typeStream, RawMessage("left unread")),
+		NewOkMessage(),
+	}
+	var capture bytes.Buffer
+	enc := NewEncoder(&capture, nil)
+	for _, pack := range packs {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bytes.NewReader(capture.Bytes())
+
+	var off int64
+	for i, want := range packs {
+		pack, n, err := ReadPacketAt(r, off, nil)
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if pack.Data.Type != want.Data.Type {
+			t.Fatalf("packet %d: expected %s, got %s", i, PacketType(want.Data.Type), PacketType(pack.Data.Type))
+		}
+		off += int64(n)
+		if i != 1 {
+			continue
+		}
+		// read the message after the next packet was located
+		next, _, err := ReadPacketAt(r, off, nil)
+		if err != nil || next.Data.Type != packs[i+1].Data.Type {
+			t.Fatalf("packet %d: unexpected %v, %v", i+1, next, err)
+		}
+		msg, ok := AsMessage[*StreamMessage](pack)
+		if !ok {
+			t.Fatalf("packet %d: unexpected %#v", i, pack.Data.Msg)
+		}
+		if got, err := io.ReadAll(msg); err != nil || string(got) != "streamed payload" {
+			t.Fatalf("packet %d: read %q, %v", i, got, err)
+		}
+	}
+	if off != int64(capture.Len()) {
+		t.Fatalf("walk ended at %d of %d", off, capture.Len())
+	}
+}
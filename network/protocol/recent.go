@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// recentErrorsLen is the number of decode errors a Decoder keeps.
+const recentErrorsLen = 32
+
+type (
+	// ErrorRecord is a decode error kept by a Decoder for inspection.
+	ErrorRecord struct {
+		Type PacketType
+		Err  error
+		Time time.Time
+	}
+
+	// errorRing holds the most recent decode errors, overwriting the oldest
+	// once full.
+	errorRing struct {
+		mu      sync.Mutex
+		records []ErrorRecord
+		next    int
+	}
+)
+
+func (r *errorRing) add(t uint8, err error) {
+	record := ErrorRecord{
+		Type: PacketType(t),
+		Err:  err,
+		Time: time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.records) < recentErrorsLen {
+		r.records = append(r.records, record)
+		return
+	}
+	r.records[r.next] = record
+	r.next = (r.next + 1) % recentErrorsLen
+}
+
+// list returns the records from the oldest to the most recent.
+func (r *errorRing) list() []ErrorRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make([]ErrorRecord, 0, len(r.records))
+	records = append(records, r.records[r.next:]...)
+	return append(records, r.records[:r.next]...)
+}
+
+// RecentErrors returns the last decode errors of d, the oldest first. Only
+// errors about a packet are kept: those returned as a *DecodeError, the
+// rejections of MaxPeerEntries and the interceptors and the FEC groups
+// dropped as unrecoverable, not plain I/O errors.
+// It is safe to call while another goroutine decodes.
+func (d *Decoder) RecentErrors() []ErrorRecord {
+	return d.recent.list()
+}
+
+// recordError keeps err in the ring of recent errors when it is about pack,
+// or about a packet of a DecodeError, and returns it.
+func (d *Decoder) recordError(pack *Packet, err error) error {
+	var decodeErr *DecodeError
+	switch {
+	case errors.As(err, &decodeErr):
+		d.recent.add(decodeErr.Type, err)
+	case pack != nil:
+		d.recent.add(pack.Data.Type, err)
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// DecodeFunc turns a raw message body into a Message.
+	DecodeFunc func(data []byte) (Message, error)
+	// ValidateFunc checks a structurally decoded message for semantic errors.
+	ValidateFunc func(msg Message) error
+	// FallbackDecodeFunc turns the raw message body of a packet of any
+	// unregistered type t into a Message.
+	FallbackDecodeFunc func(t uint8, data []byte) (Message, error)
+
+	// PacketType is the type byte of a packet, named for logs.
+	PacketType uint8
+
+	messageType struct {
+		decode   DecodeFunc
+		validate ValidateFunc
+		// stream is set for types registered with RegisterStreamType.
+		stream StreamDecodeFunc
+	}
+)
+
+var (
+	registryMu       sync.RWMutex
+	transferFastPath atomic.Bool
+	vectorTypes      [256]atomic.Bool
+	knownTypes       = make(map[uint8]messageType)
+	typeNames        = make(map[uint8]string)
+	invariants       = make(map[uint8]InvariantFunc)
+	fallbackDecoder  FallbackDecodeFunc
+)
+
+func init() {
+	RegisterType(TypeHandshake, "handshake", decodeHandshake, validateHandshake)
+	RegisterType(TypeOk, "ok", decodeOk, validateOk)
+	RegisterType(TypeHeartbeat, "heartbeat", decodeHeartbeat, validateHeartbeat)
+	RegisterType(TypeTransfer, "transfer", decodeTransfer, validateTransfer)
+	RegisterType(TypePeerInfo, "peer info", decodePeerInfo, validatePeerInfo)
+	RegisterType(TypeError, "error", decodeError, validateError)
+	RegisterType(TypeTransferBatch, "transfer batch", decodeTransferBatch, nil)
+	RegisterType(TypeGone, "gone", decodeGone, validateGone)
+	RegisterType(TypeQuery, "query", decodeQuery, validateQuery)
+	RegisterType(TypeResponse, "response", decodeResponse, validateQuery)
+	RegisterType(TypeFEC, "fec", decodeFEC, validateFEC)
+	RegisterType(TypeReset, "reset", decodeReset, validateReset)
+
+	RegisterInvariant(TypeHeartbeat, heartbeatInvariant)
+	RegisterInvariant(TypePeerInfo, peerInfoInvariant)
+
+	vectorTypes[TypeTransfer].Store(true)
+	// parity is computed over plain payloads and must be sealed like them
+	vectorTypes[TypeFEC].Store(true)
+	transferFastPath.Store(true)
+}
+
+// RegisterType makes packets of type t decodable by Decode. The decoder is
+// optional, without one the message is a RawMessage. A decoder returns a
+// message spanning exactly the bytes it parsed; anything shorter than the
+// body fails with ErrorLengthMismatch. The validator is
+// optional; when set it runs after decode and any error it returns is
+// reported as ErrorInvalidPayload. Registering an existing type replaces it.
+//
+// The registry is safe for concurrent use, but types should be registered
+// before packets are served so that peers see a stable set of types.
+func RegisterType(t uint8, name string, decode DecodeFunc, validate ValidateFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if t == TypeTransfer {
+		// the fast path hardcodes the built-in transfer decoder
+		transferFastPath.Store(false)
+	}
+	knownTypes[t] = messageType{
+		decode:   decode,
+		validate: validate,
+	}
+	typeNames[t] = name
+}
+
+// SetFallbackDecoder makes packets of every type not registered with
+// RegisterType or RegisterStreamType decodable by decode, which Decode
+// otherwise rejects with ErrorUnknownType. The decoder follows the rules of
+// a DecodeFunc; returning ErrorUnknownType refuses the type. Their bodies
+// carry no vector unless set with SetVector. A nil decode removes the
+// fallback.
+func SetFallbackDecoder(decode FallbackDecodeFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	fallbackDecoder = decode
+}
+
+// RegisterStreamType makes packets of type t decodable by Decode without
+// reading their message up front: decode receives the message as a
+// StreamMessage and returns a Message that also implements io.Reader over
+// the payload, a nil decode handing out the StreamMessage itself. The
+// message bytes are then consumed from the stream as the caller reads them.
+// Messages that are sealed, compressed or checksummed have to be read in
+// full to be verified, for those the reader goes over the verified copy.
+// Registering an existing type replaces it.
+func RegisterStreamType(t uint8, name string, decode StreamDecodeFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if t == TypeTransfer {
+		// the fast path hardcodes the built-in transfer decoder
+		transferFastPath.Store(false)
+	}
+	if decode == nil {
+		decode = func(body *StreamMessage) (Message, error) {
+			return body, nil
+		}
+	}
+	knownTypes[t] = messageType{
+		stream: decode,
+	}
+	typeNames[t] = name
+}
+
+// SetVector configures whether bodies of type t carry a vector. Only
+// Transfer and FEC do by default; control messages are never sealed and save the
+// 16 bytes. Types without a vector are sent in the clear even by an Encoder
+// with a key, and both peers must agree on the setting.
+func SetVector(t uint8, present bool) {
+	if t == TypeTransfer {
+		// the fast path assumes a transfer vector
+		transferFastPath.Store(false)
+	}
+	vectorTypes[t].Store(present)
+}
+
+// hasVector reports whether bodies of type t carry a vector.
+func hasVector(t uint8) bool {
+	return vectorTypes[t].Load()
+}
+
+func isKnownType(needle uint8) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	_, ok := knownTypes[needle]
+	return ok || fallbackDecoder != nil
+}
+
+// lookupType returns the registered type t, or one decoding with the
+// fallback decoder when t is not registered.
+func lookupType(t uint8) (messageType, string, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if mt, ok := knownTypes[t]; ok {
+		return mt, typeNames[t], true
+	}
+	if fallback := fallbackDecoder; fallback != nil {
+		return messageType{
+			decode: func(data []byte) (Message, error) {
+				return fallback(t, data)
+			},
+		}, fmt.Sprintf("type %d", t), true
+	}
+	return messageType{}, "", false
+}
+
+// streamDecoder returns the stream decoder of type t, nil unless it was
+// registered with RegisterStreamType.
+func streamDecoder(t uint8) StreamDecodeFunc {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return knownTypes[t].stream
+}
+
+func typeName(t uint8) string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
+// String returns the registered name of the type, "type N" when unknown.
+func (t PacketType) String() string {
+	return typeName(uint8(t))
+}
+
+func (t PacketType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func decodeMessage(t uint8, data []byte) (Message, error) {
+	mt, name, ok := lookupType(t)
+	if !ok {
+		return nil, ErrorUnknownType
+	}
+
+	if mt.decode == nil {
+		return RawMessage(data), nil
+	}
+	msg, err := mt.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		msg = RawMessage(data)
+	}
+	if int(msg.Len()) != len(data) {
+		// the decoder stopped short of the length the header announced
+		return nil, fmt.Errorf("%w: %s body of %d bytes, decoded %d", ErrorLengthMismatch, name, len(data), msg.Len())
+	}
+
+	if mt.validate != nil {
+		if err = mt.validate(msg); err != nil {
+			logger.Debug("invalid %s payload, %v", name, err)
+			return nil, fmt.Errorf("%w: %w", ErrorInvalidPayload, err)
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,274 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func encodeDecode(t *testing.T, pack *Packet) (*Packet, error) {
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Decode(bytes.NewReader(data))
+}
+
+// roundTrip is encodeDecode for goroutines other than the test's, which
+// must not call t.Fatal: it returns the Encode error as well.
+func roundTrip(pack *Packet) (*Packet, error) {
+	data, err := Encode(pack)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(bytes.NewReader(data))
+}
+
+func TestValidatorAcceptsValidPayload(t *testing.T) {
+	for _, pack := range []*Packet{
+		NewOkMessage(),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		NewPeerInfoMessage(net.IPv4(10, 0, 0, 2)),
+		NewTransferMessage([]byte{1, 2, 3}),
+	} {
+		if _, err := encodeDecode(t, pack); err != nil {
+			t.Errorf("type %d: unexpected error %v", pack.Data.Type, err)
+		}
+	}
+}
+
+func TestValidatorRejectsInvalidPayload(t *testing.T) {
+	for _, pack := range []*Packet{
+		newPacket(TypeOk, OkMessage("NO")),
+		newPacket(TypeHeartbeat, HeartbeatMessage{1, 2}),
+		NewPeerInfoMessage(net.IPv4zero),
+		NewTransferMessage(nil),
+	} {
+		if _, err := encodeDecode(t, pack); !errors.Is(err, ErrorInvalidPayload) {
+			t.Errorf("type %d: expected %v, got %v", pack.Data.Type, ErrorInvalidPayload, err)
+		}
+	}
+}
+
+func TestRegisterTypeValidator(t *testing.T) {
+	const typeCustom uint8 = 200
+	defer unregisterType(typeCustom)
+
+	RegisterType(typeCustom, "custom", func(data []byte) (Message, error) {
+		return testMessage(data), nil
+	}, func(msg Message) error {
+		if msg.Len() > 2 {
+			return fmt.Errorf("too long")
+		}
+		return nil
+	})
+
+	if _, err := encodeDecode(t, newPacket(typeCustom, testMessage{1, 2})); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := encodeDecode(t, newPacket(typeCustom, testMessage{1, 2, 3})); !errors.Is(err, ErrorInvalidPayload) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPayload, err)
+	}
+}
+
+func TestDecodeKnownTypesHaveMessage(t *testing.T) {
+	batch, err := NewTransferBatchMessage([][]byte{{1}, {2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packs := map[uint8]*Packet{
+		TypeHandshake:     NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}),
+		TypeOk:            NewOkMessage(),
+		TypeHeartbeat:     NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		TypeTransfer:      NewTransferMessage([]byte{1, 2, 3}),
+		TypePeerInfo:      NewPeerInfoMessage(net.IPv4(10, 0, 0, 2)),
+		TypeError:         NewErrorMessage(ErrorCodeMalformed, "bad"),
+		TypeTransferBatch: batch,
+		TypeGone:          NewGoneMessage(GoneReasonShutdown, nil),
+		TypeQuery:         NewQueryMessage(1, []byte("subnet?")),
+		TypeResponse:      NewResponseMessage(1, []byte("yes")),
+		TypeFEC:           fecPacket(t),
+		TypeReset:         NewResetMessage(ResetReasonKeyMismatch),
+	}
+
+	registryMu.RLock()
+	for typ := range knownTypes {
+		if _, ok := packs[typ]; !ok {
+			t.Errorf("no test packet for %s", typeName(typ))
+		}
+	}
+	registryMu.RUnlock()
+
+	for typ, pack := range packs {
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatalf("%s: %v", typeName(typ), err)
+		}
+		if decoded.Data.Msg == nil {
+			t.Errorf("%s: nil message", typeName(typ))
+		}
+	}
+}
+
+func TestRegisterTypeWithoutDecoder(t *testing.T) {
+	const typeCustom uint8 = 201
+	defer unregisterType(typeCustom)
+
+	RegisterType(typeCustom, "custom", nil, nil)
+	pack, err := encodeDecode(t, newPacket(typeCustom, testMessage{1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := pack.Data.Msg.(RawMessage); !ok || !bytes.Equal(msg, []byte{1, 2}) {
+		t.Fatalf("expected raw message, got %#v", pack.Data.Msg)
+	}
+}
+
+func TestFallbackDecoder(t *testing.T) {
+	const typePlugin uint8 = 205
+	defer SetFallbackDecoder(nil)
+
+	if _, err := encodeDecode(t, newPacket(typePlugin, testMessage{1, 2})); !errors.Is(err, ErrorUnknownType) {
+		t.Fatalf("expected %v without fallback, got %v", ErrorUnknownType, err)
+	}
+
+	var seen []uint8
+	SetFallbackDecoder(func(typ uint8, data []byte) (Message, error) {
+		seen = append(seen, typ)
+		return testMessage(data), nil
+	})
+	pack, err := encodeDecode(t, newPacket(typePlugin, testMessage{1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := pack.Data.Msg.(testMessage); !ok || !bytes.Equal(msg, []byte{1, 2}) {
+		t.Fatalf("expected the fallback's message, got %#v", pack.Data.Msg)
+	}
+	if err := VerifyInvariants(pack); err != nil {
+		t.Fatal(err)
+	}
+
+	// registered types keep their own decoder
+	if _, err := encodeDecode(t, NewOkMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0] != typePlugin {
+		t.Fatalf("expected the fallback to see only type %d, got %v", typePlugin, seen)
+	}
+
+	SetFallbackDecoder(func(typ uint8, data []byte) (Message, error) {
+		return nil, ErrorUnknownType
+	})
+	if _, err := encodeDecode(t, newPacket(typePlugin, testMessage{1, 2})); !errors.Is(err, ErrorUnknownType) {
+		t.Fatalf("expected %v from a refusing fallback, got %v", ErrorUnknownType, err)
+	}
+}
+
+func newPacket(t uint8, msg Message) *Packet {
+	body := Body{
+		Type: t,
+		Msg:  msg,
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+func unregisterType(t uint8) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(knownTypes, t)
+	delete(typeNames, t)
+}
+
+type testMessage []byte
+
+func (m testMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m testMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	const firstType uint8 = 100
+	data, err := Encode(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			typ := firstType + uint8(i)
+			RegisterType(typ, fmt.Sprintf("custom %d", i), func(data []byte) (Message, error) {
+				return testMessage(data), nil
+			}, nil)
+			if _, err := roundTrip(newPacket(typ, testMessage{1})); err != nil {
+				t.Errorf("type %d: %v", typ, err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := Decode(bytes.NewReader(data)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 16; i++ {
+		unregisterType(firstType + uint8(i))
+	}
+}
+
+func TestPacketTypeString(t *testing.T) {
+	for typ, name := range map[uint8]string{
+		TypeHandshake:     "handshake",
+		TypeOk:            "ok",
+		TypeHeartbeat:     "heartbeat",
+		TypeTransfer:      "transfer",
+		TypePeerInfo:      "peer info",
+		TypeError:         "error",
+		TypeTransferBatch: "transfer batch",
+		TypeGone:          "gone",
+		TypeQuery:         "query",
+		TypeResponse:      "response",
+		TypeFEC:           "fec",
+		TypeReset:         "reset",
+		250:               "type 250",
+	} {
+		if got := PacketType(typ).String(); got != name {
+			t.Errorf("type %d: expected %q, got %q", typ, name, got)
+		}
+	}
+}
+
+func TestPacketTypeMarshalText(t *testing.T) {
+	data, err := json.Marshal(map[string]PacketType{"type": PacketType(TypePeerInfo)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"type":"peer info"}` {
+		t.Fatalf("unexpected json %s", data)
+	}
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+var (
+	ErrorShortHeader = errors.New("buffer too short for packet header")
+)
+
+// RelayPacket is a packet as a relay sees it: the header and the fields
+// routing depends on are parsed, the rest of the frame is kept opaque. The
+// sealed message stays sealed, so relays forward traffic without holding
+// the session keys. The header is part of the additional data the message
+// is sealed with and cannot be changed on the way.
+type RelayPacket struct {
+	Head     Header
+	Type     uint8
+	Priority uint8
+	// Source and Destination are the node ids of a Transfer carrying a
+	// route, see SetRoute, nil otherwise.
+	Source      net.IP
+	Destination net.IP
+
+	frame []byte
+}
+
+// PeekType returns the type of the packet data starts with, reading only
+// the version and type bytes. It neither allocates nor checks anything
+// beyond the buffer being long enough, which makes it the cheapest way to
+// dispatch a frame; see RelayDecode for a validated header.
+func PeekType(data []byte) (uint8, error) {
+	if len(data) < 3 {
+		return 0, ErrorShortHeader
+	}
+	off := int(Header{Version: data[2]}.Len())
+	if len(data) <= off {
+		return 0, ErrorShortHeader
+	}
+	return data[off], nil
+}
+
+// RelayDecode reads the next packet from r without decoding its message.
+// The checksum, when present, is verified since it needs no key; anything
+// else about the message is left to the receiving peer.
+func RelayDecode(r io.Reader) (*RelayPacket, error) {
+	var (
+		pack Packet
+		buf  [maxHeaderLen + 1]byte
+	)
+	if err := readHeader(r, &pack, buf[:]); err != nil {
+		return nil, err
+	}
+	t := pack.Data.Type
+	if err := checkFields(pack.Head, t); err != nil {
+		return nil, &DecodeError{Type: t, Err: err}
+	}
+	if pack.Head.Length == 0 {
+		return nil, &DecodeError{Type: t, Err: ErrorInvalidReadSize}
+	}
+
+	headLen := int(pack.Head.Len())
+	frame := make([]byte, headLen+int(pack.Head.Length))
+	// readHeader left the header and the type in buf as read
+	copy(frame, buf[:headLen+1])
+	if n, err := io.ReadFull(r, frame[headLen+1:]); err != nil {
+		return nil, shortReadError(t, ErrorUnableToReadMessage, len(frame)-headLen-1, n, err)
+	}
+
+	relayed := &RelayPacket{
+		Head:  pack.Head,
+		Type:  t,
+		frame: frame,
+	}
+	off := headLen + 1
+	if n := fieldsLen(pack.Head.Flags); n != 0 {
+		if len(frame) < off+n {
+			return nil, &DecodeError{Type: t, Err: ErrorInvalidReadSize}
+		}
+		if err := pack.Data.parseFields(frame[off:off+n:off+n], pack.Head.Flags); err != nil {
+			return nil, &DecodeError{Type: t, Err: err}
+		}
+		relayed.Priority = pack.Data.Priority
+		relayed.Source, relayed.Destination = pack.Data.Source, pack.Data.Destination
+	}
+	if checksum := pack.Head.Checksum(); checksum != ChecksumNone {
+		trailer := len(frame) - int(checksum.Len())
+		if trailer < headLen+1 {
+			return nil, &DecodeError{Type: t, Err: ErrorInvalidReadSize}
+		}
+		if checksum.Sum(frame[:trailer]) != binary.BigEndian.Uint32(frame[trailer:]) {
+			return nil, &DecodeError{Type: t, Err: ErrorChecksumMismatch}
+		}
+	}
+	return relayed, nil
+}
+
+// Frame returns the packet as read, header included.
+func (p *RelayPacket) Frame() []byte {
+	return p.frame
+}
+
+// WriteTo forwards the packet byte for byte.
+func (p *RelayPacket) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(p.frame)
+	return int64(n), err
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRelayDecode(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	var inbound bytes.Buffer
+	enc := NewEncoder(&inbound, iSend)
+	plain := NewTransferMessage([]byte("tunnelled ip packet"))
+	urgent := NewTransferMessage([]byte("urgent ip packet"))
+	urgent.SetPriority(7)
+	urgent.SetChecksum(ChecksumCRC32C)
+	for _, pack := range []*Packet{plain, urgent, NewOkMessage()} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wire := bytes.Clone(inbound.Bytes())
+
+	// the relay routes by header alone and holds no key
+	var outbound bytes.Buffer
+	for _, want := range []struct {
+		t        uint8
+		priority uint8
+	}{{TypeTransfer, 0}, {TypeTransfer, 7}, {TypeOk, 0}} {
+		relayed, err := RelayDecode(&inbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relayed.Type != want.t || relayed.Priority != want.priority {
+			t.Fatalf("expected %s with priority %d, got %s with %d", PacketType(want.t), want.priority, PacketType(relayed.Type), relayed.Priority)
+		}
+		if _, err := relayed.WriteTo(&outbound); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(outbound.Bytes(), wire) {
+		t.Fatal("relayed frames differ from the ones received")
+	}
+
+	dec := NewDecoder(&outbound, rRecv)
+	for _, want := range []string{"tunnelled ip packet", "urgent ip packet"} {
+		pack, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg, ok := AsMessage[TransferMessage](pack); !ok || string(msg) != want {
+			t.Fatalf("expected %q, got %#v", want, pack)
+		}
+	}
+}
+
+func TestRelayDecodeChecksum(t *testing.T) {
+	pack := NewTransferMessage([]byte("tunnelled ip packet"))
+	pack.SetChecksum(ChecksumCRC32C)
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-6] ^= 1
+	if _, err := RelayDecode(bytes.NewReader(data)); !errors.Is(err, ErrorChecksumMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorChecksumMismatch, err)
+	}
+}
+
+func TestPeekType(t *testing.T) {
+	urgent := NewTransferMessage([]byte("urgent ip packet"))
+	urgent.SetPriority(7)
+	for _, tc := range []struct {
+		pack    *Packet
+		version uint8
+	}{
+		{NewTransferMessage([]byte("ip packet")), CurrentVersion},
+		{urgent, FlagsVersion},
+		{NewOkMessage(), ReservedVersion},
+	} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf, nil)
+		if err := enc.SetVersion(tc.version); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(tc.pack); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		got, err := PeekType(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.pack.Data.Type {
+			t.Fatalf("version %d: expected %s, got %s", tc.version, PacketType(tc.pack.Data.Type), PacketType(got))
+		}
+		if allocs := testing.AllocsPerRun(100, func() { PeekType(data) }); allocs != 0 {
+			t.Fatalf("PeekType allocated %v times", allocs)
+		}
+
+		headLen := int(Header{Version: tc.version}.Len())
+		for _, short := range [][]byte{nil, data[:2], data[:headLen]} {
+			if _, err := PeekType(short); err != ErrorShortHeader {
+				t.Fatalf("version %d, %d bytes: expected %v, got %v", tc.version, len(short), ErrorShortHeader, err)
+			}
+		}
+	}
+}
+
+func BenchmarkPeekType(b *testing.B) {
+	data, err := Encode(NewTransferMessage(bytes.Repeat([]byte{9}, 1400)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := PeekType(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
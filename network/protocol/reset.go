@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultResetInterval is the least time between two calls of a Decoder's
+// OnReset unless ResetInterval says otherwise.
+const DefaultResetInterval = 10 * time.Second
+
+const (
+	ResetReasonUnspecified uint8 = iota
+	// ResetReasonKeyMismatch is sent when packets of the peer keep failing
+	// to open, the session keys having drifted apart.
+	ResetReasonKeyMismatch
+)
+
+type (
+	// ResetMessage asks the peer to drop the session and handshake again.
+	// It is never sealed: it is sent precisely when the keys can no longer
+	// be trusted to agree. Neither is it authenticated, see
+	// Decoder.OnReset.
+	ResetMessage []byte
+)
+
+// NewResetMessage builds a Reset packet asking the peer to handshake again
+// for reason.
+func NewResetMessage(reason uint8) *Packet {
+	body := Body{
+		Type: TypeReset,
+		Msg:  ResetMessage{reason},
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}
+}
+
+func (m ResetMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m ResetMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+func (m ResetMessage) Reason() uint8 {
+	return m[0]
+}
+
+func decodeReset(data []byte) (Message, error) {
+	return ResetMessage(data), nil
+}
+
+func validateReset(msg Message) error {
+	m := msg.(ResetMessage)
+	if len(m) != 1 {
+		return fmt.Errorf("reset message of %d bytes, expected a reason", len(m))
+	}
+	if m.Reason() > ResetReasonKeyMismatch {
+		return fmt.Errorf("unknown reset reason %d", m.Reason())
+	}
+	return nil
+}
+
+// acceptReset reports whether OnReset is due for a Reset decoded now,
+// recording the time when it is.
+func (d *Decoder) acceptReset() bool {
+	interval := d.ResetInterval
+	if interval <= 0 {
+		interval = DefaultResetInterval
+	}
+	now := d.now()
+	if !d.lastReset.IsZero() && now.Sub(d.lastReset) < interval {
+		return false
+	}
+	d.lastReset = now
+	return true
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResetRoundTrip(t *testing.T) {
+	pack, err := encodeDecode(t, NewResetMessage(ResetReasonKeyMismatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := AsMessage[ResetMessage](pack)
+	if !ok || msg.Reason() != ResetReasonKeyMismatch {
+		t.Fatalf("unexpected reset %#v", pack.Data)
+	}
+	if pack.Data.Vector != nil {
+		t.Fatal("reset carries a vector")
+	}
+
+	for _, bad := range []ResetMessage{{}, {ResetReasonKeyMismatch, 0}, {ResetReasonKeyMismatch + 1}} {
+		if _, err := encodeDecode(t, newPacket(TypeReset, bad)); !errors.Is(err, ErrorInvalidPayload) {
+			t.Fatalf("reset %v: expected %v, got %v", bad, ErrorInvalidPayload, err)
+		}
+	}
+}
+
+func TestDecoderOnReset(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	// the reset is readable even with keys that no longer match
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	for _, pack := range []*Packet{NewTransferMessage([]byte("ip packet")), NewResetMessage(ResetReasonKeyMismatch)} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var resets []ResetMessage
+	dec := NewDecoder(&stream, rRecv)
+	dec.OnReset = func(m ResetMessage) {
+		resets = append(resets, m)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if len(resets) != 0 {
+		t.Fatal("callback fired on a transfer")
+	}
+	pack, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pack.Data.Type != TypeReset {
+		t.Fatalf("expected the reset to be returned, got %s", PacketType(pack.Data.Type))
+	}
+	if len(resets) != 1 || resets[0].Reason() != ResetReasonKeyMismatch {
+		t.Fatalf("expected one key mismatch reset, got %v", resets)
+	}
+}
+
+func TestDecoderOnResetInterval(t *testing.T) {
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(NewResetMessage(ResetReasonKeyMismatch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Unix(1000, 0)
+	resets := 0
+	dec := NewDecoder(&stream, nil)
+	dec.now = func() time.Time { return now }
+	dec.OnReset = func(ResetMessage) {
+		resets++
+	}
+	for i, step := range []time.Duration{0, DefaultResetInterval - time.Second, time.Second} {
+		now = now.Add(step)
+		pack, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pack.Data.Type != TypeReset {
+			t.Fatalf("reset %d: got %s", i, PacketType(pack.Data.Type))
+		}
+	}
+	// the second reset came within the interval of the first
+	if resets != 2 {
+		t.Fatalf("expected 2 callbacks, got %d", resets)
+	}
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultResumeTTL = 10 * time.Minute
+
+	resumeExpiryLen = 8
+	resumeMACLen    = 16
+	resumeTokenLen  = resumeExpiryLen + resumeMACLen
+)
+
+var (
+	ErrorInvalidResumeToken = errors.New("invalid resume token")
+	ErrorExpiredResumeToken = errors.New("expired resume token")
+)
+
+// ResumeTokens issues and checks the tokens a reconnecting peer presents in
+// its handshake to reuse its session key instead of negotiating a new one.
+// A token is the expiry time and a MAC over it and the session key, so it
+// is only valid for the session it was issued for.
+type ResumeTokens struct {
+	TTL time.Duration
+
+	mu     sync.Mutex
+	secret []byte
+	now    func() time.Time
+}
+
+func NewResumeTokens(secret []byte, ttl time.Duration) *ResumeTokens {
+	if ttl <= 0 {
+		ttl = DefaultResumeTTL
+	}
+	return &ResumeTokens{
+		TTL:    ttl,
+		secret: append([]byte(nil), secret...),
+		now:    time.Now,
+	}
+}
+
+// Issue returns a token binding sessionKey until the TTL elapses.
+func (rt *ResumeTokens) Issue(sessionKey []byte) []byte {
+	rt.mu.Lock()
+	expires := rt.now().Add(rt.TTL)
+	rt.mu.Unlock()
+
+	token := make([]byte, resumeExpiryLen, resumeTokenLen)
+	binary.BigEndian.PutUint64(token, uint64(expires.UnixNano()))
+	return append(token, rt.mac(sessionKey, token)...)
+}
+
+// Validate checks that token was issued for sessionKey and has not expired.
+func (rt *ResumeTokens) Validate(token, sessionKey []byte) error {
+	if len(token) != resumeTokenLen {
+		return ErrorInvalidResumeToken
+	}
+
+	expiry := token[:resumeExpiryLen]
+	if !hmac.Equal(token[resumeExpiryLen:], rt.mac(sessionKey, expiry)) {
+		return ErrorInvalidResumeToken
+	}
+
+	rt.mu.Lock()
+	now := rt.now()
+	rt.mu.Unlock()
+
+	if now.UnixNano() >= int64(binary.BigEndian.Uint64(expiry)) {
+		return ErrorExpiredResumeToken
+	}
+	return nil
+}
+
+// Resume reports whether m can take the fast path: it carries a valid token
+// for its session key, so the full negotiation can be skipped.
+func (rt *ResumeTokens) Resume(m HandshakeMessage) error {
+	token := m.ResumeToken()
+	if token == nil {
+		return ErrorInvalidResumeToken
+	}
+	return rt.Validate(token, m.SessionKey())
+}
+
+func (rt *ResumeTokens) mac(sessionKey, expiry []byte) []byte {
+	h := hmac.New(sha256.New, rt.secret)
+	h.Write(sessionKey)
+	h.Write(expiry)
+	return h.Sum(nil)[:resumeMACLen]
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func newTestResumeTokens() (*ResumeTokens, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	rt := NewResumeTokens([]byte("resume secret"), time.Minute)
+	rt.now = clock.Now
+	return rt, clock
+}
+
+func TestResumeHandshake(t *testing.T) {
+	rt, clock := newTestResumeTokens()
+	sessionKey := bytes.Repeat([]byte{1}, sessionKeyLen)
+
+	token := rt.Issue(sessionKey)
+	pack, err := encodeDecode(t, NewHandshakePacket(sessionKey, &secure.NetworkSecret{},
+		HandshakeField{Tag: HandshakeResumeToken, Value: token}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := pack.Data.Msg.(HandshakeMessage)
+	if !bytes.Equal(msg.SessionKey(), sessionKey) {
+		t.Fatalf("session key changed, %v", msg.SessionKey())
+	}
+	if !bytes.Equal(msg.ResumeToken(), token) {
+		t.Fatalf("resume token changed, %v", msg.ResumeToken())
+	}
+
+	clock.Advance(59 * time.Second)
+	if err = rt.Resume(msg); err != nil {
+		t.Fatalf("expected resume, got %v", err)
+	}
+}
+
+func TestResumeRejectsExpiredToken(t *testing.T) {
+	rt, clock := newTestResumeTokens()
+	sessionKey := bytes.Repeat([]byte{1}, sessionKeyLen)
+	token := rt.Issue(sessionKey)
+
+	clock.Advance(time.Minute)
+	if err := rt.Validate(token, sessionKey); err != ErrorExpiredResumeToken {
+		t.Fatalf("expected %v, got %v", ErrorExpiredResumeToken, err)
+	}
+}
+
+func TestResumeRejectsInvalidToken(t *testing.T) {
+	rt, _ := newTestResumeTokens()
+	sessionKey := bytes.Repeat([]byte{1}, sessionKeyLen)
+	token := rt.Issue(sessionKey)
+
+	otherSession := bytes.Repeat([]byte{2}, sessionKeyLen)
+	if err := rt.Validate(token, otherSession); err != ErrorInvalidResumeToken {
+		t.Fatalf("token accepted for another session, %v", err)
+	}
+
+	tampered := append([]byte(nil), token...)
+	tampered[0] ^= 0xff
+	if err := rt.Validate(tampered, sessionKey); err != ErrorInvalidResumeToken {
+		t.Fatalf("tampered token accepted, %v", err)
+	}
+
+	other := NewResumeTokens([]byte("other secret"), time.Minute)
+	if err := other.Validate(token, sessionKey); err != ErrorInvalidResumeToken {
+		t.Fatalf("token accepted by another issuer, %v", err)
+	}
+
+	plain := NewHandshakePacket(sessionKey, &secure.NetworkSecret{}).Data.Msg.(HandshakeMessage)
+	if err := rt.Resume(plain); err != ErrorInvalidResumeToken {
+		t.Fatalf("handshake without token resumed, %v", err)
+	}
+}
+
+func TestHandshakeMalformedField(t *testing.T) {
+	msg := append(append([]byte(nil), magicKey...), bytes.Repeat([]byte{1}, sessionKeyLen)...)
+	msg = append(msg, HandshakeResumeToken, 0, 10, 1, 2)
+
+	if _, err := encodeDecode(t, newPacket(TypeHandshake, HandshakeMessage(msg))); err == nil {
+		t.Fatal("expected malformed field to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+)
+
+// FlagRoute marks a Transfer body carrying the node ids of its source and
+// destination right after the priority. They are sent in the clear, so
+// relays can route the packet without opening it, and are authenticated
+// along with the sealed message.
+const FlagRoute uint8 = 1 << 5
+
+// routeLen is the wire size of a route: two node ids, the private IPv4
+// addresses nodes identify themselves with in the handshake.
+const routeLen = 2 * net.IPv4len
+
+var (
+	ErrorInvalidRoute = errors.New("route only allowed on transfer packets from flags version on, between two IPv4 node ids")
+)
+
+// SetRoute marks p, a Transfer packet, with the node ids of its source and
+// destination, upgrading its header to FlagsVersion when needed. Nil ids
+// remove the route. It fails with ErrorInvalidRoute, leaving p as it was,
+// unless both ids are IPv4 addresses.
+func (p *Packet) SetRoute(source, destination net.IP) error {
+	if source == nil && destination == nil {
+		p.Data.Source, p.Data.Destination = nil, nil
+		p.Head.Flags &^= FlagRoute
+	} else {
+		source, destination = source.To4(), destination.To4()
+		if source == nil || destination == nil {
+			return ErrorInvalidRoute
+		}
+		p.Data.Source, p.Data.Destination = source, destination
+		if p.Head.Version < FlagsVersion {
+			p.Head.Version = FlagsVersion
+		}
+		p.Head.Flags |= FlagRoute
+	}
+	p.Head.Length = p.Data.Len() + p.Head.Checksum().Len()
+	return nil
+}
+
+// hasRoute reports whether the body carries a route on the wire.
+func (b Body) hasRoute() bool {
+	return b.Source != nil || b.Destination != nil
+}
+
+// appendRoute appends the wire form of the route of b.
+func (b Body) appendRoute(dst []byte) []byte {
+	return append(append(dst, b.Source...), b.Destination...)
+}
+
+// checkRoute verifies that a route is only carried where the format allows
+// it.
+func checkRoute(head Header, t uint8) error {
+	if head.Flags&FlagRoute != 0 && (head.Version < FlagsVersion || t != TypeTransfer) {
+		return ErrorInvalidRoute
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestTransferRoute(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	source, destination := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	for name, key := range map[string][]byte{"plain": nil, "sealed": iSend} {
+		pack := NewTransferMessage([]byte("tunnelled ip packet"))
+		if err := pack.SetRoute(source, destination); err != nil {
+			t.Fatal(err)
+		}
+		pack.SetPriority(2)
+
+		var stream bytes.Buffer
+		if err := NewEncoder(&stream, key).Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+		wire := bytes.Clone(stream.Bytes())
+
+		// relays read the route without the key
+		relayed, err := RelayDecode(bytes.NewReader(wire))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !relayed.Source.Equal(source) || !relayed.Destination.Equal(destination) {
+			t.Fatalf("%s: relay sees route %v to %v", name, relayed.Source, relayed.Destination)
+		}
+
+		var recvKey []byte
+		if key != nil {
+			recvKey = rRecv
+		}
+		decoded, err := NewDecoder(&stream, recvKey).Decode()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !decoded.Data.Source.Equal(source) || !decoded.Data.Destination.Equal(destination) || decoded.Data.Priority != 2 {
+			t.Fatalf("%s: unexpected %#v", name, decoded.Data)
+		}
+		if msg, ok := AsMessage[TransferMessage](decoded); !ok || string(msg) != "tunnelled ip packet" {
+			t.Fatalf("%s: unexpected %#v", name, decoded.Data.Msg)
+		}
+		if err := VerifyInvariants(decoded); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestTransferRouteAuthenticated(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	pack := NewTransferMessage([]byte("tunnelled ip packet"))
+	if err := pack.SetRoute(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(pack); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+	spans := annotate(data, true)
+	for _, span := range spans {
+		if span.Name == "destination" {
+			// a relay redirecting the packet
+			data[span.End-1] ^= 1
+		}
+	}
+	if _, err := NewDecoder(bytes.NewReader(data), rRecv).Decode(); !errors.Is(err, ErrorDecryption) {
+		t.Fatalf("expected %v, got %v", ErrorDecryption, err)
+	}
+}
+
+func TestRouteOnlyOnTransfer(t *testing.T) {
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	pack.Data.Source, pack.Data.Destination = net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()
+	pack.Head.Version = FlagsVersion
+	if _, err := Encode(pack); !errors.Is(err, ErrorInvalidRoute) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidRoute, err)
+	}
+
+	pack = NewTransferMessage([]byte{1})
+	if err := pack.SetRoute(net.IPv4(10, 0, 0, 1), nil); !errors.Is(err, ErrorInvalidRoute) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidRoute, err)
+	}
+	if err := pack.SetRoute(net.IPv4(10, 0, 0, 1), net.ParseIP("fd00::2")); !errors.Is(err, ErrorInvalidRoute) {
+		t.Fatalf("expected %v for an IPv6 id, got %v", ErrorInvalidRoute, err)
+	}
+	if pack.Head.Flags&FlagRoute != 0 || pack.Data.hasRoute() {
+		t.Fatalf("expected no route, got %v to %v", pack.Data.Source, pack.Data.Destination)
+	}
+}
@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultSendQueueLen is the queue length of a Sender created without one.
+const DefaultSendQueueLen = 64
+
+var (
+	ErrorSenderClosed = errors.New("sender closed")
+	ErrorDrainTimeout = errors.New("send queue not drained in time")
+)
+
+// Sender is the bounded send queue of a link: it queues packets for an
+// Encoder and writes them from its own goroutine, highest priority first as
+// ordered by PriorityQueue. The Encoder must not be used directly any more.
+type Sender struct {
+	enc      *Encoder
+	queueLen int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  *PriorityQueue
+	closed bool
+	err    error
+	done   chan struct{}
+}
+
+// NewSender starts writing the packets sent to it with enc, holding at
+// most queueLen of them, DefaultSendQueueLen when queueLen is not positive.
+func NewSender(enc *Encoder, queueLen int) *Sender {
+	if queueLen <= 0 {
+		queueLen = DefaultSendQueueLen
+	}
+	s := &Sender{
+		enc:      enc,
+		queueLen: queueLen,
+		queue:    NewPriorityQueue(),
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+// Send queues pack. It fails with ErrorWouldBlock rather than block when
+// the writer falls behind, and with the first write error once one
+// occurred; the Sender stops writing at that error.
+func (s *Sender) Send(pack *Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+		return ErrorSenderClosed
+	case s.err != nil:
+		return s.err
+	case s.queue.Len() >= s.queueLen:
+		return ErrorWouldBlock
+	}
+	s.queue.Push(pack)
+	s.cond.Signal()
+	return nil
+}
+
+// GracefulClose stops accepting packets, queues a Gone with reason behind
+// the pending ones and waits up to timeout for the queue to drain. The
+// writer of the Encoder is closed in any case when it is an io.Closer,
+// which also unblocks a stalled write, and GracefulClose returns once the
+// writing goroutine has exited. It returns ErrorDrainTimeout when the queue
+// did not drain in time, else the write error that stopped the Sender.
+func (s *Sender) GracefulClose(reason uint8, timeout time.Duration) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrorSenderClosed
+	}
+	s.closed = true
+	if s.err == nil {
+		// priority zero and pushed last, so sent after every queued packet
+		s.queue.Push(NewGoneMessage(reason, nil))
+	}
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-s.done:
+	case <-timer.C:
+		err = ErrorDrainTimeout
+		// run exits after the write in flight
+		s.mu.Lock()
+		s.drop()
+		s.mu.Unlock()
+	}
+
+	if closer, ok := s.enc.w.(io.Closer); ok {
+		if errClose := closer.Close(); err == nil {
+			err = errClose
+		}
+	}
+	<-s.done
+
+	if err == nil {
+		s.mu.Lock()
+		err = s.err
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		for s.queue.Len() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		pack := s.queue.Pop()
+		s.mu.Unlock()
+		if pack == nil {
+			return
+		}
+
+		if err := s.enc.Encode(pack); err != nil {
+			logger.Error("error on send, %v", err)
+			s.mu.Lock()
+			s.err = err
+			s.drop()
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// drop discards the queued packets. s.mu must be held.
+func (s *Sender) drop() {
+	for s.queue.Pop() != nil {
+	}
+}
@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSenderGracefulClose(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	received := make(chan []*Packet, 1)
+	go func() {
+		var packs []*Packet
+		dec := NewDecoder(remote, nil)
+		for {
+			pack, err := dec.Decode()
+			if err != nil {
+				received <- packs
+				return
+			}
+			packs = append(packs, pack)
+		}
+	}()
+
+	s := NewSender(NewEncoder(local, nil), 16)
+	for i := 0; i < 10; i++ {
+		if err := s.Send(NewTransferMessage([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.GracefulClose(GoneReasonShutdown, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(NewTransferMessage([]byte{1})); err != ErrorSenderClosed {
+		t.Fatalf("expected %v, got %v", ErrorSenderClosed, err)
+	}
+
+	packs := <-received
+	if len(packs) != 11 {
+		t.Fatalf("expected 10 transfers and a gone, got %d packets", len(packs))
+	}
+	for i, pack := range packs[:10] {
+		if msg, ok := AsMessage[TransferMessage](pack); !ok || msg[0] != byte(i) {
+			t.Fatalf("packet %d: unexpected %#v", i, pack)
+		}
+	}
+	if msg, ok := AsMessage[GoneMessage](packs[10]); !ok || msg.Reason() != GoneReasonShutdown {
+		t.Fatalf("expected a gone last, got %#v", packs[10])
+	}
+}
+
+func TestSenderGracefulCloseStalled(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// nobody reads the remote end, the first write never completes
+	s := NewSender(NewEncoder(local, nil), 16)
+	if err := s.Send(NewTransferMessage([]byte("stuck"))); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := s.GracefulClose(GoneReasonShutdown, 50*time.Millisecond); !errors.Is(err, ErrorDrainTimeout) {
+		t.Fatalf("expected %v, got %v", ErrorDrainTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("closed after %v, before the timeout", elapsed)
+	}
+	if _, err := local.Write([]byte{0}); err != io.ErrClosedPipe {
+		t.Fatalf("connection not closed, write returned %v", err)
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("writer still running after close")
+	}
+}
+
+func TestSenderQueueFull(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	s := NewSender(NewEncoder(local, nil), 1)
+	defer s.GracefulClose(GoneReasonShutdown, 0)
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = s.Send(NewTransferMessage([]byte{byte(i)}))
+	}
+	if err != ErrorWouldBlock {
+		t.Fatalf("expected %v, got %v", ErrorWouldBlock, err)
+	}
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct {
+	n      int
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.n {
+		return 0, io.ErrShortWrite
+	}
+	return len(p), nil
+}
+
+func TestSenderStopsOnWriteError(t *testing.T) {
+	w := &failingWriter{n: 1}
+	s := NewSender(NewEncoder(w, nil), 0)
+	for i := 0; i < 3; i++ {
+		if err := s.Send(NewTransferMessage([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.GracefulClose(GoneReasonShutdown, time.Second); err != io.ErrShortWrite {
+		t.Fatalf("expected %v, got %v", io.ErrShortWrite, err)
+	}
+	// the second transfer failed, neither the third nor the gone was tried
+	if w.writes != 2 {
+		t.Fatalf("expected 2 writes, got %d", w.writes)
+	}
+	if err := s.Send(NewTransferMessage([]byte{3})); err != ErrorSenderClosed {
+		t.Fatalf("expected %v, got %v", ErrorSenderClosed, err)
+	}
+}
+
+func TestSenderPriority(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	received := make(chan []*Packet, 1)
+	go func() {
+		var packs []*Packet
+		dec := NewDecoder(remote, nil)
+		for {
+			pack, err := dec.Decode()
+			if err != nil {
+				received <- packs
+				return
+			}
+			packs = append(packs, pack)
+		}
+	}()
+
+	// nothing is written until the first send, the writer picks the
+	// highest priority of whatever is queued by then
+	s := NewSender(NewEncoder(local, nil), 16)
+	s.mu.Lock()
+	for i, priority := range []uint8{0, 2, 1} {
+		pack := NewTransferMessage([]byte{byte(i)})
+		pack.SetPriority(priority)
+		s.queue.Push(pack)
+	}
+	s.cond.Signal()
+	s.mu.Unlock()
+	if err := s.GracefulClose(GoneReasonShutdown, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	packs := <-received
+	if len(packs) != 4 {
+		t.Fatalf("expected 3 transfers and a gone, got %d packets", len(packs))
+	}
+	for i, want := range []byte{1, 2, 0} {
+		if msg, ok := AsMessage[TransferMessage](packs[i]); !ok || msg[0] != want {
+			t.Fatalf("packet %d: expected transfer %d, got %#v", i, want, packs[i])
+		}
+	}
+	if _, ok := AsMessage[GoneMessage](packs[3]); !ok {
+		t.Fatalf("expected a gone last, got %#v", packs[3])
+	}
+}
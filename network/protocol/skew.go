@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSkewThreshold warns well before the skew reaches
+	// DefaultHeartbeatSkew, beyond which Keepalive drops the heartbeats.
+	DefaultSkewThreshold = DefaultHeartbeatSkew / 2
+
+	// DefaultSkewSamples is how many heartbeats the estimate is taken over.
+	DefaultSkewSamples = 8
+)
+
+// SkewEstimator estimates the offset of the local clock against the clock of
+// a peer from its timed heartbeats, see NewTimedHeartbeatMessage. Each
+// heartbeat gives the receive time minus the send time, less half the RTT
+// spent in transit; the estimate is the median of the last Samples of them,
+// so a heartbeat delayed in a queue does not move it. A positive offset means
+// the local clock is ahead. Once the estimate exceeds Threshold in either
+// direction a warning is logged and OnSkew called, again only after it fell
+// back below. It is safe for concurrent use.
+type SkewEstimator struct {
+	Threshold time.Duration
+	Samples   int
+	OnSkew    func(offset time.Duration)
+
+	mu      sync.Mutex
+	now     func() time.Time
+	samples []time.Duration
+	skewed  bool
+}
+
+func NewSkewEstimator(threshold time.Duration, onSkew func(offset time.Duration)) *SkewEstimator {
+	if threshold <= 0 {
+		threshold = DefaultSkewThreshold
+	}
+	return &SkewEstimator{
+		Threshold: threshold,
+		Samples:   DefaultSkewSamples,
+		OnSkew:    onSkew,
+		now:       time.Now,
+	}
+}
+
+// Observe feeds a heartbeat received just now along with the current RTT to
+// the peer, see Keepalive.RTT, and returns the updated estimate. Plain
+// heartbeats carry no send time and are ignored, returning false. Feed the
+// heartbeats before Keepalive.Received, which drops those too skewed.
+func (s *SkewEstimator) Observe(m HeartbeatMessage, rtt time.Duration) (time.Duration, bool) {
+	sent, ok := m.Timestamp()
+	if !ok {
+		return 0, false
+	}
+
+	s.mu.Lock()
+	s.samples = append(s.samples, s.now().Sub(sent)-rtt/2)
+	if n := max(s.Samples, 1); len(s.samples) > n {
+		s.samples = append(s.samples[:0], s.samples[len(s.samples)-n:]...)
+	}
+	offset := s.offset()
+	skewed := offset > s.Threshold || offset < -s.Threshold
+	warn := skewed && !s.skewed
+	s.skewed = skewed
+	s.mu.Unlock()
+
+	if warn {
+		logger.Warning("clock offset to peer %s exceeds %s", offset, s.Threshold)
+		if s.OnSkew != nil {
+			s.OnSkew(offset)
+		}
+	}
+	return offset, true
+}
+
+// Offset returns the current estimate, false before any timed heartbeat.
+func (s *SkewEstimator) Offset() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 {
+		return 0, false
+	}
+	return s.offset(), true
+}
+
+// offset returns the median of the samples, the mean of the middle two for
+// an even count.
+func (s *SkewEstimator) offset() time.Duration {
+	sorted := slices.Clone(s.samples)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return sorted[mid-1] + (sorted[mid]-sorted[mid-1])/2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSkewEstimator(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	var alerts []time.Duration
+	s := NewSkewEstimator(time.Second, func(offset time.Duration) {
+		alerts = append(alerts, offset)
+	})
+	s.now = func() time.Time { return now }
+
+	heartbeat := func(sent time.Time) HeartbeatMessage {
+		return NewTimedHeartbeatMessage(net.IPv4(10, 0, 0, 1), sent).Data.Msg.(HeartbeatMessage)
+	}
+
+	if _, ok := s.Observe(NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)).Data.Msg.(HeartbeatMessage), 0); ok {
+		t.Fatal("expected a plain heartbeat to be ignored")
+	}
+	if _, ok := s.Offset(); ok {
+		t.Fatal("expected no estimate before a timed heartbeat")
+	}
+
+	// the peer clock is 3s behind, heartbeats take 20ms one way, one of them
+	// is held up in a queue for another 500ms
+	const skew, rtt = 3 * time.Second, 40 * time.Millisecond
+	for i := range 5 {
+		now = now.Add(time.Second)
+		sent := now.Add(-skew - rtt/2)
+		if i == 2 {
+			sent = sent.Add(-500 * time.Millisecond)
+		}
+		s.Observe(heartbeat(sent), rtt)
+	}
+	offset, ok := s.Offset()
+	if !ok || offset != skew {
+		t.Fatalf("expected offset %s, got %s", skew, offset)
+	}
+	if len(alerts) != 1 || alerts[0] != skew {
+		t.Fatalf("expected a single alert at %s, got %v", skew, alerts)
+	}
+
+	// the peer clock is corrected, the estimate follows over the window
+	for range DefaultSkewSamples {
+		now = now.Add(time.Second)
+		s.Observe(heartbeat(now.Add(-rtt/2)), rtt)
+	}
+	if offset, _ := s.Offset(); offset != 0 {
+		t.Fatalf("expected offset 0, got %s", offset)
+	}
+
+	// and a peer clock ahead alerts again
+	for range DefaultSkewSamples {
+		now = now.Add(time.Second)
+		s.Observe(heartbeat(now.Add(2*time.Second-rtt/2)), rtt)
+	}
+	if offset, _ := s.Offset(); offset != -2*time.Second {
+		t.Fatalf("expected offset -2s, got %s", offset)
+	}
+	if len(alerts) != 2 || alerts[1] >= -time.Second {
+		t.Fatalf("expected a second alert ahead, got %v", alerts)
+	}
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import "encoding/binary"
+
+// SplitFrames delimits the complete packets at the start of data using only
+// their headers, without decoding or opening them, so relays can forward
+// frames they hold no key for. It returns the frames, which alias data, and
+// the number of bytes they span; a trailing partial frame is left for the
+// next call. A header announcing no body is reported as ErrorInvalidReadSize
+// along with the frames preceding it.
+func SplitFrames(data []byte) ([][]byte, int, error) {
+	var (
+		frames   [][]byte
+		consumed int
+	)
+	for rest := data; len(rest) >= 3; rest = data[consumed:] {
+		head := Header{
+			Length:  binary.BigEndian.Uint16(rest),
+			Version: rest[2],
+		}
+		if head.Length == 0 {
+			return frames, consumed, ErrorInvalidReadSize
+		}
+		frameLen := int(head.Len()) + int(head.Length)
+		if len(rest) < frameLen {
+			break
+		}
+		frames = append(frames, rest[:frameLen:frameLen])
+		consumed += frameLen
+	}
+	return frames, consumed, nil
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSplitFrames(t *testing.T) {
+	packs := []*Packet{
+		NewOkMessage(),
+		NewTransferMessage([]byte("payload")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+	}
+	packs[2].SetChecksum(ChecksumCRC32)
+
+	var stream []byte
+	var encoded [][]byte
+	for _, pack := range packs {
+		data, err := Encode(pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded = append(encoded, data)
+		stream = append(stream, data...)
+	}
+	whole := len(stream)
+	next, _ := Encode(NewTransferMessage([]byte("next")))
+	stream = append(stream, next[:10]...)
+
+	frames, consumed, err := SplitFrames(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumed != whole || len(frames) != len(packs) {
+		t.Fatalf("expected %d frames in %d bytes, got %d in %d", len(packs), whole, len(frames), consumed)
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(frame, encoded[i]) {
+			t.Fatalf("frame %d: expected %v, got %v", i, encoded[i], frame)
+		}
+		decoded, err := Decode(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		samePacket(t, packs[i], decoded)
+	}
+
+	// the partial frame completes once the rest arrives
+	frames, consumed, err = SplitFrames(append(stream[consumed:], next[10:]...))
+	if err != nil || len(frames) != 1 || consumed != len(next) {
+		t.Fatalf("expected the completed frame, got %d frames in %d bytes, %v", len(frames), consumed, err)
+	}
+}
+
+func TestSplitFramesPartialHeader(t *testing.T) {
+	frames, consumed, err := SplitFrames([]byte{0, 3})
+	if err != nil || len(frames) != 0 || consumed != 0 {
+		t.Fatalf("expected nothing, got %d frames in %d bytes, %v", len(frames), consumed, err)
+	}
+}
+
+func TestSplitFramesEmptyBody(t *testing.T) {
+	ok, _ := Encode(NewOkMessage())
+	frames, consumed, err := SplitFrames(append(ok, 0, 0, CurrentVersion))
+	if err != ErrorInvalidReadSize || len(frames) != 1 || consumed != len(ok) {
+		t.Fatalf("expected %v after one frame, got %d frames in %d bytes, %v", ErrorInvalidReadSize, len(frames), consumed, err)
+	}
+}
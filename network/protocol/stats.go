@@ -0,0 +1,44 @@
+package protocol
+
+import "time"
+
+// DecodeStats describes the work done by DecodeWithStats. Stage durations
+// are zero for stages the packet did not need.
+type DecodeStats struct {
+	Total      time.Duration
+	Open       time.Duration // decryption
+	Decompress time.Duration
+	Decode     time.Duration // message decoding and validation
+
+	WireBytes    int // header and body as read
+	MessageBytes int // message as read, still sealed or compressed
+	PlainBytes   int // message after opening and decompression
+	Allocated    int // bytes of buffers allocated for the packet
+}
+
+// DecodeWithStats decodes the single packet in data, opening it with key
+// when set, and reports per stage timings and sizes. It always takes the
+// generic decode path, so it is meant for debugging rather than serving.
+// Like the other datagram decoders, bytes in data after the packet fail
+// with ErrorLengthMismatch.
+func DecodeWithStats(data []byte, key []byte) (*Packet, DecodeStats, error) {
+	var stats DecodeStats
+	start := time.Now()
+
+	pack, err := decodeDatagram(data, decodeOptions{key: key, stats: &stats})
+	if err != nil {
+		return nil, stats, err
+	}
+
+	stats.WireBytes = pack.wireLen()
+	stats.Total = time.Since(start)
+	return pack, stats, nil
+}
+
+// start returns the current time when stats are collected.
+func (s *DecodeStats) start() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
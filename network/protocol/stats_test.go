@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDecodeWithStats(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	payload := bytes.Repeat([]byte("tunnelled ip packet "), 10)
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewTransferMessage(payload)); err != nil {
+		t.Fatal(err)
+	}
+	wire := stream.Len()
+
+	pack, stats, err := DecodeWithStats(stream.Bytes(), rRecv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pack.Data.Msg.(TransferMessage), payload) {
+		t.Fatalf("unexpected payload %q", pack.Data.Msg)
+	}
+	if stats.WireBytes != wire || stats.PlainBytes != len(payload) || stats.MessageBytes != len(payload)+16 {
+		t.Fatalf("unexpected sizes %+v", stats)
+	}
+	if stats.Allocated < stats.MessageBytes+stats.PlainBytes {
+		t.Fatalf("allocated bytes too low %+v", stats)
+	}
+	if stats.Open <= 0 || stats.Total < stats.Open+stats.Decode || stats.Decompress != 0 {
+		t.Fatalf("implausible timings %+v", stats)
+	}
+}
+
+func TestDecodeWithStatsCompressed(t *testing.T) {
+	pack := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(20))
+	pack.SetCompressed(CapabilityDictCompression)
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stats, err := DecodeWithStats(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Decompress <= 0 || stats.Open != 0 || stats.PlainBytes <= stats.MessageBytes {
+		t.Fatalf("implausible stats %+v", stats)
+	}
+}
+
+func TestDecodeWithStatsTrailingBytes(t *testing.T) {
+	data, err := Encode(NewTransferMessage([]byte("tunnelled ip packet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stats, err := DecodeWithStats(data, nil); err != nil || stats.WireBytes != len(data) {
+		t.Fatalf("unexpected %+v, %v", stats, err)
+	}
+	if _, _, err = DecodeWithStats(append(data, 0), nil); !errors.Is(err, ErrorLengthMismatch) {
+		t.Fatalf("expected %v, got %v", ErrorLengthMismatch, err)
+	}
+}
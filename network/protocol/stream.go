@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// streamHeaderLen covers the sequence number and the flags that start
+	// every chunk of a transfer stream.
+	streamHeaderLen = 4 + 1
+	streamFlagEnd   = 1 << 0
+
+	// DefaultStreamChunk is the payload size of a stream chunk when none is
+	// given.
+	DefaultStreamChunk = 8 << 10
+	// MaxStreamChunk is the largest chunk payload that still fits a sealed
+	// Transfer packet.
+	MaxStreamChunk = MaxMessageLen - bodyVectorLen - gcmTagLen - streamHeaderLen
+)
+
+var (
+	ErrorStreamClosed   = errors.New("transfer stream closed")
+	ErrorStreamSequence = errors.New("transfer stream out of sequence")
+)
+
+type (
+	// TransferStreamWriter splits a byte stream into sequenced Transfer
+	// packets. Every chunk payload starts with its uint32 sequence number
+	// and a flags byte; Close sends the chunk flagged as the end of the
+	// stream.
+	TransferStreamWriter struct {
+		enc    *Encoder
+		chunk  int
+		seq    uint32
+		closed bool
+	}
+
+	// TransferStreamReader reassembles the chunks of a TransferStreamWriter
+	// into a byte stream, returning io.EOF after the end of the stream.
+	// Packets of other types are skipped; a missing or reordered chunk
+	// fails with ErrorStreamSequence.
+	TransferStreamReader struct {
+		dec  *Decoder
+		seq  uint32
+		buf  []byte
+		done bool
+	}
+)
+
+// NewTransferStreamWriter writes chunks of at most chunkSize bytes to enc,
+// DefaultStreamChunk when chunkSize is zero.
+func NewTransferStreamWriter(enc *Encoder, chunkSize int) (*TransferStreamWriter, error) {
+	if chunkSize == 0 {
+		chunkSize = DefaultStreamChunk
+	}
+	if chunkSize < 0 || chunkSize > MaxStreamChunk {
+		return nil, fmt.Errorf("%w: chunk of %d bytes, at most %d", ErrorPacketTooLarge, chunkSize, MaxStreamChunk)
+	}
+	return &TransferStreamWriter{
+		enc:   enc,
+		chunk: chunkSize,
+	}, nil
+}
+
+func (s *TransferStreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrorStreamClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		size := min(len(p), s.chunk)
+		if err := s.send(p[:size], 0); err != nil {
+			return n, err
+		}
+		n += size
+		p = p[size:]
+	}
+	return n, nil
+}
+
+// ReadFrom copies r to the stream until io.EOF, without closing it.
+func (s *TransferStreamWriter) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, s.chunk)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, errWrite := s.Write(buf[:n]); errWrite != nil {
+				return total, errWrite
+			}
+			total += int64(n)
+		}
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return total, nil
+		default:
+			return total, err
+		}
+	}
+}
+
+// Close signals the end of the stream to the reader.
+func (s *TransferStreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.send(nil, streamFlagEnd)
+}
+
+func (s *TransferStreamWriter) send(data []byte, flags uint8) error {
+	payload := make([]byte, streamHeaderLen, streamHeaderLen+len(data))
+	binary.BigEndian.PutUint32(payload, s.seq)
+	payload[4] = flags
+	payload = append(payload, data...)
+	s.seq++
+	return s.enc.Encode(NewTransferMessage(payload))
+}
+
+func NewTransferStreamReader(dec *Decoder) *TransferStreamReader {
+	return &TransferStreamReader{dec: dec}
+}
+
+func (s *TransferStreamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *TransferStreamReader) next() error {
+	pack, err := s.dec.Decode()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	msg, ok := AsMessage[TransferMessage](pack)
+	if !ok {
+		return nil
+	}
+	if len(msg) < streamHeaderLen {
+		return fmt.Errorf("%w: chunk of %d bytes", ErrorInvalidPayload, len(msg))
+	}
+	if seq := binary.BigEndian.Uint32(msg); seq != s.seq {
+		return fmt.Errorf("%w: chunk %d, expected %d", ErrorStreamSequence, seq, s.seq)
+	}
+	s.seq++
+	s.done = msg[4]&streamFlagEnd != 0
+	s.buf = msg[streamHeaderLen:]
+	return nil
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTransferStream(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	data := make([]byte, 3<<20+123)
+	rand.Read(data)
+
+	var wire bytes.Buffer
+	w, err := NewTransferStreamWriter(NewEncoder(&wire, iSend), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.ReadFrom(bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %d of %d bytes, %v", n, len(data), err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	if _, err = io.Copy(h, NewTransferStreamReader(NewDecoder(&wire, rRecv))); err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(data); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Fatal("checksum of the reassembled stream differs")
+	}
+}
+
+func TestTransferStreamMissingChunk(t *testing.T) {
+	var wire bytes.Buffer
+	enc := NewEncoder(&wire, nil)
+	w, err := NewTransferStreamWriter(enc, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("abcd"))
+	w.seq++ // a chunk lost on the way
+	w.Write([]byte("efgh"))
+	w.Close()
+
+	_, err = io.ReadAll(NewTransferStreamReader(NewDecoder(&wire, nil)))
+	if !errors.Is(err, ErrorStreamSequence) {
+		t.Fatalf("expected %v, got %v", ErrorStreamSequence, err)
+	}
+}
+
+func TestTransferStreamTruncated(t *testing.T) {
+	var wire bytes.Buffer
+	w, _ := NewTransferStreamWriter(NewEncoder(&wire, nil), 0)
+	w.Write([]byte("no end of stream"))
+
+	if _, err := io.ReadAll(NewTransferStreamReader(NewDecoder(&wire, nil))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrorTooManyStreams = errors.New("too many concurrent streams")
+)
+
+// StreamLimit admits the streams of a session up to the number negotiated
+// in the handshake. A stream demultiplexer calls Open when it sees a new
+// stream id and Close once the stream ended. It is safe for concurrent use.
+type StreamLimit struct {
+	mu   sync.Mutex
+	max  int
+	open map[uint32]struct{}
+}
+
+// MaxStreamsField builds the handshake field announcing how many concurrent
+// streams the local node accepts.
+func MaxStreamsField(limit uint16) HandshakeField {
+	return HandshakeField{Tag: HandshakeMaxStreams, Value: binary.BigEndian.AppendUint16(nil, limit)}
+}
+
+// NegotiateMaxStreams returns the stream limit of the session: the lower of
+// local and the limit announced in the peer's handshake, so both sides
+// settle on the same value. A peer announcing none gets local.
+func NegotiateMaxStreams(local uint16, m HandshakeMessage) uint16 {
+	value, ok := m.Field(HandshakeMaxStreams)
+	if !ok || len(value) != 2 {
+		return local
+	}
+	return min(local, binary.BigEndian.Uint16(value))
+}
+
+func NewStreamLimit(limit uint16) *StreamLimit {
+	return &StreamLimit{
+		max:  int(limit),
+		open: make(map[uint32]struct{}),
+	}
+}
+
+// Open admits stream id, failing with ErrorTooManyStreams when it is new and
+// the limit is reached. Opening an admitted stream again is a no-op.
+func (l *StreamLimit) Open(id uint32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.open[id]; ok {
+		return nil
+	}
+	if len(l.open) >= l.max {
+		return fmt.Errorf("%w: stream %d beyond the limit of %d", ErrorTooManyStreams, id, l.max)
+	}
+	l.open[id] = struct{}{}
+	return nil
+}
+
+// Close releases stream id, making room for another.
+func (l *StreamLimit) Close(id uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.open, id)
+}
+
+// Len returns the number of streams currently admitted.
+func (l *StreamLimit) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.open)
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestNegotiateMaxStreams(t *testing.T) {
+	handshake := func(fields ...HandshakeField) HandshakeMessage {
+		pack := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}, fields...)
+		got, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Data.Msg.(HandshakeMessage)
+	}
+
+	if a, b := NegotiateMaxStreams(8, handshake(MaxStreamsField(3))), NegotiateMaxStreams(3, handshake(MaxStreamsField(8))); a != 3 || b != 3 {
+		t.Fatalf("peers settled on %d and %d streams, expected 3", a, b)
+	}
+	if n := NegotiateMaxStreams(8, handshake()); n != 8 {
+		t.Fatalf("expected the local limit with a peer announcing none, got %d", n)
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	l := NewStreamLimit(3)
+	for id := uint32(1); id <= 3; id++ {
+		if err := l.Open(id); err != nil {
+			t.Fatalf("stream %d: %v", id, err)
+		}
+	}
+	if err := l.Open(2); err != nil {
+		t.Fatalf("reopening an admitted stream: %v", err)
+	}
+	if err := l.Open(4); !errors.Is(err, ErrorTooManyStreams) {
+		t.Fatalf("expected %v, got %v", ErrorTooManyStreams, err)
+	}
+
+	l.Close(1)
+	if err := l.Open(4); err != nil {
+		t.Fatalf("stream 4 after a close: %v", err)
+	}
+	if l.Len() != 3 {
+		t.Fatalf("expected 3 open streams, got %d", l.Len())
+	}
+}
@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+)
+
+type (
+	// StreamDecodeFunc turns the message of a packet, still to be read,
+	// into a Message that implements io.Reader over its payload.
+	StreamDecodeFunc func(body *StreamMessage) (Message, error)
+
+	// StreamMessage reads the message of a type registered with
+	// RegisterStreamType. The bytes are consumed from the stream the packet
+	// was decoded from, so the message has to be read before the next
+	// packet is; a Decoder skips what is left unread.
+	StreamMessage struct {
+		r    io.Reader
+		n    int
+		left int
+	}
+)
+
+func newStreamMessage(r io.Reader, n int) *StreamMessage {
+	return &StreamMessage{
+		r:    r,
+		n:    n,
+		left: n,
+	}
+}
+
+// Read reads the next bytes of the message. A stream ending inside the
+// message fails with io.ErrUnexpectedEOF.
+func (m *StreamMessage) Read(p []byte) (int, error) {
+	if m.left == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > m.left {
+		p = p[:m.left]
+	}
+	n, err := m.r.Read(p)
+	m.left -= n
+	if err == io.EOF && m.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Len returns the size of the whole message, read or not.
+func (m *StreamMessage) Len() uint16 {
+	return uint16(m.n)
+}
+
+// WriteTo writes the bytes of the message not read yet.
+func (m *StreamMessage) WriteTo(w io.Writer) (int64, error) {
+	// hide WriteTo from io.Copy, it would call back here
+	return io.Copy(w, struct{ io.Reader }{m})
+}
+
+// decodeStream hands body to the stream decoder of pack's type.
+func decodeStream(pack *Packet, decode StreamDecodeFunc, body *StreamMessage) (*Packet, error) {
+	msg, err := decode(body)
+	if err != nil {
+		return nil, &DecodeError{Type: pack.Data.Type, Err: err}
+	}
+	if _, ok := msg.(io.Reader); !ok {
+		return nil, &DecodeError{
+			Type: pack.Data.Type,
+			Err:  fmt.Errorf("%w: stream decoder returned %T, not a reader", ErrorInvalidPayload, msg),
+		}
+	}
+	pack.Data.Msg = msg
+	return pack, nil
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRegisterStreamType(t *testing.T) {
+	const typeStream uint8 = 202
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	payload := make([]byte, 60000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	data, err := Encode(newPacket(typeStream, RawMessage(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReader{r: bytes.NewReader(data)}
+	pack, err := Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.n != len(data)-len(payload) {
+		t.Fatalf("expected only the header to be read, got %d bytes", r.n)
+	}
+	msg, ok := AsMessage[*StreamMessage](pack)
+	if !ok || int(msg.Len()) != len(payload) {
+		t.Fatalf("unexpected %#v", pack.Data.Msg)
+	}
+
+	// the payload is read a chunk at a time, never all at once
+	chunk := make([]byte, 4096)
+	var read []byte
+	for {
+		before := r.n
+		n, err := msg.Read(chunk)
+		if r.n-before > len(chunk) {
+			t.Fatalf("read %d bytes from the stream for a %d byte chunk", r.n-before, len(chunk))
+		}
+		read = append(read, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(read, payload) {
+		t.Fatal("payload differs")
+	}
+}
+
+func TestRegisterStreamTypeDecoder(t *testing.T) {
+	const typeStream uint8 = 203
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, nil)
+	checked := newPacket(typeStream, RawMessage("checksummed"))
+	checked.SetChecksum(ChecksumCRC32C)
+	for _, pack := range []*Packet{
+		newPacket(typeStream, RawMessage("left unread")),
+		checked,
+		NewOkMessage(),
+	} {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := NewDecoder(&stream, nil)
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	// the first message is skipped, the checksummed one is verified before
+	// it is handed out
+	pack, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(pack.Data.Msg.(io.Reader)); err != nil || string(got) != "checksummed" {
+		t.Fatalf("expected the checksummed message, got %q, %v", got, err)
+	}
+	if pack, err = dec.Decode(); err != nil || pack.Data.Type != TypeOk {
+		t.Fatalf("expected an ok, got %#v, %v", pack, err)
+	}
+}
+
+func TestStreamMessageTruncated(t *testing.T) {
+	const typeStream uint8 = 204
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	data, err := Encode(newPacket(typeStream, RawMessage("truncated message")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := Decode(bytes.NewReader(data[:len(data)-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(pack.Data.Msg.(io.Reader)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// pingPayload marks a query as a ping, which the peer answers right away,
+// see PingReply.
+var pingPayload = []byte("ping")
+
+type (
+	// SweepPeer is a peer reached by PingSweep: Send writes a packet to it
+	// and the responses read from it are delivered to Queries, whose
+	// Timeout bounds the wait for this peer.
+	SweepPeer struct {
+		Name    string
+		Send    func(*Packet) error
+		Queries *Queries
+	}
+
+	// SweepResult is the outcome of pinging one peer: the round trip time,
+	// or the error that left it unanswered, ErrorQueryTimeout when the peer
+	// did not respond in time.
+	SweepResult struct {
+		Peer string
+		RTT  time.Duration
+		Err  error
+	}
+)
+
+// PingReply returns the response answering m when it is a ping, nil
+// otherwise. Peers that take part in sweeps pass every query through it.
+func PingReply(m QueryMessage) *Packet {
+	if !bytes.Equal(m.Payload(), pingPayload) {
+		return nil
+	}
+	return NewResponseMessage(m.ID(), pingPayload)
+}
+
+// PingSweep pings every peer at once and returns a result per peer, in the
+// order of peers, once each has answered or timed out.
+func PingSweep(peers []SweepPeer) []SweepResult {
+	results := make([]SweepResult, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer SweepPeer) {
+			defer wg.Done()
+			start := time.Now()
+			_, err := peer.Queries.Ask(peer.Send, pingPayload)
+			results[i] = SweepResult{Peer: peer.Name, Err: err}
+			if err == nil {
+				results[i].RTT = time.Since(start)
+			}
+		}(i, peer)
+	}
+	wg.Wait()
+	return results
+}
+
+// Responsive reports whether the peer answered the ping.
+func (r SweepResult) Responsive() bool {
+	return r.Err == nil
+}
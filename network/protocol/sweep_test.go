@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// pingPeer answers pings after delay through PingReply, or never.
+func pingPeer(t *testing.T, name string, delay time.Duration, answer bool) SweepPeer {
+	q := NewQueries(50 * time.Millisecond)
+	send := func(pack *Packet) error {
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			return err
+		}
+		reply := PingReply(decoded.Data.Msg.(QueryMessage))
+		if reply == nil {
+			t.Errorf("%s: query not recognized as a ping", name)
+			return nil
+		}
+		if !answer {
+			return nil
+		}
+		go func() {
+			time.Sleep(delay)
+			response, err := roundTrip(reply)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			q.Deliver(response.Data.Msg.(ResponseMessage))
+		}()
+		return nil
+	}
+	return SweepPeer{Name: name, Send: send, Queries: q}
+}
+
+func TestPingSweep(t *testing.T) {
+	errDown := errors.New("link down")
+	peers := []SweepPeer{
+		pingPeer(t, "fast", 0, true),
+		pingPeer(t, "silent", 0, false),
+		pingPeer(t, "slow", 10*time.Millisecond, true),
+		{Name: "broken", Send: func(*Packet) error { return errDown }, Queries: NewQueries(time.Second)},
+	}
+
+	start := time.Now()
+	results := PingSweep(peers)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("peers not pinged concurrently, sweep took %v", elapsed)
+	}
+
+	for i, want := range []struct {
+		responsive bool
+		err        error
+	}{{true, nil}, {false, ErrorQueryTimeout}, {true, nil}, {false, errDown}} {
+		r := results[i]
+		if r.Peer != peers[i].Name || r.Responsive() != want.responsive || r.Err != want.err {
+			t.Fatalf("%s: unexpected result %+v", peers[i].Name, r)
+		}
+		if want.responsive && r.RTT <= 0 {
+			t.Fatalf("%s: responsive without round trip time", r.Peer)
+		}
+		if !want.responsive && r.RTT != 0 {
+			t.Fatalf("%s: round trip time %v without response", r.Peer, r.RTT)
+		}
+	}
+	if results[2].RTT < 10*time.Millisecond {
+		t.Fatalf("slow peer answered in %v", results[2].RTT)
+	}
+
+	if PingReply(NewQueryMessage(1, []byte("route?")).Data.Msg.(QueryMessage)) != nil {
+		t.Fatal("application query answered as a ping")
+	}
+}
@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrorMalformedText = errors.New("malformed packet text")
+)
+
+// EncodeString returns the wire form of pack as standard base64, safe to
+// paste into configs, issues or tests.
+func EncodeString(pack *Packet) (string, error) {
+	data, err := Encode(pack)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeString decodes a packet produced by EncodeString, or by an Encoder
+// when key is set. Surrounding whitespace is ignored; anything else that
+// is not a single packet is an error.
+func DecodeString(s string, key []byte) (*Packet, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorMalformedText, err)
+	}
+
+	pack, err := decode(bytes.NewReader(data), decodeOptions{key: key})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDatagram(pack, len(data)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorMalformedText, err)
+	}
+	return pack, nil
+}
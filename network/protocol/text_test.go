@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestEncodeDecodeString(t *testing.T) {
+	for _, pack := range []*Packet{
+		NewOkMessage(),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		NewTransferMessage([]byte("hello")),
+	} {
+		s, err := EncodeString(pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeString(s+"\n", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", typeName(pack.Data.Type), err)
+		}
+		samePacket(t, pack, decoded)
+	}
+}
+
+func TestDecodeStringSealed(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(NewTransferMessage([]byte("hello"))); err != nil {
+		t.Fatal(err)
+	}
+	pack, err := DecodeString(base64.StdEncoding.EncodeToString(stream.Bytes()), rRecv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pack.Data.Msg.(TransferMessage)) != "hello" {
+		t.Fatalf("unexpected payload %q", pack.Data.Msg)
+	}
+}
+
+func TestDecodeStringMalformed(t *testing.T) {
+	s, err := EncodeString(NewOkMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(s)
+	trailing := base64.StdEncoding.EncodeToString(append(data, 0))
+
+	for _, s := range []string{"not base64!", s[:len(s)-1], trailing} {
+		if _, err := DecodeString(s, nil); !errors.Is(err, ErrorMalformedText) {
+			t.Errorf("%q: expected %v, got %v", s, ErrorMalformedText, err)
+		}
+	}
+}
+
+func TestDecodeStringStreamType(t *testing.T) {
+	const typeStream uint8 = 208
+	defer unregisterType(typeStream)
+	RegisterStreamType(typeStream, "stream", nil)
+
+	s, err := EncodeString(newPacket(typeStream, RawMessage("streamed payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := DecodeString(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := AsMessage[*StreamMessage](pack)
+	if !ok {
+		t.Fatalf("unexpected %#v", pack.Data.Msg)
+	}
+	if payload, err := io.ReadAll(msg); err != nil || string(payload) != "streamed payload" {
+		t.Fatalf("unexpected payload %q, %v", payload, err)
+	}
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// FlagTimestamp marks a body carrying its send time right after the route.
+// Receivers subtract it from their own clock to estimate the one-way delay,
+// which is only as accurate as the two clocks are synchronized.
+const FlagTimestamp uint8 = 1 << 6
+
+// timestampLen is the wire size of a send time, in unix nanoseconds.
+const timestampLen = 8
+
+var (
+	ErrorInvalidTimestamp = errors.New("timestamp not allowed on handshake packets or before flags version")
+)
+
+// SetSentAt stamps p with its send time, upgrading its header to
+// FlagsVersion when needed. The zero time removes the stamp. An Encoder
+// with timestamps enabled stamps every packet it writes instead, see
+// Encoder.SetTimestamps.
+func (p *Packet) SetSentAt(sent time.Time) {
+	p.Data.SentAt = sent
+	if sent.IsZero() {
+		p.Head.Flags &^= FlagTimestamp
+	} else {
+		if p.Head.Version < FlagsVersion {
+			p.Head.Version = FlagsVersion
+		}
+		p.Head.Flags |= FlagTimestamp
+	}
+	p.Head.Length = p.Data.Len() + p.Head.Checksum().Len()
+}
+
+// OneWayDelay returns the time b took from its sender to received, false
+// when b carries no send time. It assumes synchronized clocks: a skew
+// between the peers adds to the delay and may even make it negative.
+func (b Body) OneWayDelay(received time.Time) (time.Duration, bool) {
+	if !b.hasTimestamp() {
+		return 0, false
+	}
+	return received.Sub(b.SentAt), true
+}
+
+// hasTimestamp reports whether the body carries a send time on the wire.
+func (b Body) hasTimestamp() bool {
+	return !b.SentAt.IsZero()
+}
+
+// appendTimestamp appends the wire form of the send time of b.
+func (b Body) appendTimestamp(dst []byte) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(b.SentAt.UnixNano()))
+}
+
+func parseTimestamp(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
+
+// checkTimestamp verifies that a send time is only carried where the format
+// allows it. Handshakes are excluded: they are read before the session
+// settles on a version.
+func checkTimestamp(head Header, t uint8) error {
+	if head.Flags&FlagTimestamp != 0 && (head.Version < FlagsVersion || t == TypeHandshake) {
+		return ErrorInvalidTimestamp
+	}
+	return nil
+}
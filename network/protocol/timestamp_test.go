@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestEncoderTimestamps(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	clock := &fakeClock{t: time.Unix(1000, 500)}
+
+	var stream bytes.Buffer
+	enc := NewEncoder(&stream, iSend)
+	enc.now = clock.Now
+	enc.SetTimestamps(true)
+	packets := []*Packet{
+		NewTransferMessage([]byte("tunnelled ip packet")),
+		NewHeartbeatMessage(net.IPv4(10, 0, 0, 1)),
+		NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}),
+	}
+	for _, pack := range packets {
+		if err := enc.Encode(pack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if packets[0].Data.hasTimestamp() {
+		t.Fatal("encoding stamped the caller's packet")
+	}
+
+	dec := NewDecoder(&stream, rRecv)
+	for _, want := range packets {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want.Data.Type == TypeHandshake {
+			if got.Data.hasTimestamp() || got.Head.Flags&FlagTimestamp != 0 {
+				t.Fatalf("handshake stamped with %v", got.Data.SentAt)
+			}
+			continue
+		}
+		if !got.Data.SentAt.Equal(clock.Now()) {
+			t.Fatalf("%s: expected send time %v, got %v", PacketType(want.Data.Type), clock.Now(), got.Data.SentAt)
+		}
+		if delay, ok := got.Data.OneWayDelay(clock.Now().Add(30 * time.Millisecond)); !ok || delay != 30*time.Millisecond {
+			t.Fatalf("%s: expected a 30ms delay, got %v", PacketType(want.Data.Type), delay)
+		}
+		if err := VerifyInvariants(got); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTimestampAbsentByDefault(t *testing.T) {
+	pack := NewTransferMessage([]byte("tunnelled ip packet"))
+	got, err := encodeDecode(t, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Head.Flags&FlagTimestamp != 0 || got.Data.hasTimestamp() {
+		t.Fatalf("unexpected send time %v with flags %#x", got.Data.SentAt, got.Head.Flags)
+	}
+	if _, ok := got.Data.OneWayDelay(time.Now()); ok {
+		t.Fatal("delay reported without send time")
+	}
+
+	pack.SetSentAt(time.Unix(1000, 0))
+	stamped, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := Encode(NewTransferMessage([]byte("tunnelled ip packet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stamped) != len(plain)+timestampLen+1 {
+		t.Fatalf("expected the flags byte and %d timestamp bytes, got %d more bytes", timestampLen, len(stamped)-len(plain))
+	}
+	pack.SetSentAt(time.Time{})
+	if data, err := Encode(pack); err != nil || len(data) != len(plain)+1 {
+		t.Fatalf("removed send time still encoded: %d bytes, %v", len(data), err)
+	}
+}
+
+func TestTimestampAuthenticated(t *testing.T) {
+	iSend, _, _, rRecv := testDirectionKeys()
+	pack := NewTransferMessage([]byte("tunnelled ip packet"))
+	pack.SetSentAt(time.Unix(1000, 0))
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(pack); err != nil {
+		t.Fatal(err)
+	}
+	wire := stream.Bytes()
+	// the send time follows the 4 byte header and the type
+	wire[5+timestampLen-1] ^= 1
+	if _, err := NewDecoder(&stream, rRecv).Decode(); err == nil {
+		t.Fatal("tampered send time accepted")
+	}
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const batchLenSize = 2
+
+type (
+	// TransferBatchMessage coalesces several tunnel frames into one packet:
+	// a uint16 frame count followed by uint16 length-prefixed frames.
+	TransferBatchMessage []byte
+)
+
+// NewTransferBatchMessage packs frames into a single packet, failing with
+// ErrorPacketTooLarge when they do not fit the maximum message size.
+func NewTransferBatchMessage(frames [][]byte) (*Packet, error) {
+	size := batchLenSize
+	for _, frame := range frames {
+		size += batchLenSize + len(frame)
+	}
+	if size > MaxMessageLen || len(frames) > 1<<16-1 {
+		return nil, ErrorPacketTooLarge
+	}
+
+	msg := make(TransferBatchMessage, batchLenSize, size)
+	binary.BigEndian.PutUint16(msg, uint16(len(frames)))
+	for _, frame := range frames {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(frame)))
+		msg = append(msg, frame...)
+	}
+
+	body := Body{
+		Type: TypeTransferBatch,
+		Msg:  msg,
+	}
+	return &Packet{
+		Head: Header{
+			Length:  body.Len(),
+			Version: CurrentVersion,
+		},
+		Data: body,
+	}, nil
+}
+
+func (m TransferBatchMessage) Len() uint16 {
+	return uint16(len(m))
+}
+
+func (m TransferBatchMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+// Count returns the number of frames in the batch.
+func (m TransferBatchMessage) Count() int {
+	return int(binary.BigEndian.Uint16(m))
+}
+
+// Frames returns the individual frames. They share memory with m.
+func (m TransferBatchMessage) Frames() [][]byte {
+	frames, _ := splitBatch(m)
+	return frames
+}
+
+func splitBatch(data []byte) ([][]byte, error) {
+	if len(data) < batchLenSize {
+		return nil, fmt.Errorf("%w: batch without frame count", ErrorUnableToReadMessage)
+	}
+
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[batchLenSize:]
+	// every frame takes at least its length prefix
+	if count > len(data)/batchLenSize {
+		return nil, fmt.Errorf("%w: %d batch frames in %d bytes", ErrorUnableToReadMessage, count, len(data))
+	}
+	frames := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if len(data) < batchLenSize {
+			return nil, fmt.Errorf("%w: batch frame %d without length", ErrorUnableToReadMessage, i)
+		}
+		frameLen := int(binary.BigEndian.Uint16(data))
+		data = data[batchLenSize:]
+		if len(data) < frameLen {
+			return nil, fmt.Errorf("%w: batch frame %d truncated", ErrorUnableToReadMessage, i)
+		}
+		frames = append(frames, data[:frameLen:frameLen])
+		data = data[frameLen:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes after batch", ErrorUnableToReadMessage, len(data))
+	}
+	return frames, nil
+}
+
+func decodeTransferBatch(data []byte) (Message, error) {
+	if _, err := splitBatch(data); err != nil {
+		return nil, err
+	}
+	return TransferBatchMessage(data), nil
+}
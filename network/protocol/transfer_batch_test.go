@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTransferBatchRoundTrip(t *testing.T) {
+	for _, frames := range [][][]byte{
+		{[]byte("first"), bytes.Repeat([]byte{2}, 300), {}},
+		{[]byte("single")},
+		{},
+	} {
+		pack, err := NewTransferBatchMessage(frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := encodeDecode(t, pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, ok := AsMessage[TransferBatchMessage](decoded)
+		if !ok {
+			t.Fatalf("expected transfer batch, got %T", decoded.Data.Msg)
+		}
+		if msg.Count() != len(frames) {
+			t.Fatalf("expected %d frames, got %d", len(frames), msg.Count())
+		}
+		for i, frame := range msg.Frames() {
+			if !bytes.Equal(frame, frames[i]) {
+				t.Fatalf("frame %d: expected %v, got %v", i, frames[i], frame)
+			}
+		}
+	}
+}
+
+func TestTransferBatchTooLarge(t *testing.T) {
+	frames := [][]byte{make([]byte, 40000), make([]byte, 40000)}
+	if _, err := NewTransferBatchMessage(frames); err != ErrorPacketTooLarge {
+		t.Fatalf("expected %v, got %v", ErrorPacketTooLarge, err)
+	}
+}
+
+func TestTransferBatchMalformed(t *testing.T) {
+	// claims two frames but only carries one
+	data := []byte{0, 6, CurrentVersion, TypeTransferBatch, 0, 2, 0, 1, 'x'}
+	if _, err := Decode(bytes.NewReader(data)); !errors.Is(err, ErrorUnableToReadMessage) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadMessage, err)
+	}
+
+	// a count the bytes cannot hold is rejected before allocating
+	if _, err := splitBatch([]byte{0xff, 0xff, 0, 0}); !errors.Is(err, ErrorUnableToReadMessage) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadMessage, err)
+	}
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// decodeGeneric forces a packet through the generic decode path.
+func decodeGeneric(r io.Reader) (*Packet, error) {
+	pack := new(Packet)
+	if err := readHeader(r, pack, make([]byte, maxHeaderLen+1)); err != nil {
+		return nil, err
+	}
+	return decodeBody(r, pack, decodeOptions{})
+}
+
+func TestDecodeTransferFastMatchesGeneric(t *testing.T) {
+	data, err := Encode(NewTransferMessage(bytes.Repeat([]byte{9}, 1400)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{
+		data,
+		data[:4+10],
+		data[:4+bodyVectorLen+100],
+		{0, 17, CurrentVersion, TypeTransfer},
+		append([]byte{0, 17, CurrentVersion, TypeTransfer}, make([]byte, bodyVectorLen)...),
+		{0, 5, CurrentVersion, TypeTransfer, 1, 2, 3, 4},
+	}
+
+	for i, input := range inputs {
+		fast, errFast := Decode(bytes.NewReader(input))
+		generic, errGeneric := decodeGeneric(bytes.NewReader(input))
+		if fmt.Sprint(errFast) != fmt.Sprint(errGeneric) {
+			t.Errorf("input %d: fast path error %v, generic %v", i, errFast, errGeneric)
+			continue
+		}
+		if errFast == nil && !samePacket(t, fast, generic) {
+			t.Errorf("input %d: fast path decoded %+v, generic %+v", i, fast, generic)
+		}
+	}
+}
+
+func TestDecodeTransferFastPathDisabledOnOverride(t *testing.T) {
+	defer RegisterType(TypeTransfer, "transfer", decodeTransfer, validateTransfer)
+	defer transferFastPath.Store(true)
+
+	RegisterType(TypeTransfer, "transfer", decodeTransfer, func(Message) error {
+		return fmt.Errorf("rejected")
+	})
+	data, _ := Encode(NewTransferMessage([]byte{1}))
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("overridden transfer validator was bypassed")
+	}
+}
+
+func benchmarkTransferDecode(b *testing.B, decode func(io.Reader) (*Packet, error)) {
+	data, err := Encode(NewTransferMessage(bytes.Repeat([]byte{9}, 1400)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if _, err = decode(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTransferFast(b *testing.B) {
+	benchmarkTransferDecode(b, Decode)
+}
+
+func BenchmarkDecodeTransferGeneric(b *testing.B) {
+	benchmarkTransferDecode(b, decodeGeneric)
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	TransportUDP TransportProtocol = iota + 1
+	TransportTCP
+	TransportRelay
+)
+
+// transportEntryLen is the fixed part of an advertised transport: the
+// protocol and the endpoint length.
+const transportEntryLen = 2
+
+var (
+	ErrorMalformedTransport = errors.New("malformed transport")
+)
+
+type (
+	// TransportProtocol identifies how an advertised endpoint is reached.
+	TransportProtocol uint8
+
+	// Transport is an endpoint a node accepts connections on, advertised in
+	// the HandshakeTransports field so peers can pick the best path.
+	Transport struct {
+		Protocol TransportProtocol
+		Endpoint string
+	}
+)
+
+func (p TransportProtocol) String() string {
+	switch p {
+	case TransportUDP:
+		return "udp"
+	case TransportTCP:
+		return "tcp"
+	case TransportRelay:
+		return "relay"
+	}
+	return fmt.Sprintf("transport %d", uint8(p))
+}
+
+// TransportsField builds the handshake field advertising transports. Each
+// entry is encoded as the protocol byte, the endpoint length byte and the
+// endpoint.
+func TransportsField(transports ...Transport) (HandshakeField, error) {
+	var value []byte
+	for _, t := range transports {
+		if t.Protocol == 0 || t.Endpoint == "" || len(t.Endpoint) > 255 {
+			return HandshakeField{}, fmt.Errorf("%w: %s %q", ErrorMalformedTransport, t.Protocol, t.Endpoint)
+		}
+		value = append(value, byte(t.Protocol), byte(len(t.Endpoint)))
+		value = append(value, t.Endpoint...)
+	}
+	return HandshakeField{Tag: HandshakeTransports, Value: value}, nil
+}
+
+// Transports returns the transports the peer advertised, nil if it sent
+// none. Protocols unknown to this node are returned as well, so the caller
+// decides which it can use.
+func (m HandshakeMessage) Transports() ([]Transport, error) {
+	data, ok := m.Field(HandshakeTransports)
+	if !ok {
+		return nil, nil
+	}
+
+	var transports []Transport
+	for len(data) > 0 {
+		if len(data) < transportEntryLen {
+			return nil, fmt.Errorf("%w: truncated entry", ErrorMalformedTransport)
+		}
+		protocol, endpointLen := TransportProtocol(data[0]), int(data[1])
+		data = data[transportEntryLen:]
+		if protocol == 0 || endpointLen == 0 || len(data) < endpointLen {
+			return nil, fmt.Errorf("%w: %s endpoint of %d bytes", ErrorMalformedTransport, protocol, endpointLen)
+		}
+		transports = append(transports, Transport{Protocol: protocol, Endpoint: string(data[:endpointLen])})
+		data = data[endpointLen:]
+	}
+	return transports, nil
+}
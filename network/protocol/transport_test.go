@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/meshbird/meshbird/secure"
+)
+
+func TestHandshakeTransports(t *testing.T) {
+	transports := []Transport{
+		{Protocol: TransportUDP, Endpoint: "203.0.113.7:7001"},
+		{Protocol: TransportTCP, Endpoint: "[2001:db8::7]:7001"},
+		{Protocol: TransportRelay, Endpoint: "relay.example.net:443"},
+	}
+	field, err := TransportsField(transports...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := encodeDecode(t, NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}, field))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pack.Data.Msg.(HandshakeMessage).Transports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, transports) {
+		t.Fatalf("expected %v, got %v", transports, got)
+	}
+}
+
+func TestHandshakeMalformedTransport(t *testing.T) {
+	if _, err := TransportsField(Transport{Protocol: TransportUDP}); !errors.Is(err, ErrorMalformedTransport) {
+		t.Fatalf("expected %v, got %v", ErrorMalformedTransport, err)
+	}
+
+	// the endpoint announces more bytes than the entry carries
+	field := HandshakeField{Tag: HandshakeTransports, Value: []byte{byte(TransportUDP), 16, '1', '0'}}
+	pack := NewHandshakePacket(bytes.Repeat([]byte{1}, sessionKeyLen), &secure.NetworkSecret{}, field)
+	if _, err := pack.Data.Msg.(HandshakeMessage).Transports(); !errors.Is(err, ErrorMalformedTransport) {
+		t.Fatalf("expected %v, got %v", ErrorMalformedTransport, err)
+	}
+	if _, err := encodeDecode(t, pack); !errors.Is(err, ErrorInvalidPayload) {
+		t.Fatalf("expected %v, got %v", ErrorInvalidPayload, err)
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrorWouldBlock = errors.New("write would block")
+)
+
+// TryWriter is implemented by writers that can refuse a write instead of
+// blocking until there is room for it.
+type TryWriter interface {
+	TryWrite(p []byte) (int, error)
+}
+
+// TryEncodeAndWrite is EncodeAndWrite for writers that apply back-pressure.
+// When w is a TryWriter, a full writer fails with ErrorWouldBlock and the
+// caller decides whether to drop the packet or retry later. Any other writer
+// is written as by EncodeAndWrite. Packets for an Encoder are queued with
+// the same back-pressure by Sender.Send.
+func TryEncodeAndWrite(w io.Writer, pack *Packet) error {
+	tw, ok := w.(TryWriter)
+	if !ok {
+		return EncodeAndWrite(w, pack)
+	}
+
+	reply, errEncode := Encode(pack)
+	if errEncode != nil {
+		logger.Error("error on encoding, %v", errEncode)
+		return errEncode
+	}
+
+	if _, err := tw.TryWrite(reply); err != nil {
+		if err != ErrorWouldBlock {
+			logger.Error("error on write, %v", err)
+		}
+		return err
+	}
+
+	reportEncoded(pack, len(reply))
+	return nil
+}
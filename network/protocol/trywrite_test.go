@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// boundedWriter is a TryWriter holding at most size frames.
+type boundedWriter struct {
+	frames [][]byte
+	size   int
+}
+
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	return w.TryWrite(p)
+}
+
+func (w *boundedWriter) TryWrite(p []byte) (int, error) {
+	if len(w.frames) >= w.size {
+		return 0, ErrorWouldBlock
+	}
+	w.frames = append(w.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestTryEncodeAndWriteFullQueue(t *testing.T) {
+	w := &boundedWriter{size: 2}
+	for i := 0; i < 2; i++ {
+		if err := TryEncodeAndWrite(w, NewTransferMessage([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := TryEncodeAndWrite(w, NewTransferMessage([]byte{2})); err != ErrorWouldBlock {
+		t.Fatalf("expected %v, got %v", ErrorWouldBlock, err)
+	}
+	if len(w.frames) != 2 {
+		t.Fatalf("expected 2 queued frames, got %d", len(w.frames))
+	}
+
+	// draining a frame makes room again
+	first := w.frames[0]
+	w.frames = w.frames[1:]
+	if err := TryEncodeAndWrite(w, NewTransferMessage([]byte{3})); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0, 1, 3}
+	frames := append([][]byte{first}, w.frames...)
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d frames, got %d", len(want), len(frames))
+	}
+	for i, frame := range frames {
+		pack, err := Decode(bytes.NewReader(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg, ok := AsMessage[TransferMessage](pack); !ok || msg[0] != want[i] {
+			t.Fatalf("frame %d: unexpected %#v", i, pack)
+		}
+	}
+}
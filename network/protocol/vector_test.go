@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestHeartbeatOmitsVector(t *testing.T) {
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	pack.Data.Vector = randomBytes(bodyVectorLen)
+
+	data, err := Encode(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 3+1+net.IPv4len {
+		t.Fatalf("expected heartbeat without vector, got %d bytes", len(data))
+	}
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Data.Vector != nil || !bytes.Equal(decoded.Data.Msg.(HeartbeatMessage), pack.Data.Msg.(HeartbeatMessage)) {
+		t.Fatalf("unexpected heartbeat %+v", decoded.Data)
+	}
+}
+
+func TestSetVector(t *testing.T) {
+	SetVector(TypeHeartbeat, true)
+	defer SetVector(TypeHeartbeat, false)
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	pack := NewHeartbeatMessage(net.IPv4(10, 0, 0, 1))
+	if _, err := Encode(pack); !errors.Is(err, ErrorUnableToReadVector) {
+		t.Fatalf("expected %v, got %v", ErrorUnableToReadVector, err)
+	}
+
+	pack.Data.Vector = randomBytes(bodyVectorLen)
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(pack); err != nil {
+		t.Fatal(err)
+	}
+	if stream.Len() != 3+1+bodyVectorLen+net.IPv4len+16 { // plus the GCM tag
+		t.Fatalf("expected sealed heartbeat with vector, got %d bytes", stream.Len())
+	}
+	decoded, err := NewDecoder(&stream, rRecv).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Data.Vector, pack.Data.Vector) ||
+		!bytes.Equal(decoded.Data.Msg.(HeartbeatMessage), pack.Data.Msg.(HeartbeatMessage)) {
+		t.Fatalf("unexpected heartbeat %+v", decoded.Data)
+	}
+}
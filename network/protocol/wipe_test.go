@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestUnsealWipesScratch(t *testing.T) {
+	SetVector(TypePeerInfo, true)
+	defer SetVector(TypePeerInfo, false)
+	iSend, _, _, rRecv := testDirectionKeys()
+
+	pack := NewPeerTableMessage(net.IPv4(10, 0, 0, 1), testPeerTable(10))
+	pack.Data.Vector = randomBytes(bodyVectorLen)
+	pack.SetCompressed(CapabilityDictCompression)
+	plain := append([]byte(nil), pack.Data.Msg.(PeerInfoMessage)...)
+
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream, iSend).Encode(pack); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+	head := int(pack.Head.Len()) + 1 + bodyVectorLen
+
+	// open in place the way decodeBody does, the buffer then holds the
+	// compressed plaintext until the message is decompressed
+	scratch := append([]byte(nil), data[head:]...)
+	decoded := &Packet{Head: pack.Head, Data: Body{Type: TypePeerInfo, Vector: data[head-bodyVectorLen : head]}}
+	message, err := unsealMessage(decoded, scratch, decodeOptions{key: rRecv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, plain) {
+		t.Fatal("unexpected message")
+	}
+	opened := scratch[:len(scratch)-gcmTagLen]
+	if !bytes.Equal(opened, make([]byte, len(opened))) {
+		t.Fatalf("scratch not wiped: %v", opened)
+	}
+}
@@ -12,19 +12,19 @@ func EncryptIV(decrypted []byte, key []byte) ([]byte, error) {
 
 	c, err := aes.NewCipher(key)
 	if err != nil {
-		log.Println("[CRYPT][AES][ENC] Problem %s", err.Error())
+		log.Printf("[CRYPT][AES][ENC] Problem %s", err.Error())
 		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		log.Println("[CRYPT][AES][ENC] Problem %s", err.Error())
+		log.Printf("[CRYPT][AES][ENC] Problem %s", err.Error())
 		return nil, err
 	}
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		log.Println("[CRYPT][AES][NONCE] Problem %s", err.Error())
+		log.Printf("[CRYPT][AES][NONCE] Problem %s", err.Error())
 		return nil, err
 	}
 
@@ -36,19 +36,19 @@ func DecryptIV(ciphertext []byte, key []byte) ([]byte, error) {
 
 	c, err := aes.NewCipher(key)
 	if err != nil {
-		log.Println("[DECRYPT][AES] Problem %s", err.Error())
+		log.Printf("[DECRYPT][AES] Problem %s", err.Error())
 		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		log.Println("[DECRYPT][AES] Problem %s", err.Error())
+		log.Printf("[DECRYPT][AES] Problem %s", err.Error())
 		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
-		log.Println("[DECRYPT][AES] Problem %s", "Cyphertext too short")
+		log.Printf("[DECRYPT][AES] Problem %s", "Cyphertext too short")
 		return nil, err
 	}
 
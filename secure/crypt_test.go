@@ -1,6 +1,7 @@
 package secure
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"testing"
@@ -20,7 +21,7 @@ func BenchmarkEncryptAesCbc(b *testing.B) {
 		b.Fatal(err)
 	}
 	enc := cipher.NewCBCEncrypter(aesCipher, iv)
-	decrypted := PKCS5Padding(original, aes.BlockSize)
+	decrypted := pkcs5Padding(original, aes.BlockSize)
 	dataLen := len(decrypted)
 	encrypted := make([]byte, len(decrypted))
 	t := time.Now()
@@ -42,6 +43,7 @@ func BenchmarkDescryptAesCbc(b *testing.B) {
 	if err != nil {
 		b.Fatal(err)
 	}
+	encrypted = pkcs5Padding(encrypted, aes.BlockSize)
 	counter := 0
 	dataLen := len(encrypted)
 
@@ -63,6 +65,12 @@ func BenchmarkDescryptAesCbc(b *testing.B) {
 	b.Logf("decryption speed: %.2f Mbit/s", float64(counter)*8/ts.Seconds()/1024/1024)
 }
 
+// pkcs5Padding pads data to a multiple of blockSize as CBC requires.
+func pkcs5Padding(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
 func BenchmarkEncryptAESGCM(b *testing.B) {
 	key := make([]byte, 32)
 	a, _ := aes.NewCipher(key)
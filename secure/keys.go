@@ -0,0 +1,18 @@
+package secure
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+)
+
+// DeriveSessionKey derives a length byte key from the shared secret agreed
+// in the handshake using HKDF with SHA-256.
+//
+// Never use one key for both directions of a session: derive one key per
+// direction by passing a different info string for each, so that a packet
+// can not be reflected back at its sender.
+//
+// It fails when length exceeds 255 * sha256.Size, the most HKDF can derive.
+func DeriveSessionKey(sharedSecret, salt, info []byte, length int) ([]byte, error) {
+	return hkdf.Key(sha256.New, sharedSecret, salt, string(info), length)
+}
@@ -0,0 +1,66 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveSessionKeyIsDeterministic(t *testing.T) {
+	secret := []byte("shared secret")
+	salt := []byte("salt")
+
+	a, err := DeriveSessionKey(secret, salt, []byte("meshbird"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DeriveSessionKey(secret, salt, []byte("meshbird"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatal("same input derived different keys")
+	}
+	if len(a) != 32 {
+		t.Fatalf("expected 32 byte key, got %d", len(a))
+	}
+}
+
+func TestDeriveSessionKeyDependsOnInfo(t *testing.T) {
+	secret := []byte("shared secret")
+	salt := []byte("salt")
+
+	send, err := DeriveSessionKey(secret, salt, []byte("initiator to responder"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv, err := DeriveSessionKey(secret, salt, []byte("responder to initiator"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(send, recv) {
+		t.Fatal("different info strings derived the same key")
+	}
+}
+
+// RFC 5869, test case 1
+func TestDeriveSessionKeyVector(t *testing.T) {
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	okm, _ := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+
+	key, err := DeriveSessionKey(ikm, salt, info, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, okm) {
+		t.Fatalf("unexpected key %x", key)
+	}
+}
+
+func TestDeriveSessionKeyTooLong(t *testing.T) {
+	if _, err := DeriveSessionKey([]byte("shared secret"), nil, nil, 255*32+1); err == nil {
+		t.Fatal("expected an error for a key longer than HKDF can derive")
+	}
+}